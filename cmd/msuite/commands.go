@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/plexsysio/go-msuite/modules/auth"
+	"github.com/plexsysio/go-msuite/modules/config/loader"
+	"github.com/plexsysio/go-msuite/modules/diag/admin"
+	"github.com/plexsysio/go-msuite/modules/node/validate"
+	"github.com/plexsysio/go-msuite/modules/repo"
+	"github.com/plexsysio/go-msuite/modules/repo/fsrepo"
+)
+
+// env holds the global options and the Admin used by the commands
+type env struct {
+	root  string
+	api   string
+	token string
+
+	r repo.Repo
+}
+
+// admin returns the Admin for the running node if the API address is provided,
+// else the repository is opened
+func (e *env) admin() (admin.Admin, error) {
+	if e.api != "" {
+		return admin.NewClient(e.api, e.token), nil
+	}
+	if !fsrepo.IsInitialized(e.root) {
+		return nil, fmt.Errorf("repository not initialized at %s", e.root)
+	}
+	r, err := fsrepo.Open(e.root)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening repository, use -api if the node is running %w", err)
+	}
+	e.r = r
+	return admin.New(r, nil), nil
+}
+
+func (e *env) close() error {
+	if e.r != nil {
+		return e.r.Close()
+	}
+	return nil
+}
+
+func printJSON(val interface{}) error {
+	if s, ok := val.(string); ok {
+		fmt.Println(s)
+		return nil
+	}
+	buf, err := json.MarshalIndent(val, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(buf))
+	return nil
+}
+
+func checkArgs(args []string, n int, usage string) error {
+	if len(args) != n {
+		return fmt.Errorf("usage: msuite %s", usage)
+	}
+	return nil
+}
+
+func initCmd(_ context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	cfgFile := fs.String("config", "", "initial config file (json, yaml or toml)")
+	loader.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// Bool flags do not take a separate value, so "-use-http true" would stop
+	// parsing at "true" and ignore the flags after it
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %v, bool flags are set using -flag or -flag=false", fs.Args())
+	}
+
+	if fsrepo.IsInitialized(e.root) {
+		return fmt.Errorf("repository already initialized at %s", e.root)
+	}
+	sources := []loader.Source{loader.Defaults()}
+	if *cfgFile != "" {
+		sources = append(sources, loader.File(*cfgFile))
+	}
+	sources = append(sources, loader.Env("MSUITE"), loader.Flags(fs))
+	c, err := loader.Load(sources...)
+	if err != nil {
+		return err
+	}
+	c.Set("RootPath", e.root)
+	if err := validate.Config(c); err != nil {
+		return err
+	}
+	if err := fsrepo.Init(e.root, c); err != nil {
+		return err
+	}
+	id := map[string]interface{}{}
+	_ = c.Get("Identity", &id)
+	fmt.Printf("initialized repository at %s\npeer identity: %v\n", e.root, id["ID"])
+	return nil
+}
+
+func configCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: msuite config show|get|set")
+	}
+	a, err := e.admin()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "show":
+		vals, err := a.Config(ctx)
+		if err != nil {
+			return err
+		}
+		return printJSON(vals)
+	case "get":
+		if err := checkArgs(args, 2, "config get <key>"); err != nil {
+			return err
+		}
+		val, err := a.GetConfig(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		return printJSON(val)
+	case "set":
+		if err := checkArgs(args, 3, "config set <key> <value>"); err != nil {
+			return err
+		}
+		return a.SetConfig(ctx, args[1], args[2])
+	default:
+		return fmt.Errorf("unknown config command %q", args[0])
+	}
+}
+
+func idCmd(ctx context.Context, e *env, args []string) error {
+	if err := checkArgs(args, 0, "id"); err != nil {
+		return err
+	}
+	a, err := e.admin()
+	if err != nil {
+		return err
+	}
+	info, err := a.ID(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(info)
+}
+
+func repoCmd(ctx context.Context, e *env, args []string) error {
+	if err := checkArgs(args, 1, "repo stat"); err != nil {
+		return err
+	}
+	if args[0] != "stat" {
+		return fmt.Errorf("unknown repo command %q", args[0])
+	}
+	a, err := e.admin()
+	if err != nil {
+		return err
+	}
+	stat, err := a.RepoStat(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(stat)
+}
+
+func aclCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: msuite acl list|set|delete")
+	}
+	a, err := e.admin()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "list":
+		acls, err := a.ACL(ctx)
+		if err != nil {
+			return err
+		}
+		rscs := make([]string, 0, len(acls))
+		for k := range acls {
+			rscs = append(rscs, k)
+		}
+		sort.Strings(rscs)
+		for _, k := range rscs {
+			fmt.Printf("%s\t%s\n", k, acls[k])
+		}
+		return nil
+	case "set":
+		if err := checkArgs(args, 3, "acl set <resource> <role>"); err != nil {
+			return err
+		}
+		return a.SetACL(ctx, args[1], auth.Role(args[2]))
+	case "delete":
+		if err := checkArgs(args, 2, "acl delete <resource>"); err != nil {
+			return err
+		}
+		return a.DeleteACL(ctx, args[1])
+	default:
+		return fmt.Errorf("unknown acl command %q", args[0])
+	}
+}
+
+func peersCmd(ctx context.Context, e *env, args []string) error {
+	if err := checkArgs(args, 0, "peers"); err != nil {
+		return err
+	}
+	if e.api == "" {
+		return errors.New("peers needs a running node, use -api")
+	}
+	a, err := e.admin()
+	if err != nil {
+		return err
+	}
+	peers, err := a.Peers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range peers {
+		fmt.Println(p.ID, p.Addresses)
+	}
+	return nil
+}
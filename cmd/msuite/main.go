@@ -0,0 +1,97 @@
+// Command msuite is used to manage msuite repositories and nodes. Commands work
+// on the repository at the root path directly, or on a running node using its
+// admin handlers if the API address is provided.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	// The CLI validates configs using any of the optional implementations
+	_ "github.com/plexsysio/go-msuite/plugins/all"
+)
+
+const usage = `msuite is a tool to manage msuite repositories and nodes
+
+Usage:
+	msuite [global flags] <command> [arguments]
+
+Commands:
+	init                          initialize a new repository
+	config show                   show the config with the secrets redacted
+	config get <key>              show the value of the config key
+	config set <key> <value>      update the config key (secrets are set on init)
+	id                            show the identity of the node
+	repo stat                     show the repository mounts and disk usage
+	acl list                      list the ACLs
+	acl set <resource> <role>     configure the role for the resource
+	acl delete <resource>         delete the ACL for the resource
+	peers                         list the connected peers (needs -api)
+
+Global flags:
+`
+
+type command func(ctx context.Context, e *env, args []string) error
+
+var commands = map[string]command{
+	"init":   initCmd,
+	"config": configCmd,
+	"id":     idCmd,
+	"repo":   repoCmd,
+	"acl":    aclCmd,
+	"peers":  peersCmd,
+}
+
+// defaultRoot returns the repository root used if not provided
+func defaultRoot() string {
+	if p := os.Getenv("MSUITE_PATH"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".msuite"
+	}
+	return filepath.Join(home, ".msuite")
+}
+
+func main() {
+	e := &env{}
+
+	fs := flag.NewFlagSet("msuite", flag.ExitOnError)
+	fs.StringVar(&e.root, "repo", defaultRoot(), "root path of the repository (env MSUITE_PATH)")
+	fs.StringVar(&e.api, "api", os.Getenv("MSUITE_API"), "HTTP address of a running node (env MSUITE_API)")
+	fs.StringVar(&e.token, "token", os.Getenv("MSUITE_TOKEN"), "token used for the admin handlers (env MSUITE_TOKEN)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(os.Args[1:])
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	cmd, found := commands[fs.Arg(0)]
+	if !found {
+		names := make([]string, 0, len(commands))
+		for k := range commands {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "unknown command %q, available commands %v\n", fs.Arg(0), names)
+		os.Exit(2)
+	}
+
+	err := cmd(context.Background(), e, fs.Args()[1:])
+	if closeErr := e.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
@@ -16,9 +16,13 @@ import (
 	"github.com/plexsysio/dLocker"
 	store "github.com/plexsysio/gkvstore"
 	"github.com/plexsysio/go-msuite/modules/auth"
+	"github.com/plexsysio/go-msuite/modules/election"
 	"github.com/plexsysio/go-msuite/modules/events"
+	grpcclient "github.com/plexsysio/go-msuite/modules/grpc/client"
 	"github.com/plexsysio/go-msuite/modules/protocols"
+	"github.com/plexsysio/go-msuite/modules/queue"
 	"github.com/plexsysio/go-msuite/modules/repo"
+	"github.com/plexsysio/go-msuite/modules/scheduler"
 	"github.com/plexsysio/go-msuite/modules/sharedStorage"
 	"github.com/plexsysio/taskmanager"
 	"github.com/prometheus/client_golang/prometheus"
@@ -36,6 +40,11 @@ type Service interface {
 	Repo() repo.Repo
 	// TM uses taskmanager for async task scheduling within
 	TM() *taskmanager.TaskManager
+	// Scheduler runs periodic jobs on the taskmanager
+	Scheduler() scheduler.Scheduler
+	// Queue provides durable job queues processed by the taskmanager. Queues are
+	// saved in the repository store, or in the shared storage for queue.Shared
+	Queue(string, ...queue.Option) (queue.Queue, error)
 
 	// Auth is used to provide authorized access to resources
 	Auth() (Auth, error)
@@ -49,6 +58,9 @@ type Service interface {
 	HTTP() (HTTP, error)
 	// Locker provides access to the distributed locker configured if any
 	Locker() (dLocker.DLocker, error)
+	// Election provides leader election over the distributed locker. Elections
+	// with the same name are shared across the nodes using the same locker
+	Election(string) (election.Election, error)
 	// Events service can be used to broadcast/handle events in the form of messages
 	// using underlying PubSub
 	Events() (events.Events, error)
@@ -83,10 +95,11 @@ type Auth interface {
 }
 
 // GRPC provides the gRPC client-server implementations. Can be used to register services
-// or call other services already registered
+// or call other services already registered. The connections returned by Client are closed
+// by the callers, with the client cache enabled closing them only releases them
 type GRPC interface {
 	Server() *grpc.Server
-	Client(context.Context, string, ...grpc.DialOption) (*grpc.ClientConn, error)
+	Client(context.Context, string, ...grpc.DialOption) (*grpcclient.Conn, error)
 }
 
 // HTTP provides the standard HTTP multiplexer already configured with middlewares. This
@@ -0,0 +1,43 @@
+package coretest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/plexsysio/go-msuite/modules/auth"
+)
+
+// IssuedToken is a token generated by the JWT manager
+type IssuedToken struct {
+	User    auth.User
+	Timeout time.Duration
+	Token   string
+}
+
+// JWT is the JWT manager using the configured secret. The tokens generated are
+// captured, so the tests can use them
+type JWT struct {
+	auth.JWTManager
+
+	mtx    sync.Mutex
+	issued []IssuedToken
+}
+
+func (j *JWT) Generate(user auth.User, timeout time.Duration) (string, error) {
+	token, err := j.JWTManager.Generate(user, timeout)
+	if err != nil {
+		return "", err
+	}
+	j.mtx.Lock()
+	j.issued = append(j.issued, IssuedToken{User: user, Timeout: timeout, Token: token})
+	j.mtx.Unlock()
+	return token, nil
+}
+
+// Issued returns the tokens generated so far
+func (j *JWT) Issued() []IssuedToken {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	return append([]IssuedToken{}, j.issued...)
+}
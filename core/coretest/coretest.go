@@ -0,0 +1,322 @@
+// Package coretest provides a fake core.Service for unit testing the apps. The
+// subsystems are in-memory implementations, no sockets are used, and the fakes
+// can be inspected by the tests for the events broadcasted, the messages sent,
+// the tokens issued etc.
+package coretest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	ipfslite "github.com/hsanjuan/ipfs-lite"
+	"github.com/libp2p/go-libp2p-core/discovery"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/opentracing/opentracing-go"
+	"github.com/plexsysio/dLocker"
+	store "github.com/plexsysio/gkvstore"
+	"github.com/plexsysio/go-msuite/core"
+	"github.com/plexsysio/go-msuite/modules/auth"
+	"github.com/plexsysio/go-msuite/modules/config"
+	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/election"
+	"github.com/plexsysio/go-msuite/modules/events"
+	grpcclient "github.com/plexsysio/go-msuite/modules/grpc/client"
+	"github.com/plexsysio/go-msuite/modules/protocols"
+	"github.com/plexsysio/go-msuite/modules/queue"
+	"github.com/plexsysio/go-msuite/modules/repo"
+	"github.com/plexsysio/go-msuite/modules/repo/inmem"
+	"github.com/plexsysio/go-msuite/modules/scheduler"
+	"github.com/plexsysio/go-msuite/modules/sharedStorage"
+	"github.com/plexsysio/taskmanager"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// ErrFilesNotSupported is returned by Files as ipfs-lite needs a real network
+var ErrFilesNotSupported = errors.New("files not supported by the fake service")
+
+// defaultSecret is used for the JWT tokens if the config has no secret
+const defaultSecret = "coretest"
+
+var (
+	_ core.Service = (*Service)(nil)
+	_ core.P2P     = (*Service)(nil)
+	_ core.GRPC    = (*Service)(nil)
+	_ core.HTTP    = (*Service)(nil)
+	_ core.Auth    = (*Service)(nil)
+)
+
+// Option configures the fake service
+type Option func(*Service)
+
+// WithConfig uses the config for the repository. JWTSecret is set if missing
+func WithConfig(c config.Config) Option {
+	return func(s *Service) {
+		s.cfg = c
+	}
+}
+
+// Service is the fake core.Service. All the subsystems are available, along
+// with the fakes which can be used to inspect them
+type Service struct {
+	// FakeEvents is returned by Events
+	FakeEvents *Events
+	// FakeProtocols is returned by Protocols
+	FakeProtocols *Protocols
+	// FakeStorage provides the stores returned by SharedStorage
+	FakeStorage *SharedStorage
+	// FakeLocker is returned by Locker
+	FakeLocker *Locker
+	// FakeJWT is the JWT manager returned by Auth
+	FakeJWT *JWT
+	// FakeDiscovery is the discovery returned by P2P
+	FakeDiscovery *Discovery
+
+	cfg    config.Config
+	r      repo.Repo
+	tm     *taskmanager.TaskManager
+	mn     mocknet.Mocknet
+	h      host.Host
+	ps     *pubsub.PubSub
+	acl    auth.ACL
+	el     *election.Manager
+	sch    *scheduler.Runner
+	qm     *queue.Manager
+	srv    *grpc.Server
+	lis    *bufconn.Listener
+	mux    *http.ServeMux
+	gw     *runtime.ServeMux
+	reg    *prometheus.Registry
+	done   chan os.Signal
+	cancel context.CancelFunc
+
+	mtx     sync.Mutex
+	clients map[string]*grpc.ClientConn
+	dialed  []string
+}
+
+// New creates the fake service. The gRPC server is served in memory once the
+// service is started
+func New(opts ...Option) (*Service, error) {
+	s := &Service{
+		cfg:     jsonConf.DefaultConfig(),
+		tm:      taskmanager.New(0, 20, 15*time.Second),
+		mn:      mocknet.New(),
+		srv:     grpc.NewServer(),
+		lis:     bufconn.Listen(1024 * 1024),
+		mux:     http.NewServeMux(),
+		gw:      runtime.NewServeMux(),
+		reg:     prometheus.NewRegistry(),
+		done:    make(chan os.Signal, 1),
+		clients: make(map[string]*grpc.ClientConn),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if !s.cfg.Exists("JWTSecret") {
+		s.cfg.Set("JWTSecret", defaultSecret)
+	}
+	s.mux.Handle("/", s.gw)
+
+	var err error
+	s.r, err = inmem.CreateOrOpen(s.cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.acl, err = auth.NewAclManager(s.r, nil)
+	if err != nil {
+		return nil, err
+	}
+	jwtMgr, err := auth.NewJWTManager(s.r.Config())
+	if err != nil {
+		return nil, err
+	}
+	s.h, err = s.mn.GenPeer()
+	if err != nil {
+		return nil, err
+	}
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.ps, err = pubsub.NewGossipSub(ctx, s.h)
+	if err != nil {
+		s.cancel()
+		return nil, err
+	}
+
+	s.FakeEvents = NewEvents()
+	s.FakeProtocols = NewProtocols()
+	s.FakeStorage = NewSharedStorage()
+	s.FakeLocker = NewLocker()
+	s.FakeJWT = &JWT{JWTManager: jwtMgr}
+	s.FakeDiscovery = NewDiscovery(peer.AddrInfo{ID: s.h.ID(), Addrs: s.h.Addrs()})
+
+	recs, err := s.FakeStorage.SharedStorage("/election", nil)
+	if err != nil {
+		s.cancel()
+		return nil, err
+	}
+	s.el = election.NewManager(s.FakeLocker, recs, s.h.ID().Pretty(), settings.DefaultElectionLease)
+	s.sch = scheduler.NewRunner(s.tm, s.FakeLocker)
+
+	shared, err := s.FakeStorage.SharedStorage("/queue", nil)
+	if err != nil {
+		s.cancel()
+		return nil, err
+	}
+	s.qm = queue.NewManager(s.tm, s.r.Store(), shared, s.FakeLocker, s.h.ID().Pretty())
+	return s, nil
+}
+
+// Start serves the gRPC server in memory and starts the scheduler. Services should
+// be registered on the server before this
+func (s *Service) Start(_ context.Context) error {
+	go func() {
+		_ = s.srv.Serve(s.lis)
+	}()
+	return s.sch.Start()
+}
+
+func (s *Service) Stop(ctx context.Context) error {
+	_ = s.el.Close(ctx)
+	_ = s.sch.Stop(ctx)
+	_ = s.qm.Close(ctx)
+	s.srv.Stop()
+	s.mtx.Lock()
+	for _, conn := range s.clients {
+		conn.Close()
+	}
+	s.mtx.Unlock()
+	s.tm.Stop()
+	s.cancel()
+	_ = s.FakeLocker.Close()
+	_ = s.mn.Close()
+	return s.r.Close()
+}
+
+// Done returns the channel on which the signals are received. Tests can send on
+// it using Signal
+func (s *Service) Done() <-chan os.Signal {
+	return s.done
+}
+
+// Signal sends the signal on the Done channel
+func (s *Service) Signal(sig os.Signal) {
+	s.done <- sig
+}
+
+func (s *Service) Repo() repo.Repo { return s.r }
+
+func (s *Service) TM() *taskmanager.TaskManager { return s.tm }
+
+func (s *Service) Scheduler() scheduler.Scheduler { return s.sch }
+
+func (s *Service) Queue(name string, opts ...queue.Option) (queue.Queue, error) {
+	return s.qm.Queue(name, opts...)
+}
+
+func (s *Service) Auth() (core.Auth, error) { return s, nil }
+
+func (s *Service) JWT() auth.JWTManager { return s.FakeJWT }
+
+func (s *Service) ACL() auth.ACL { return s.acl }
+
+func (s *Service) P2P() (core.P2P, error) { return s, nil }
+
+func (s *Service) Host() host.Host { return s.h }
+
+// Routing returns a router which finds nothing
+func (s *Service) Routing() routing.Routing { return routinghelpers.Null{} }
+
+func (s *Service) Discovery() discovery.Discovery { return s.FakeDiscovery }
+
+func (s *Service) Pubsub() *pubsub.PubSub { return s.ps }
+
+func (s *Service) GRPC() (core.GRPC, error) { return s, nil }
+
+func (s *Service) Server() *grpc.Server { return s.srv }
+
+// Client returns the connection set for the service using SetClient. Otherwise
+// the connection is to the in-memory gRPC server of the fake
+func (s *Service) Client(
+	ctx context.Context,
+	name string,
+	opts ...grpc.DialOption,
+) (*grpcclient.Conn, error) {
+	s.mtx.Lock()
+	s.dialed = append(s.dialed, name)
+	conn, found := s.clients[name]
+	s.mtx.Unlock()
+
+	if found {
+		// Closed when the fake is stopped
+		return grpcclient.NewConn(conn, func() error { return nil }), nil
+	}
+	opts = append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, opts...)
+	conn, err := grpc.DialContext(ctx, "bufnet", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return grpcclient.NewConn(conn, nil), nil
+}
+
+// SetClient sets the connection returned for the service. It is closed when the
+// fake is stopped
+func (s *Service) SetClient(name string, conn *grpc.ClientConn) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.clients[name] = conn
+}
+
+// Dialed returns the names of the services for which clients were requested
+func (s *Service) Dialed() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]string{}, s.dialed...)
+}
+
+func (s *Service) HTTP() (core.HTTP, error) { return s, nil }
+
+func (s *Service) Mux() *http.ServeMux { return s.mux }
+
+func (s *Service) Gateway() *runtime.ServeMux { return s.gw }
+
+func (s *Service) Locker() (dLocker.DLocker, error) { return s.FakeLocker, nil }
+
+// Election uses the fake locker and storage. The leader records can be seen in
+// the "election" namespace of the storage
+func (s *Service) Election(name string) (election.Election, error) { return s.el.Election(name) }
+
+func (s *Service) Events() (events.Events, error) { return s.FakeEvents, nil }
+
+func (s *Service) Protocols() (protocols.ProtocolsSvc, error) { return s.FakeProtocols, nil }
+
+func (s *Service) SharedStorage(ns string, cb sharedStorage.Callback) (store.Store, error) {
+	return s.FakeStorage.SharedStorage(ns, cb)
+}
+
+func (s *Service) Files() (*ipfslite.Peer, error) { return nil, ErrFilesNotSupported }
+
+func (s *Service) Tracing() (opentracing.Tracer, error) { return opentracing.NoopTracer{}, nil }
+
+func (s *Service) Metrics() (*prometheus.Registry, error) { return s.reg, nil }
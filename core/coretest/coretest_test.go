@@ -0,0 +1,353 @@
+package coretest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	store "github.com/plexsysio/gkvstore"
+	"github.com/plexsysio/go-msuite/core/coretest"
+	"github.com/plexsysio/go-msuite/modules/events"
+	"github.com/plexsysio/go-msuite/modules/protocols"
+	"github.com/plexsysio/go-msuite/modules/queue"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type testEvent struct {
+	Msg string
+}
+
+func (t *testEvent) Topic() string { return "test" }
+
+func (t *testEvent) Marshal() ([]byte, error) { return json.Marshal(t) }
+
+func (t *testEvent) Unmarshal(buf []byte) error { return json.Unmarshal(buf, t) }
+
+type testProto struct {
+	send protocols.Sender
+}
+
+func (testProto) ID() protocol.ID { return "/test/1.0.0" }
+
+func (testProto) HandleMsg(req protocols.Request, _ peer.ID) (protocols.Response, error) {
+	return &testEvent{Msg: req.(*testEvent).Msg + " resp"}, nil
+}
+
+func (p *testProto) SetSender(s protocols.Sender) { p.send = s }
+
+func (testProto) ReqFactory() protocols.Request { return new(testEvent) }
+
+func (testProto) RespFactory() protocols.Response { return new(testEvent) }
+
+type testItem struct {
+	ID  string
+	Val string
+}
+
+func (t *testItem) GetNamespace() string { return "items" }
+
+func (t *testItem) GetID() string { return t.ID }
+
+func (t *testItem) Marshal() ([]byte, error) { return json.Marshal(t) }
+
+func (t *testItem) Unmarshal(buf []byte) error { return json.Unmarshal(buf, t) }
+
+type testCallback struct {
+	puts, deletes []string
+}
+
+func (t *testCallback) Put(key string) { t.puts = append(t.puts, key) }
+
+func (t *testCallback) Delete(key string) { t.deletes = append(t.deletes, key) }
+
+type testUser struct{}
+
+func (testUser) ID() string { return "user" }
+
+func (testUser) Role() string { return "admin" }
+
+func (testUser) Mtdt() map[string]interface{} { return nil }
+
+func newService(t *testing.T) *coretest.Service {
+	t.Helper()
+
+	svc, err := coretest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := svc.Stop(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return svc
+}
+
+func TestEvents(t *testing.T) {
+	svc := newService(t)
+
+	ev, err := svc.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recvd []string
+	ev.RegisterHandler(func() events.Event { return new(testEvent) }, func(e events.Event) {
+		recvd = append(recvd, e.(*testEvent).Msg)
+	})
+
+	err = ev.Broadcast(context.Background(), &testEvent{Msg: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = svc.FakeEvents.Deliver(&testEvent{Msg: "remote"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recvd) != 2 || recvd[0] != "hello" || recvd[1] != "remote" {
+		t.Fatal("incorrect events received", recvd)
+	}
+	if b := svc.FakeEvents.Broadcasts(); len(b) != 1 || b[0].(*testEvent).Msg != "hello" {
+		t.Fatal("incorrect broadcasts", b)
+	}
+}
+
+func TestProtocols(t *testing.T) {
+	svc := newService(t)
+
+	ps, err := svc.Protocols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProto{}
+	ps.Register(p)
+
+	resp, err := svc.FakeProtocols.Receive(p.ID(), "peer", &testEvent{Msg: "req"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.(*testEvent).Msg != "req resp" {
+		t.Fatal("incorrect response", resp)
+	}
+
+	_, err = p.send(context.Background(), "peer", &testEvent{Msg: "out"})
+	if !errors.Is(err, coretest.ErrNoResponder) {
+		t.Fatal("expected no responder", err)
+	}
+	svc.FakeProtocols.SetResponder(p.ID(), func(_ peer.ID, req protocols.Request) (protocols.Response, error) {
+		return &testEvent{Msg: "ack"}, nil
+	})
+	resp, err = p.send(context.Background(), "peer", &testEvent{Msg: "out"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.(*testEvent).Msg != "ack" {
+		t.Fatal("incorrect response", resp)
+	}
+	sent := svc.FakeProtocols.Sent()
+	if len(sent) != 2 || sent[1].Peer != "peer" || sent[1].Req.(*testEvent).Msg != "out" {
+		t.Fatal("incorrect sent messages", sent)
+	}
+}
+
+func TestSharedStorage(t *testing.T) {
+	svc := newService(t)
+
+	cb := &testCallback{}
+	st, err := svc.SharedStorage("/items", cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := &testItem{ID: "1", Val: "val"}
+	if err := st.Create(context.Background(), item); err != nil {
+		t.Fatal(err)
+	}
+	read := &testItem{ID: "1"}
+	if err := st.Read(context.Background(), read); err != nil {
+		t.Fatal(err)
+	}
+	if read.Val != "val" {
+		t.Fatal("incorrect value", read)
+	}
+	if err := st.Delete(context.Background(), item); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Read(context.Background(), read); !errors.Is(err, store.ErrRecordNotFound) {
+		t.Fatal("expected not found", err)
+	}
+	if len(cb.puts) != 1 || cb.puts[0] != "/items/1" || len(cb.deletes) != 1 {
+		t.Fatal("incorrect callbacks", cb)
+	}
+}
+
+func TestLocker(t *testing.T) {
+	svc := newService(t)
+
+	lk, err := svc.Locker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lk.TryLock(context.Background(), "key", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !svc.FakeLocker.Held("key") {
+		t.Fatal("expected lock to be held")
+	}
+	unlock()
+	if svc.FakeLocker.Held("key") {
+		t.Fatal("expected lock to be released")
+	}
+}
+
+func TestElection(t *testing.T) {
+	svc := newService(t)
+
+	el, err := svc.Election("leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := el.Campaign(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !el.IsLeader() || !svc.FakeLocker.Held("election/leader") {
+		t.Fatal("expected to be the leader")
+	}
+	if err := el.Resign(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if el.IsLeader() || svc.FakeLocker.Held("election/leader") {
+		t.Fatal("expected leadership to be given up")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	svc := newService(t)
+
+	a, err := svc.Auth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := a.JWT().Generate(testUser{}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := a.JWT().Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.ID != "user" {
+		t.Fatal("incorrect claims", claims)
+	}
+	issued := svc.FakeJWT.Issued()
+	if len(issued) != 1 || issued[0].Token != token {
+		t.Fatal("incorrect issued tokens", issued)
+	}
+}
+
+func TestP2PAndGRPC(t *testing.T) {
+	svc := newService(t)
+
+	p2p, err := svc.P2P()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p2p.Discovery().Advertise(context.Background(), "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.FakeDiscovery.AddProvider("svc", peer.AddrInfo{ID: "other"})
+	found, err := p2p.Discovery().FindPeers(context.Background(), "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var peers []peer.ID
+	for p := range found {
+		peers = append(peers, p.ID)
+	}
+	if len(peers) != 2 || peers[0] != p2p.Host().ID() || peers[1] != "other" {
+		t.Fatal("incorrect peers", peers)
+	}
+
+	g, err := svc.GRPC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthpb.RegisterHealthServer(g.Server(), health.NewServer())
+	if err := svc.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := g.Client(context.Background(), "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatal("incorrect status", resp.Status)
+	}
+	if d := svc.Dialed(); len(d) != 1 || d[0] != "svc" {
+		t.Fatal("incorrect dialed services", d)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	svc := newService(t)
+
+	ran := make(chan struct{}, 1)
+	err := svc.Scheduler().Every("job", 10*time.Millisecond, func(context.Context) error {
+		select {
+		case ran <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not run")
+	}
+}
+
+func TestQueue(t *testing.T) {
+	svc := newService(t)
+
+	q, err := svc.Queue("jobs", queue.Shared())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvd := make(chan string, 1)
+	err = q.Handle(func(_ context.Context, j *queue.Job) error {
+		recvd <- string(j.Payload)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue(context.Background(), []byte("job")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-recvd:
+		if p != "job" {
+			t.Fatal("incorrect job", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job not processed")
+	}
+}
@@ -0,0 +1,88 @@
+package coretest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/discovery"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// defaultTTL is returned on Advertise if no TTL is provided
+const defaultTTL = 3 * time.Hour
+
+// Discovery is the in-memory discovery. Advertised services are found on the
+// node itself and the tests can add other providers using AddProvider
+type Discovery struct {
+	self peer.AddrInfo
+
+	mtx        sync.Mutex
+	providers  map[string][]peer.AddrInfo
+	advertised []string
+}
+
+// NewDiscovery returns the in-memory discovery for the node
+func NewDiscovery(self peer.AddrInfo) *Discovery {
+	return &Discovery{
+		self:      self,
+		providers: make(map[string][]peer.AddrInfo),
+	}
+}
+
+func (d *Discovery) Advertise(_ context.Context, ns string, opts ...discovery.Option) (time.Duration, error) {
+	var options discovery.Options
+	if err := options.Apply(opts...); err != nil {
+		return 0, err
+	}
+	d.mtx.Lock()
+	d.advertised = append(d.advertised, ns)
+	d.mtx.Unlock()
+
+	d.AddProvider(ns, d.self)
+	if options.Ttl == 0 {
+		return defaultTTL, nil
+	}
+	return options.Ttl, nil
+}
+
+func (d *Discovery) FindPeers(_ context.Context, ns string, opts ...discovery.Option) (<-chan peer.AddrInfo, error) {
+	var options discovery.Options
+	if err := options.Apply(opts...); err != nil {
+		return nil, err
+	}
+	d.mtx.Lock()
+	providers := d.providers[ns]
+	d.mtx.Unlock()
+
+	if options.Limit > 0 && len(providers) > options.Limit {
+		providers = providers[:options.Limit]
+	}
+	ch := make(chan peer.AddrInfo, len(providers))
+	for _, p := range providers {
+		ch <- p
+	}
+	close(ch)
+	return ch, nil
+}
+
+// AddProvider adds the peer as a provider of the service
+func (d *Discovery) AddProvider(ns string, p peer.AddrInfo) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	for _, v := range d.providers[ns] {
+		if v.ID == p.ID {
+			return
+		}
+	}
+	d.providers[ns] = append(d.providers[ns], p)
+}
+
+// Advertised returns the services advertised so far
+func (d *Discovery) Advertised() []string {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return append([]string{}, d.advertised...)
+}
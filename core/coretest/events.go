@@ -0,0 +1,84 @@
+package coretest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/plexsysio/go-msuite/modules/events"
+)
+
+type evHandler struct {
+	factory events.Factory
+	handle  events.Handle
+}
+
+// Events is the in-memory events service. Broadcasted events are captured and
+// delivered to the local handlers like the events received from pubsub
+type Events struct {
+	mtx        sync.Mutex
+	handlers   map[string][]evHandler
+	broadcasts []events.Event
+}
+
+// NewEvents returns the in-memory events service
+func NewEvents() *Events {
+	return &Events{handlers: make(map[string][]evHandler)}
+}
+
+func (e *Events) RegisterHandler(factory events.Factory, handle events.Handle) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	topic := factory().Topic()
+	e.handlers[topic] = append(e.handlers[topic], evHandler{factory: factory, handle: handle})
+}
+
+// Broadcast captures the event and delivers it to the handlers of the topic
+func (e *Events) Broadcast(_ context.Context, ev events.Event) error {
+	e.mtx.Lock()
+	e.broadcasts = append(e.broadcasts, ev)
+	e.mtx.Unlock()
+
+	return e.Deliver(ev)
+}
+
+// Deliver calls the handlers of the topic as if the event was received from some
+// other node. The handlers get a copy created using the factory, so the event is
+// marshaled like on the network
+func (e *Events) Deliver(ev events.Event) error {
+	e.mtx.Lock()
+	handlers := append([]evHandler{}, e.handlers[ev.Topic()]...)
+	e.mtx.Unlock()
+
+	for _, h := range handlers {
+		recvd := h.factory()
+		if err := roundTrip(ev, recvd); err != nil {
+			return err
+		}
+		h.handle(recvd)
+	}
+	return nil
+}
+
+// Broadcasts returns the events broadcasted so far
+func (e *Events) Broadcasts() []events.Event {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	return append([]events.Event{}, e.broadcasts...)
+}
+
+// message is the serializable type used by events, protocols and stores
+type message interface {
+	Marshal() ([]byte, error)
+	Unmarshal([]byte) error
+}
+
+// roundTrip copies the message by marshaling it
+func roundTrip(from, to message) error {
+	buf, err := from.Marshal()
+	if err != nil {
+		return err
+	}
+	return to.Unmarshal(buf)
+}
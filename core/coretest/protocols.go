@@ -0,0 +1,102 @@
+package coretest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/plexsysio/go-msuite/modules/protocols"
+)
+
+// ErrNoResponder is returned by the sender if no responder is set for the protocol
+var ErrNoResponder = errors.New("no responder for protocol")
+
+// Responder handles the requests sent by the protocol to other peers
+type Responder func(peer.ID, protocols.Request) (protocols.Response, error)
+
+// SentMsg is a request sent by the protocol
+type SentMsg struct {
+	Protocol protocol.ID
+	Peer     peer.ID
+	Req      protocols.Request
+}
+
+// Protocols is the in-memory protocols service. The requests sent by protocols
+// are captured and answered by the responders set by the test. Incoming requests
+// can be simulated using Receive
+type Protocols struct {
+	mtx        sync.Mutex
+	protos     map[protocol.ID]protocols.Protocol
+	responders map[protocol.ID]Responder
+	sent       []SentMsg
+}
+
+// NewProtocols returns the in-memory protocols service
+func NewProtocols() *Protocols {
+	return &Protocols{
+		protos:     make(map[protocol.ID]protocols.Protocol),
+		responders: make(map[protocol.ID]Responder),
+	}
+}
+
+func (p *Protocols) Register(proto protocols.Protocol) {
+	p.mtx.Lock()
+	p.protos[proto.ID()] = proto
+	p.mtx.Unlock()
+
+	proto.SetSender(func(_ context.Context, to peer.ID, req protocols.Request) (protocols.Response, error) {
+		p.mtx.Lock()
+		p.sent = append(p.sent, SentMsg{Protocol: proto.ID(), Peer: to, Req: req})
+		responder, found := p.responders[proto.ID()]
+		p.mtx.Unlock()
+
+		if !found {
+			return nil, ErrNoResponder
+		}
+		resp, err := responder(to, req)
+		if err != nil {
+			return nil, err
+		}
+		recvd := proto.RespFactory()
+		if err := roundTrip(resp, recvd); err != nil {
+			return nil, err
+		}
+		return recvd, nil
+	})
+}
+
+// SetResponder sets the handler for the requests sent by the protocol
+func (p *Protocols) SetResponder(id protocol.ID, r Responder) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.responders[id] = r
+}
+
+// Receive calls the registered protocol as if the request was received from
+// the peer
+func (p *Protocols) Receive(id protocol.ID, from peer.ID, req protocols.Request) (protocols.Response, error) {
+	p.mtx.Lock()
+	proto, found := p.protos[id]
+	p.mtx.Unlock()
+
+	if !found {
+		return nil, fmt.Errorf("protocol %s not registered", id)
+	}
+	recvd := proto.ReqFactory()
+	if err := roundTrip(req, recvd); err != nil {
+		return nil, err
+	}
+	return proto.HandleMsg(recvd, from)
+}
+
+// Sent returns the requests sent so far
+func (p *Protocols) Sent() []SentMsg {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return append([]SentMsg{}, p.sent...)
+}
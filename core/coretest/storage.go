@@ -0,0 +1,153 @@
+package coretest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plexsysio/dLocker"
+	"github.com/plexsysio/dLocker/handlers/memlock"
+	store "github.com/plexsysio/gkvstore"
+	"github.com/plexsysio/gkvstore/inmem"
+	syncstore "github.com/plexsysio/gkvstore/sync"
+	"github.com/plexsysio/go-msuite/modules/sharedStorage"
+)
+
+// SharedStorage is the in-memory shared storage provider. Like the CRDT store,
+// all the namespaces share the same store and the callbacks are called for the
+// keys with the namespace as prefix
+type SharedStorage struct {
+	st store.Store
+
+	mtx       sync.RWMutex
+	callbacks map[string][]sharedStorage.Callback
+}
+
+// NewSharedStorage returns the in-memory shared storage provider
+func NewSharedStorage() *SharedStorage {
+	return &SharedStorage{
+		st:        syncstore.New(inmem.New()),
+		callbacks: make(map[string][]sharedStorage.Callback),
+	}
+}
+
+func (s *SharedStorage) SharedStorage(ns string, cb sharedStorage.Callback) (store.Store, error) {
+	if cb != nil {
+		s.mtx.Lock()
+		s.callbacks[ns] = append(s.callbacks[ns], cb)
+		s.mtx.Unlock()
+	}
+	return &notifyingStore{Store: s.st, s: s}, nil
+}
+
+// Put calls the callbacks as if the key was updated on some other node
+func (s *SharedStorage) Put(key string) {
+	s.notify(key, sharedStorage.Callback.Put)
+}
+
+// Delete calls the callbacks as if the key was deleted on some other node
+func (s *SharedStorage) Delete(key string) {
+	s.notify(key, sharedStorage.Callback.Delete)
+}
+
+func (s *SharedStorage) notify(key string, fn func(sharedStorage.Callback, string)) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	for ns, cbs := range s.callbacks {
+		if strings.HasPrefix(key, ns) {
+			for _, cb := range cbs {
+				fn(cb, key)
+			}
+		}
+	}
+}
+
+// Key returns the key used in the callbacks for the item
+func Key(item store.Item) string {
+	return fmt.Sprintf("/%s/%s", item.GetNamespace(), item.GetID())
+}
+
+// notifyingStore calls the callbacks on updates like the CRDT store
+type notifyingStore struct {
+	store.Store
+	s *SharedStorage
+}
+
+func (n *notifyingStore) Create(ctx context.Context, item store.Item) error {
+	if err := n.Store.Create(ctx, item); err != nil {
+		return err
+	}
+	n.s.Put(Key(item))
+	return nil
+}
+
+func (n *notifyingStore) Update(ctx context.Context, item store.Item) error {
+	if err := n.Store.Update(ctx, item); err != nil {
+		return err
+	}
+	n.s.Put(Key(item))
+	return nil
+}
+
+func (n *notifyingStore) Delete(ctx context.Context, item store.Item) error {
+	if err := n.Store.Delete(ctx, item); err != nil {
+		return err
+	}
+	n.s.Delete(Key(item))
+	return nil
+}
+
+// Close is a no-op as the store is shared by all the namespaces
+func (n *notifyingStore) Close() error {
+	return nil
+}
+
+// Locker is the in-memory locker which tracks the locks held
+type Locker struct {
+	dLocker.DLocker
+
+	mtx  sync.Mutex
+	held map[string]int
+}
+
+// NewLocker returns the in-memory locker
+func NewLocker() *Locker {
+	return &Locker{
+		DLocker: memlock.NewLocker(),
+		held:    make(map[string]int),
+	}
+}
+
+func (l *Locker) TryLock(ctx context.Context, key string, d time.Duration) (func(), error) {
+	unlock, err := l.DLocker.TryLock(ctx, key, d)
+	if err != nil {
+		return nil, err
+	}
+	l.mtx.Lock()
+	l.held[key]++
+	l.mtx.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mtx.Lock()
+			l.held[key]--
+			if l.held[key] == 0 {
+				delete(l.held, key)
+			}
+			l.mtx.Unlock()
+			unlock()
+		})
+	}, nil
+}
+
+// Held returns true if the lock on the key is held
+func (l *Locker) Held(key string) bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.held[key] > 0
+}
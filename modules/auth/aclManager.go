@@ -4,12 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 
+	"github.com/hashicorp/go-multierror"
+	logger "github.com/ipfs/go-log/v2"
 	store "github.com/plexsysio/gkvstore"
+	"github.com/plexsysio/go-msuite/modules/config"
 	"github.com/plexsysio/go-msuite/modules/repo"
 	"github.com/plexsysio/go-msuite/modules/sharedStorage"
+	"go.uber.org/fx"
 )
 
+var log = logger.Logger("auth")
+
 type Role string
 
 type ACL interface {
@@ -55,6 +62,25 @@ var raclMap = map[int]Role{
 	admin:     Admin,
 }
 
+// ValidRole checks if the role is one of the known roles
+func ValidRole(role Role) bool {
+	_, ok := aclMap[role]
+	return ok
+}
+
+// ValidateACL checks the roles used in the ACL config
+func ValidateACL(c config.Config) error {
+	acls := map[string]string{}
+	_ = c.Get("ACL", &acls)
+	var errs *multierror.Error
+	for k, v := range acls {
+		if !ValidRole(Role(v)) {
+			errs = multierror.Append(errs, fmt.Errorf("ACL: invalid role %q for %s", v, k))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
 type Acl struct {
 	Key   string
 	Roles int
@@ -107,6 +133,45 @@ func NewAclManager(r repo.Repo, shStore sharedStorage.Provider) (ACL, error) {
 	return am, nil
 }
 
+// WatchACL updates the ACLs on config updates until the node is stopped
+func WatchACL(lc fx.Lifecycle, r repo.Repo, acl ACL) {
+	am, ok := acl.(*aclManager)
+	if !ok {
+		return
+	}
+	unsubscribe := repo.Subscribe(r, am.onConfigChange, "ACL")
+	lc.Append(fx.Hook{
+		OnStop: func(_ context.Context) error {
+			unsubscribe()
+			return nil
+		},
+	})
+}
+
+// onConfigChange updates the ACLs when the ACL config is updated. Entries removed
+// from the config are deleted
+func (a *aclManager) onConfigChange(ch repo.ConfigChange) {
+	oldAcls, newAcls := map[string]string{}, map[string]string{}
+	_ = ch.Old(&oldAcls)
+	_ = ch.New(&newAcls)
+
+	for k := range oldAcls {
+		if _, found := newAcls[k]; !found {
+			if err := a.Delete(context.Background(), k); err != nil {
+				log.Warnf("failed deleting ACL %s: %v", k, err)
+			}
+		}
+	}
+	for k, v := range newAcls {
+		if oldAcls[k] == v {
+			continue
+		}
+		if err := a.Configure(context.Background(), k, Role(v)); err != nil {
+			log.Warnf("failed updating ACL %s: %v", k, err)
+		}
+	}
+}
+
 func (a *aclManager) Configure(ctx context.Context, rsc string, role Role) error {
 	r, ok := aclMap[role]
 	if !ok {
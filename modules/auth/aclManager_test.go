@@ -7,6 +7,7 @@ import (
 	"github.com/plexsysio/go-msuite/modules/auth"
 	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
 	"github.com/plexsysio/go-msuite/modules/repo/inmem"
+	"go.uber.org/fx/fxtest"
 )
 
 func TestNewAclManager(t *testing.T) {
@@ -103,3 +104,58 @@ func TestACLLifecycle(t *testing.T) {
 		t.Fatal("Expected authorization for ACL", auth.AuthWrite)
 	}
 }
+
+func TestACLConfigUpdate(t *testing.T) {
+	cfg := jsonConf.DefaultConfig()
+	cfg.Set("ACL", map[string]string{
+		"res1": "admin",
+		"res2": "admin",
+	})
+	r, err := inmem.CreateOrOpen(cfg)
+	if err != nil {
+		t.Fatal("failed creating repo", err)
+	}
+	defer r.Close()
+
+	am, err := auth.NewAclManager(r, nil)
+	if err != nil {
+		t.Fatal("Failed creating new acl manager", err.Error())
+	}
+	lc := fxtest.NewLifecycle(t)
+	auth.WatchACL(lc, r, am)
+	lc.RequireStart()
+
+	newCfg := jsonConf.DefaultConfig()
+	newCfg.Set("ACL", map[string]string{
+		"res2": "public_read",
+		"res3": "admin",
+	})
+	err = r.SetConfig(newCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !am.Authorized(context.TODO(), "res1", auth.PublicRead) {
+		t.Fatal("expected removed ACL to be deleted")
+	}
+	if !am.Authorized(context.TODO(), "res2", auth.PublicRead) {
+		t.Fatal("expected updated ACL to allow public_read")
+	}
+	if am.Authorized(context.TODO(), "res3", auth.AuthWrite) {
+		t.Fatal("expected new ACL to be configured")
+	}
+
+	// Updates are not applied once stopped
+	lc.RequireStop()
+	newCfg = jsonConf.DefaultConfig()
+	newCfg.Set("ACL", map[string]string{
+		"res4": "admin",
+	})
+	err = r.SetConfig(newCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !am.Authorized(context.TODO(), "res4", auth.AuthWrite) {
+		t.Fatal("expected ACL update after stop to be ignored")
+	}
+}
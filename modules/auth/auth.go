@@ -12,4 +12,5 @@ var Module = fx.Options(
 			fx.ParamTags(``, `optional:"true"`),
 		),
 	),
+	fx.Invoke(WatchACL),
 )
@@ -0,0 +1,235 @@
+// Package certs loads the TLS certificates of the node. The files are checked
+// for changes during the handshakes, so that the certificates can be rotated
+// without restarting the node. The certificate can also be derived from the
+// libp2p identity, in which case the peers are verified using their peer IDs
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	logger "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	libp2ptls "github.com/libp2p/go-libp2p-tls"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+)
+
+var log = logger.Logger("certs")
+
+// reloadInterval is the minimum interval between the checks for changes
+const reloadInterval = time.Second
+
+// Store keeps the certificate and the client CA loaded from disk, or the
+// certificate of the libp2p identity
+type Store struct {
+	cfg    settings.TLS
+	idCert *tls.Certificate
+
+	mtx       sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  []time.Time
+	checked   time.Time
+}
+
+// New loads the certificates configured
+func New(cfg settings.TLS) (*Store, error) {
+	if cfg.Cert == "" || cfg.Key == "" {
+		return nil, errors.New("TLS certificate and key not provided")
+	}
+	s := &Store{cfg: cfg}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewIdentity returns the store using the self-signed certificate derived from
+// the libp2p key, as done by the libp2p TLS transport. No CA is used, the peer ID
+// of the server is verified by the clients instead
+func NewIdentity(priv crypto.PrivKey) (*Store, error) {
+	id, err := libp2ptls.NewIdentity(priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating TLS certificate from identity: %w", err)
+	}
+	cfg, _ := id.ConfigForPeer("")
+	return &Store{idCert: &cfg.Certificates[0]}, nil
+}
+
+// verifyPeer checks the certificate is a libp2p certificate of the peer. Any
+// peer is accepted if the remote is empty
+func verifyPeer(remote peer.ID) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		chain := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			chain[i] = cert
+		}
+		pubKey, err := libp2ptls.PubKeyFromCertChain(chain)
+		if err != nil {
+			return err
+		}
+		if remote != "" && !remote.MatchesPublicKey(pubKey) {
+			found, err := peer.IDFromPublicKey(pubKey)
+			if err != nil {
+				return fmt.Errorf("peer ID mismatch, expected %s: %w", remote, err)
+			}
+			return fmt.Errorf("peer ID mismatch, expected %s found %s", remote, found)
+		}
+		return nil
+	}
+}
+
+func (s *Store) files() []string {
+	files := []string{s.cfg.Cert, s.cfg.Key}
+	if s.cfg.ClientCA != "" {
+		files = append(files, s.cfg.ClientCA)
+	}
+	return files
+}
+
+func (s *Store) load() error {
+	var modTimes []time.Time
+	for _, f := range s.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes = append(modTimes, fi.ModTime())
+	}
+	cert, err := tls.LoadX509KeyPair(s.cfg.Cert, s.cfg.Key)
+	if err != nil {
+		return fmt.Errorf("failed loading TLS certificate: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if s.cfg.ClientCA != "" {
+		buf, err := os.ReadFile(s.cfg.ClientCA)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(buf) {
+			return fmt.Errorf("no certificates found in %s", s.cfg.ClientCA)
+		}
+	}
+	s.cert, s.clientCAs, s.modTimes = &cert, clientCAs, modTimes
+	return nil
+}
+
+// reload loads the files again if any of them changed. If the files are not
+// valid, the certificates loaded earlier are used
+func (s *Store) reload() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if time.Since(s.checked) < reloadInterval {
+		return
+	}
+	s.checked = time.Now()
+	for i, f := range s.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			log.Warnf("failed checking %s: %v", f, err)
+			return
+		}
+		if !fi.ModTime().Equal(s.modTimes[i]) {
+			if err := s.load(); err != nil {
+				log.Errorf("failed reloading TLS certificates: %v", err)
+				return
+			}
+			log.Info("reloaded TLS certificates")
+			return
+		}
+	}
+}
+
+func (s *Store) current() (*tls.Certificate, *x509.CertPool) {
+	s.reload()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.cert, s.clientCAs
+}
+
+// ServerConfig returns the config of the servers using the protocols. If the
+// client CA is configured, the clients are required to present a certificate
+// signed by it
+func (s *Store) ServerConfig(protos ...string) *tls.Config {
+	if s.idCert != nil {
+		// The clients are not required to present a certificate, but the ones
+		// presented should be libp2p certificates
+		verify := verifyPeer("")
+		return &tls.Config{
+			MinVersion:   tls.VersionTLS13,
+			NextProtos:   protos,
+			Certificates: []tls.Certificate{*s.idCert},
+			ClientAuth:   tls.RequestClientCert,
+			VerifyPeerCertificate: func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+				if len(rawCerts) == 0 {
+					return nil
+				}
+				return verify(rawCerts, chains)
+			},
+		}
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: protos,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := s.current()
+			return cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, clientCAs := s.current()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   protos,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if clientCAs != nil {
+				cfg.ClientCAs = clientCAs
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig returns the config of the clients connecting to the remote peer.
+// The certificate is presented to the servers requiring it and the servers are
+// verified using the client CA, or the system roots if it is not configured.
+// With the libp2p identity, the server is verified using the peer ID, which is
+// required
+func (s *Store) ClientConfig(remote peer.ID) (*tls.Config, error) {
+	if s.idCert != nil {
+		if remote == "" {
+			return nil, errors.New("peer ID of the server required to verify TLS identity")
+		}
+		return &tls.Config{
+			MinVersion: tls.VersionTLS13,
+			// The chain is verified using the peer ID instead of a CA
+			InsecureSkipVerify:    true,
+			VerifyPeerCertificate: verifyPeer(remote),
+			Certificates:          []tls.Certificate{*s.idCert},
+		}, nil
+	}
+	_, clientCAs := s.current()
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    clientCAs,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := s.current()
+			return cert, nil
+		},
+	}, nil
+}
@@ -0,0 +1,223 @@
+package certs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/plexsysio/go-msuite/modules/certs"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+)
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeCerts writes a CA and a certificate signed by it for the localhost in the
+// directory
+func writeCerts(t *testing.T, dir string, serial int64) settings.TLS {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial + 1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caTmpl, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := settings.TLS{
+		Enabled:  true,
+		Cert:     filepath.Join(dir, "cert.pem"),
+		Key:      filepath.Join(dir, "key.pem"),
+		ClientCA: filepath.Join(dir, "ca.pem"),
+	}
+	writePEM(t, cfg.ClientCA, "CERTIFICATE", caDER)
+	writePEM(t, cfg.Cert, "CERTIFICATE", der)
+	writePEM(t, cfg.Key, "EC PRIVATE KEY", keyDER)
+	return cfg
+}
+
+// handshake connects the client to the server and returns the serial of the
+// server certificate
+func handshake(server, client *tls.Config) (int64, error) {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		sc, err := l.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer sc.Close()
+		errc <- sc.(*tls.Conn).Handshake()
+	}()
+	client = client.Clone()
+	client.ServerName = "localhost"
+	conn, err := tls.Dial("tcp", l.Addr().String(), client)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := <-errc; err != nil {
+		return 0, err
+	}
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func clientConfig(t *testing.T, s *certs.Store, remote peer.ID) *tls.Config {
+	t.Helper()
+
+	cfg, err := s.ClientConfig(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	cfg := writeCerts(t, dir, 1)
+
+	if _, err := certs.New(settings.TLS{Enabled: true}); err == nil {
+		t.Fatal("expected error without certificate")
+	}
+	s, err := certs.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := handshake(s.ServerConfig(), clientConfig(t, s, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial != 2 {
+		t.Fatal("incorrect certificate", serial)
+	}
+
+	t.Run("client certificate required", func(t *testing.T) {
+		client := clientConfig(t, s, "")
+		client.GetClientCertificate = nil
+		if _, err := handshake(s.ServerConfig(), client); err == nil {
+			t.Fatal("expected handshake to fail without client certificate")
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		time.Sleep(1100 * time.Millisecond)
+		writeCerts(t, dir, 10)
+
+		serial, err := handshake(s.ServerConfig(), clientConfig(t, s, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serial != 11 {
+			t.Fatal("expected certificate to be reloaded", serial)
+		}
+	})
+
+	t.Run("invalid files", func(t *testing.T) {
+		time.Sleep(1100 * time.Millisecond)
+		if err := os.WriteFile(cfg.Cert, []byte("invalid"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		serial, err := handshake(s.ServerConfig(), clientConfig(t, s, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serial != 11 {
+			t.Fatal("expected previous certificate to be used", serial)
+		}
+	})
+}
+
+func TestIdentity(t *testing.T) {
+	newIdentity := func() (*certs.Store, peer.ID) {
+		priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := peer.IDFromPrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := certs.NewIdentity(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s, id
+	}
+	server, serverID := newIdentity()
+	client, clientID := newIdentity()
+
+	if _, err := client.ClientConfig(""); err == nil {
+		t.Fatal("expected error without peer ID")
+	}
+	if _, err := handshake(server.ServerConfig(), clientConfig(t, client, serverID)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(server.ServerConfig(), clientConfig(t, client, clientID)); err == nil {
+		t.Fatal("expected handshake to fail with other peer ID")
+	}
+
+	// Clients without certificates can connect, as they verify the server
+	anon := clientConfig(t, client, serverID)
+	anon.Certificates = nil
+	if _, err := handshake(server.ServerConfig(), anon); err != nil {
+		t.Fatal(err)
+	}
+}
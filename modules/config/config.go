@@ -22,3 +22,10 @@ type Config interface {
 	// Exists checks whether key exists
 	Exists(key string) bool
 }
+
+// KeyLister is implemented by the configs which can list their keys. It is kept
+// out of Config, so that the existing implementations do not break
+type KeyLister interface {
+	// Keys returns all the keys present in sorted order
+	Keys() []string
+}
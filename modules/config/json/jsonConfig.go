@@ -1,42 +1,40 @@
 package jsonConf
 
 import (
-	"bytes"
 	"encoding/json"
 	"io"
 
-	"github.com/plexsysio/go-msuite/utils"
+	"github.com/plexsysio/go-msuite/modules/config/mapconf"
 )
 
+var codec = &mapConf.Codec{
+	Marshal:   json.Marshal,
+	Unmarshal: json.Unmarshal,
+	Indent: func(v interface{}) ([]byte, error) {
+		return json.MarshalIndent(v, "", "\t")
+	},
+}
+
 type JsonConfig map[string]interface{}
 
 func (j *JsonConfig) Get(key string, val interface{}) bool {
-	_, ok := (*j)[key]
-	if !ok {
-		return false
-	}
-	jsonString, err := json.Marshal((*j)[key])
-	if err != nil {
-		return false
-	}
-	if err := json.Unmarshal(jsonString, val); err != nil {
-		return false
-	}
-	return true
+	return mapConf.Values(*j).Get(key, val)
 }
 
 func (j *JsonConfig) Set(key string, val interface{}) {
-	(*j)[key] = val
+	(*mapConf.Values)(j).Set(key, val)
 }
 
 func (j *JsonConfig) IsSet(key string) bool {
-	val, ok := (*j)[key]
-	return ok && val.(bool)
+	return mapConf.Values(*j).IsSet(key)
 }
 
 func (j *JsonConfig) Exists(key string) bool {
-	_, ok := (*j)[key]
-	return ok
+	return mapConf.Values(*j).Exists(key)
+}
+
+func (j *JsonConfig) Keys() []string {
+	return mapConf.Values(*j).Keys()
 }
 
 func DefaultConfig() *JsonConfig {
@@ -46,52 +44,24 @@ func DefaultConfig() *JsonConfig {
 
 func FromFile(f string) (*JsonConfig, error) {
 	j := &JsonConfig{}
-	writer := j.Writer()
-	err := utils.ReadFromFile(writer, f)
-	if err != nil {
+	if err := codec.FromFile(f, j); err != nil {
 		return nil, err
 	}
-	return j, writer.Close()
+	return j, nil
 }
 
 func (j *JsonConfig) String() string {
-	buf, err := json.Marshal(j)
-	if err != nil {
-		return "INVALID_CONFIG"
-	}
-	return string(buf)
-}
-
-func (j *JsonConfig) Reader() (io.Reader, error) {
-	buf, err := json.Marshal(j)
-	if err != nil {
-		return nil, err
-	}
-	return bytes.NewBuffer(buf), nil
+	return codec.String(j)
 }
 
-type writeCloser struct {
-	*bytes.Buffer
-	conf *JsonConfig
+func (j *JsonConfig) Pretty() string {
+	return codec.Pretty(j)
 }
 
-func (w *writeCloser) Close() error {
-	err := json.Unmarshal(w.Bytes(), w.conf)
-	if err != nil {
-		return err
-	}
-	w.Reset()
-	return nil
+func (j *JsonConfig) Reader() (io.Reader, error) {
+	return codec.Reader(j)
 }
 
 func (j *JsonConfig) Writer() io.WriteCloser {
-	return &writeCloser{Buffer: bytes.NewBuffer(nil), conf: j}
-}
-
-func (j *JsonConfig) Pretty() string {
-	buf, err := json.MarshalIndent(j, "", "\t")
-	if err != nil {
-		return "INVALID_CONFIG"
-	}
-	return string(buf)
+	return codec.Writer(j)
 }
@@ -91,6 +91,11 @@ func TestConfig(t *testing.T) {
 		if conf.IsSet("NotBool") {
 			t.Fatal("expected NotBool to be not set")
 		}
+
+		conf.Set("String", "true")
+		if conf.IsSet("String") {
+			t.Fatal("expected non-bool value to be not set")
+		}
 	})
 
 	t.Run("exists", func(t *testing.T) {
@@ -106,6 +111,25 @@ func TestConfig(t *testing.T) {
 		}
 	})
 
+	t.Run("zero value", func(t *testing.T) {
+		conf := &jsonConf.JsonConfig{}
+
+		var strVal string
+		if conf.Get("StrVal", &strVal) || conf.Exists("StrVal") {
+			t.Fatal("expected StrVal to not exist")
+		}
+
+		conf.Set("StrVal", "str")
+		if !conf.Get("StrVal", &strVal) || strVal != "str" {
+			t.Fatal("expected StrVal to be set on zero value")
+		}
+
+		lit := jsonConf.JsonConfig{"StrVal": "lit"}
+		if !lit.Get("StrVal", &strVal) || strVal != "lit" {
+			t.Fatal("expected value from map literal")
+		}
+	})
+
 	t.Run("reader writer", func(t *testing.T) {
 		conf := jsonConf.DefaultConfig()
 
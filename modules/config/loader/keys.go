@@ -0,0 +1,92 @@
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/plexsysio/go-msuite/modules/config/schema"
+)
+
+// normalize strips separators and case so that HTTP_PORT, http-port and HTTPPort
+// all refer to the same key
+func normalize(name string) string {
+	name = strings.ReplaceAll(name, "_", "")
+	name = strings.ReplaceAll(name, "-", "")
+	return strings.ToLower(name)
+}
+
+var normalizedKeys = func() map[string]string {
+	keys := make(map[string]string, len(schema.Keys))
+	for _, k := range schema.Keys {
+		keys[normalize(k.Name)] = k.Name
+	}
+	return keys
+}()
+
+// lookupKey returns the config key for the name. If the name does not belong to
+// a known key, it is returned as is so that apps can use their own keys
+func lookupKey(name string) string {
+	if k, ok := normalizedKeys[normalize(name)]; ok {
+		return k
+	}
+	return name
+}
+
+// parseValue converts the string value into the type expected for the key in
+// the schema
+func parseValue(key, val string) (interface{}, error) {
+	k, found := schema.Lookup(key)
+	if !found {
+		// Unknown type, use JSON if possible else treat as string
+		var res interface{}
+		if err := json.Unmarshal([]byte(val), &res); err == nil {
+			return res, nil
+		}
+		return val, nil
+	}
+	switch k.Type {
+	case schema.Bool:
+		return strconv.ParseBool(val)
+	case schema.Int:
+		return strconv.Atoi(val)
+	case schema.String:
+		return val, nil
+	case schema.Strings:
+		if strings.HasPrefix(val, "[") {
+			var strs []string
+			err := json.Unmarshal([]byte(val), &strs)
+			return strs, err
+		}
+		strs := []string{}
+		for _, v := range strings.Split(val, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				strs = append(strs, v)
+			}
+		}
+		return strs, nil
+	case schema.StringMap:
+		if strings.HasPrefix(val, "{") {
+			mp := map[string]string{}
+			err := json.Unmarshal([]byte(val), &mp)
+			return mp, err
+		}
+		mp := map[string]string{}
+		for _, v := range strings.Split(val, ",") {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			kv := strings.SplitN(v, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid map entry %q, expected key=value", v)
+			}
+			mp[kv[0]] = kv[1]
+		}
+		return mp, nil
+	default:
+		var res map[string]interface{}
+		err := json.Unmarshal([]byte(val), &res)
+		return res, err
+	}
+}
@@ -0,0 +1,237 @@
+// Package loader builds the msuite configuration from multiple layered sources.
+// Sources are applied in order, so values from later sources override the earlier
+// ones. The usual order is built-in defaults, config file, environment variables
+// and then command-line flags.
+package loader
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/plexsysio/go-msuite/modules/config"
+	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	"github.com/plexsysio/go-msuite/modules/config/schema"
+	tomlConf "github.com/plexsysio/go-msuite/modules/config/toml"
+	yamlConf "github.com/plexsysio/go-msuite/modules/config/yaml"
+	"github.com/plexsysio/go-msuite/utils"
+)
+
+// Source adds its configuration values to the config passed
+type Source func(config.Config) error
+
+// DefaultValues are the built-in defaults used by msuite
+var DefaultValues = map[string]interface{}{
+	"Services": []string{"msuite"},
+	"TMWorkers": map[string]int{
+		"Min": 0,
+		"Max": 20,
+	},
+}
+
+// Load creates a new config from the sources
+func Load(sources ...Source) (config.Config, error) {
+	c := jsonConf.DefaultConfig()
+	if err := Apply(c, sources...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Apply applies the sources in order on an existing config
+func Apply(c config.Config, sources ...Source) error {
+	for _, src := range sources {
+		if err := src(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Defaults provides the built-in msuite defaults
+func Defaults() Source {
+	return Map(DefaultValues)
+}
+
+// Map provides values from an in-memory map
+func Map(vals map[string]interface{}) Source {
+	return func(c config.Config) error {
+		for k, v := range vals {
+			c.Set(k, v)
+		}
+		return nil
+	}
+}
+
+// File reads the config file at path. The format is detected using the file
+// extension. JSON (.json), YAML (.yaml/.yml) and TOML (.toml) files are supported
+func File(path string) Source {
+	return func(c config.Config) error {
+		if !utils.Exists(path) {
+			return fmt.Errorf("config file %s not found", path)
+		}
+		fc, err := FromFile(path)
+		if err != nil {
+			return err
+		}
+		var vals map[string]interface{}
+		switch v := fc.(type) {
+		case *jsonConf.JsonConfig:
+			vals = *v
+		case *yamlConf.YamlConfig:
+			vals = *v
+		case *tomlConf.TomlConfig:
+			vals = *v
+		}
+		return Map(vals)(c)
+	}
+}
+
+// FromFile opens the config file using the backend for its extension
+func FromFile(path string) (config.Config, error) {
+	var (
+		c   config.Config
+		err error
+	)
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		c, err = jsonConf.FromFile(path)
+	case ".yaml", ".yml":
+		c, err = yamlConf.FromFile(path)
+	case ".toml":
+		c, err = tomlConf.FromFile(path)
+	default:
+		return nil, fmt.Errorf("unsupported config file format %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading config file %s %w", path, err)
+	}
+	return c, nil
+}
+
+// Clone copies the config into a new config using the same backend
+func Clone(c config.Config) (config.Config, error) {
+	var nc config.Config
+	switch c.(type) {
+	case *yamlConf.YamlConfig:
+		nc = yamlConf.DefaultConfig()
+	case *tomlConf.TomlConfig:
+		nc = tomlConf.DefaultConfig()
+	default:
+		nc = jsonConf.DefaultConfig()
+	}
+	rdr, err := c.Reader()
+	if err != nil {
+		return nil, err
+	}
+	w := nc.Writer()
+	if _, err := io.Copy(w, rdr); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return nc, nil
+}
+
+// Value returns the config key for the name along with the string value parsed
+// into the type expected for the key. Names are matched ignoring case and
+// separators, so http-port and HTTP_PORT both return HTTPPort
+func Value(name, val string) (string, interface{}, error) {
+	key := lookupKey(name)
+	v, err := parseValue(key, val)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid value for %s %w", key, err)
+	}
+	return key, v, nil
+}
+
+// Env reads environment variables starting with the prefix followed by an
+// underscore, e.g. with prefix MSUITE, MSUITE_HTTP_PORT sets HTTPPort. Variables
+// which do not belong to a known key are set using the name without prefix
+func Env(prefix string) Source {
+	return func(c config.Config) error {
+		p := strings.ToUpper(prefix) + "_"
+		for _, kv := range os.Environ() {
+			if !strings.HasPrefix(kv, p) {
+				continue
+			}
+			kv = strings.TrimPrefix(kv, p)
+			idx := strings.Index(kv, "=")
+			if idx <= 0 {
+				continue
+			}
+			key := lookupKey(kv[:idx])
+			val, err := parseValue(key, kv[idx+1:])
+			if err != nil {
+				return fmt.Errorf("invalid value for env %s%s %w", p, kv[:idx], err)
+			}
+			c.Set(key, val)
+		}
+		return nil
+	}
+}
+
+// Flags reads the flags which were set on the flagset. Only flags which belong to
+// a known key are used, so the flagset can be shared with the application. The
+// flagset should already be parsed
+func Flags(fs *flag.FlagSet) Source {
+	return func(c config.Config) error {
+		var retErr error
+		fs.Visit(func(f *flag.Flag) {
+			if retErr != nil {
+				return
+			}
+			key := lookupKey(f.Name)
+			if _, ok := schema.Lookup(key); !ok {
+				return
+			}
+			val, err := parseValue(key, f.Value.String())
+			if err != nil {
+				retErr = fmt.Errorf("invalid value for flag %s %w", f.Name, err)
+				return
+			}
+			c.Set(key, val)
+		})
+		return retErr
+	}
+}
+
+// RegisterFlags defines flags for all the known keys on the flagset. The flag
+// names are the keys in kebab-case, e.g. HTTPPort can be set using -http-port.
+// Bool keys are registered as bool flags, so -use-http is same as -use-http=true
+func RegisterFlags(fs *flag.FlagSet) {
+	for _, k := range schema.Keys {
+		name := FlagName(k.Name)
+		if fs.Lookup(name) != nil {
+			continue
+		}
+		usage := fmt.Sprintf("%s (%s)", k.Description, k.Type)
+		if k.Type == schema.Bool {
+			fs.Bool(name, false, usage)
+			continue
+		}
+		fs.String(name, "", usage)
+	}
+}
+
+// FlagName returns the flag name used for the key
+func FlagName(key string) string {
+	rs := []rune(key)
+	var sb strings.Builder
+	for i, r := range rs {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := rs[i-1]
+			nextLower := i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if unicode.IsLower(prev) || (unicode.IsUpper(prev) && nextLower) {
+				sb.WriteRune('-')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
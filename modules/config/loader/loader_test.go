@@ -0,0 +1,170 @@
+package loader_test
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/plexsysio/go-msuite/modules/config/loader"
+	yamlConf "github.com/plexsysio/go-msuite/modules/config/yaml"
+)
+
+func TestLoader(t *testing.T) {
+	jsonFile := `{"HTTPPort": 8080, "Services": ["svc1"], "JWTSecret": "file"}`
+	if err := os.WriteFile("cfg.json", []byte(jsonFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	yamlFile := "HTTPPort: 8081\nStaticAddresses:\n  svc2: localhost:10000\n"
+	if err := os.WriteFile("cfg.yaml", []byte(yamlFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove("cfg.json")
+		os.Remove("cfg.yaml")
+	})
+
+	t.Setenv("MSUITE_JWT_SECRET", "env")
+	t.Setenv("MSUITE_USE_HTTP", "true")
+	t.Setenv("MSUITE_BOOTSTRAP_ADDRESSES", "addr1,addr2")
+	t.Setenv("MSUITE_APP_KEY", "appval")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	loader.RegisterFlags(fs)
+	fs.Bool("verbose", false, "app flag")
+	err := fs.Parse([]string{"-http-port", "9000", "-use-grpc", "-use-tcp=false", "-verbose"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := loader.Load(
+		loader.Defaults(),
+		loader.File("cfg.json"),
+		loader.File("cfg.yaml"),
+		loader.Env("MSUITE"),
+		loader.Flags(fs),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var port int
+	if !c.Get("HTTPPort", &port) || port != 9000 {
+		t.Fatal("expected flag to override port", port)
+	}
+	var secret string
+	if !c.Get("JWTSecret", &secret) || secret != "env" {
+		t.Fatal("expected env to override secret", secret)
+	}
+	if !c.IsSet("UseHTTP") {
+		t.Fatal("expected UseHTTP to be set from env")
+	}
+	var svcs []string
+	if !c.Get("Services", &svcs) || !reflect.DeepEqual(svcs, []string{"svc1"}) {
+		t.Fatal("expected file to override services", svcs)
+	}
+	var addrs []string
+	if !c.Get("BootstrapAddresses", &addrs) || !reflect.DeepEqual(addrs, []string{"addr1", "addr2"}) {
+		t.Fatal("incorrect bootstrap addresses", addrs)
+	}
+	static := map[string]string{}
+	if !c.Get("StaticAddresses", &static) || static["svc2"] != "localhost:10000" {
+		t.Fatal("incorrect static addresses", static)
+	}
+	tm := map[string]int{}
+	if !c.Get("TMWorkers", &tm) || tm["Max"] != 20 {
+		t.Fatal("expected defaults for taskmanager", tm)
+	}
+	var appVal string
+	if !c.Get("APP_KEY", &appVal) || appVal != "appval" {
+		t.Fatal("expected app key from env", appVal)
+	}
+	var useGRPC bool
+	if !c.Get("UseGRPC", &useGRPC) || !useGRPC {
+		t.Fatal("expected bare bool flag to enable UseGRPC")
+	}
+	useTCP := true
+	if !c.Get("UseTCP", &useTCP) || useTCP {
+		t.Fatal("expected bool flag to disable UseTCP")
+	}
+	if c.Exists("UseP2PGRPC") {
+		t.Fatal("bool flags not given should not be set")
+	}
+	if c.Exists("verbose") {
+		t.Fatal("app flag should not be present in config")
+	}
+}
+
+func TestLoaderErrors(t *testing.T) {
+	_, err := loader.Load(loader.File("absent.json"))
+	if err == nil {
+		t.Fatal("expected error for absent file")
+	}
+
+	t.Setenv("MSUITE_HTTP_PORT", "notaport")
+	_, err = loader.Load(loader.Env("MSUITE"))
+	if err == nil {
+		t.Fatal("expected error for invalid env value")
+	}
+}
+
+func TestEnvReload(t *testing.T) {
+	t.Setenv("MSUITE_HTTP_PORT", "9000")
+
+	src := loader.Env("msuite")
+	for i := 0; i < 2; i++ {
+		yc := yamlConf.DefaultConfig()
+		if err := src(yc); err != nil {
+			t.Fatal(err)
+		}
+		var port int
+		if !yc.Get("HTTPPort", &port) || port != 9000 {
+			t.Fatal("expected env to be applied on each load", i, port)
+		}
+	}
+}
+
+func TestFlagName(t *testing.T) {
+	for k, v := range map[string]string{
+		"HTTPPort":  "http-port",
+		"JWTSecret": "jwt-secret",
+		"UseP2P":    "use-p2p",
+		"TMWorkers": "tm-workers",
+		"ACL":       "acl",
+	} {
+		if n := loader.FlagName(k); n != v {
+			t.Fatalf("incorrect flag name for %s expected %s found %s", k, v, n)
+		}
+	}
+}
+
+func TestValueAndClone(t *testing.T) {
+	key, val, err := loader.Value("http-port", "8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "HTTPPort" || val != 8080 {
+		t.Fatalf("incorrect value %s %v", key, val)
+	}
+	if _, _, err := loader.Value("use-http", "notabool"); err == nil {
+		t.Fatal("expected error for invalid value")
+	}
+
+	yc := yamlConf.DefaultConfig()
+	yc.Set(key, val)
+	c, err := loader.Clone(yc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(*yamlConf.YamlConfig); !ok {
+		t.Fatalf("expected yaml config found %T", c)
+	}
+	c.Set("UseHTTP", true)
+	if yc.Exists("UseHTTP") {
+		t.Fatal("clone should not update the original config")
+	}
+	var port int
+	if !c.Get("HTTPPort", &port) || port != 8080 {
+		t.Fatal("incorrect port in clone", port)
+	}
+}
@@ -0,0 +1,127 @@
+// Package mapConf implements the config helpers shared by the map backed configs.
+// The file formats only differ in the encoding used, so the backends keep their
+// own map types and use the Codec for the rest of the implementation
+package mapConf
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/plexsysio/go-msuite/utils"
+)
+
+// Values is the map used by the backends to store the config. Values are
+// converted on Get using JSON, so the backends can be used interchangeably
+type Values map[string]interface{}
+
+func (m Values) Get(key string, val interface{}) bool {
+	_, ok := m[key]
+	if !ok {
+		return false
+	}
+	jsonString, err := json.Marshal(m[key])
+	if err != nil {
+		return false
+	}
+	if err := json.Unmarshal(jsonString, val); err != nil {
+		return false
+	}
+	return true
+}
+
+// Set stores the value in the map. The map is allocated if it is nil, so that
+// the zero value of the backends can be used
+func (m *Values) Set(key string, val interface{}) {
+	if *m == nil {
+		*m = make(map[string]interface{})
+	}
+	(*m)[key] = val
+}
+
+func (m Values) IsSet(key string) bool {
+	val, ok := m[key].(bool)
+	return ok && val
+}
+
+func (m Values) Exists(key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+func (m Values) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Codec encodes the config values in the file format
+type Codec struct {
+	Marshal   func(interface{}) ([]byte, error)
+	Unmarshal func([]byte, interface{}) error
+	// Indent is used for the Pretty string. Marshal is used if it is not set
+	Indent func(interface{}) ([]byte, error)
+}
+
+// FromFile reads the file into conf using the codec. conf should be a pointer
+// to the map of the backend
+func (c *Codec) FromFile(f string, conf interface{}) error {
+	writer := c.Writer(conf)
+	err := utils.ReadFromFile(writer, f)
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+func (c *Codec) String(conf interface{}) string {
+	buf, err := c.Marshal(conf)
+	if err != nil {
+		return "INVALID_CONFIG"
+	}
+	return string(buf)
+}
+
+func (c *Codec) Pretty(conf interface{}) string {
+	if c.Indent == nil {
+		return c.String(conf)
+	}
+	buf, err := c.Indent(conf)
+	if err != nil {
+		return "INVALID_CONFIG"
+	}
+	return string(buf)
+}
+
+func (c *Codec) Reader(conf interface{}) (io.Reader, error) {
+	buf, err := c.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(buf), nil
+}
+
+type writeCloser struct {
+	*bytes.Buffer
+	codec *Codec
+	conf  interface{}
+}
+
+func (w *writeCloser) Close() error {
+	err := w.codec.Unmarshal(w.Bytes(), w.conf)
+	if err != nil {
+		return err
+	}
+	w.Reset()
+	return nil
+}
+
+// Writer returns the writer which decodes the data written into conf on Close.
+// conf should be a pointer to the map of the backend
+func (c *Codec) Writer(conf interface{}) io.WriteCloser {
+	return &writeCloser{Buffer: bytes.NewBuffer(nil), codec: c, conf: conf}
+}
@@ -0,0 +1,103 @@
+package mapConf_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/plexsysio/go-msuite/modules/config/mapconf"
+)
+
+type structVal struct {
+	Name string
+	Val  int
+}
+
+var codec = &mapConf.Codec{
+	Marshal:   json.Marshal,
+	Unmarshal: json.Unmarshal,
+}
+
+func TestConfig(t *testing.T) {
+	var conf mapConf.Values
+	conf.Set("Name", "dummy")
+	conf.Set("Int", 10)
+	conf.Set("Bool", true)
+	conf.Set("StrArray", []string{"hello", "world"})
+	conf.Set("Float64", float64(1.5))
+	conf.Set("Struct", structVal{
+		Name: "struct",
+		Val:  100,
+	})
+	conf.Set("Mounts", map[string]interface{}{
+		"level": map[string]interface{}{
+			"path":   "kv",
+			"prefix": "/",
+		},
+	})
+
+	assert := func(val bool, msg string) {
+		t.Helper()
+		if !val {
+			t.Fatal(msg)
+		}
+	}
+
+	var strVal string
+	assert(conf.Get("Name", &strVal) && strVal == "dummy", "getting strval")
+
+	var intVal int
+	assert(conf.Get("Int", &intVal) && intVal == 10, "getting intval")
+
+	var strArray []string
+	assert(
+		conf.Get("StrArray", &strArray) && reflect.DeepEqual(strArray, []string{"hello", "world"}),
+		"getting strarray",
+	)
+
+	var floatVal float64
+	assert(conf.Get("Float64", &floatVal) && floatVal == 1.5, "getting float val")
+
+	strct := structVal{}
+	assert(conf.Get("Struct", &strct) && strct.Name == "struct" && strct.Val == 100, "getting struct val")
+
+	mnts := map[string]map[string]string{}
+	assert(conf.Get("Mounts", &mnts) && mnts["level"]["prefix"] == "/", "getting nested map")
+
+	assert(conf.IsSet("Bool"), "expected Bool to be set")
+	assert(!conf.IsSet("Name"), "expected non-bool to be not set")
+	assert(!conf.IsSet("NotBool"), "expected NotBool to be not set")
+	assert(conf.Exists("Name"), "expected Name to exist")
+	assert(!conf.Exists("NonKey"), "expected NonKey to not exist")
+
+	var strVal2 string
+	assert(!conf.Get("NonKey", &strVal2), "key should not exist")
+	assert(!conf.Get("Int", &strVal2), "incorrect type for Get should fail")
+
+	assert(
+		reflect.DeepEqual(conf.Keys(), []string{"Bool", "Float64", "Int", "Mounts", "Name", "StrArray", "Struct"}),
+		"keys should be sorted",
+	)
+	assert(codec.Pretty(conf) == codec.String(conf), "expected Marshal to be used without Indent")
+
+	rdr, err := codec.Reader(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConf := mapConf.Values{}
+	w := codec.Writer(&newConf)
+	if _, err := io.Copy(w, rdr); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(newConf.Get("Name", &strVal) && strVal == "dummy", "getting strval after copy")
+
+	conf.Set("Function", func() { fmt.Println("hello") })
+	var funcType func()
+	assert(!conf.Get("Function", &funcType), "non-encodable type should not be found")
+	assert(codec.String(conf) == "INVALID_CONFIG", "expected invalid config with non-encodable type")
+}
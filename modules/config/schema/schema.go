@@ -0,0 +1,438 @@
+// Package schema declares all the config keys known to msuite along with their
+// types and the rules for valid combinations. Validate can be used to check a
+// config before using it.
+package schema
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+)
+
+// Type of the value expected for a key
+type Type int
+
+const (
+	Bool Type = iota
+	Int
+	String
+	Strings
+	StringMap
+	Object
+)
+
+func (t Type) String() string {
+	switch t {
+	case Bool:
+		return "bool"
+	case Int:
+		return "int"
+	case String:
+		return "string"
+	case Strings:
+		return "list of strings"
+	case StringMap:
+		return "map of strings"
+	default:
+		return "object"
+	}
+}
+
+// Key describes a config key
+type Key struct {
+	Name        string
+	Type        Type
+	Description string
+	// Check can be used to add validations on the value apart from the type
+	Check func(config.Config) error
+}
+
+// Rule checks the config for invalid combinations of keys
+type Rule func(config.Config) error
+
+// Keys is the list of all the keys used by msuite
+var Keys = []Key{
+	{Name: "RootPath", Type: String, Description: "path of the on-disk repository"},
+	{Name: "Services", Type: Strings, Description: "names of the services provided", Check: checkServices},
+	{Name: "Identity", Type: Object, Description: "libp2p identity of the node", Check: checkIdentity},
+	{Name: "TMWorkers", Type: Object, Description: "min and max taskmanager workers", Check: checkTMWorkers},
+	{Name: "Drain", Type: Object, Description: "graceful shutdown Delay and Timeout in seconds", Check: checkDrain},
+	{Name: "UseRateLimit", Type: Bool, Description: "enable rate limits on the gRPC and HTTP servers"},
+	{Name: "RateLimits", Type: Object, Description: "named rate limits on the gRPC and HTTP servers", Check: checkRateLimits},
+	{Name: "Mounts", Type: Object, Description: "datastore mounts in the repository", Check: checkMounts},
+	{Name: "UseGRPC", Type: Bool, Description: "enable gRPC server"},
+	{Name: "UseTCP", Type: Bool, Description: "serve gRPC on TCP"},
+	{Name: "TCPPort", Type: Int, Description: "TCP port for gRPC", Check: checkPort("TCPPort")},
+	{Name: "UseP2PGRPC", Type: Bool, Description: "serve gRPC on libp2p"},
+	{Name: "UseReflection", Type: Bool, Description: "enable gRPC server reflection and the method list on HTTP server"},
+	{Name: "DisableGRPCHealth", Type: Bool, Description: "do not register the standard gRPC health service"},
+	{Name: "UseUDS", Type: Bool, Description: "serve gRPC on unix domain socket"},
+	{Name: "UDSocket", Type: String, Description: "unix domain socket path for gRPC"},
+	{Name: "UseHTTP", Type: Bool, Description: "enable HTTP server"},
+	{Name: "HTTPPort", Type: Int, Description: "HTTP server port", Check: checkPort("HTTPPort")},
+	{Name: "CORS", Type: Object, Description: "CORS settings for HTTP server"},
+	{Name: "UseAuth", Type: Bool, Description: "enable JWT authentication and ACLs"},
+	{Name: "JWTSecret", Type: String, Description: "secret used to sign JWT tokens"},
+	{Name: "ACL", Type: StringMap, Description: "roles required for resources"},
+	{Name: "ServiceRole", Type: String, Description: "role of the service tokens used by gRPC clients without an incoming token"},
+	{Name: "UseTracing", Type: Bool, Description: "enable tracing"},
+	{Name: "TracingName", Type: String, Description: "service name used for tracing"},
+	{Name: "TracingHost", Type: String, Description: "jaeger agent address"},
+	{Name: "Tracer", Type: String, Description: "tracer backend, defaults to jaeger"},
+	{Name: "UseLocker", Type: Bool, Description: "enable distributed locker"},
+	{Name: "Locker", Type: String, Description: "locker implementation to use"},
+	{Name: "ZookeeperHost", Type: String, Description: "zookeeper host for locker"},
+	{Name: "ZookeeperPort", Type: Int, Description: "zookeeper port for locker", Check: checkPort("ZookeeperPort")},
+	{Name: "RedisHost", Type: String, Description: "redis host for locker"},
+	{Name: "RedisNetwork", Type: String, Description: "redis network for locker"},
+	{Name: "ElectionLease", Type: Int, Description: "lease of the election leaders in seconds", Check: checkNonNegative("ElectionLease")},
+	{Name: "UseP2P", Type: Bool, Description: "enable libp2p host"},
+	{Name: "SwarmPort", Type: Int, Description: "libp2p swarm port", Check: checkPort("SwarmPort")},
+	{Name: "Discovery", Type: String, Description: "service discovery backend used with libp2p, defaults to dht"},
+	{Name: "UseFiles", Type: Bool, Description: "enable ipfs-lite files service"},
+	{Name: "BootstrapAddresses", Type: Strings, Description: "libp2p bootstrap peer addresses"},
+	{Name: "SharedStoreNs", Type: String, Description: "namespace used for shared storage"},
+	{Name: "UseStaticDiscovery", Type: Bool, Description: "enable static service discovery"},
+	{Name: "StaticAddresses", Type: StringMap, Description: "addresses of services for static discovery"},
+	{Name: "LoadBalancer", Type: String, Description: "balance libp2p gRPC clients across the peers, round_robin or least_request", Check: checkLoadBalancer},
+	{Name: "ResolverRefresh", Type: Int, Description: "interval in seconds to discover the peers of balanced clients", Check: checkNonNegative("ResolverRefresh")},
+	{Name: "UseClientCache", Type: Bool, Description: "reuse gRPC client connections by service"},
+	{Name: "ClientIdleTimeout", Type: Int, Description: "time in seconds after which unused cached client connections are closed", Check: checkNonNegative("ClientIdleTimeout")},
+	{Name: "ClientHealthInterval", Type: Int, Description: "interval in seconds to check the health of cached client connections", Check: checkNonNegative("ClientHealthInterval")},
+	{Name: "ClientPolicies", Type: Object, Description: "timeout, retry and circuit breaker policies of gRPC clients by service", Check: checkClientPolicies},
+	{Name: "UsePrometheus", Type: Bool, Description: "enable prometheus metrics"},
+	{Name: "UsePrometheusLatency", Type: Bool, Description: "enable gRPC latency histograms"},
+	{Name: "UseDebug", Type: Bool, Description: "enable pprof handlers on HTTP server"},
+	{Name: "UseAdmin", Type: Bool, Description: "enable admin handlers on HTTP server"},
+	{Name: "UseTLS", Type: Bool, Description: "enable TLS on TCP gRPC listener, HTTP server and static clients"},
+	{Name: "TLSCert", Type: String, Description: "path of the TLS certificate, reloaded on changes"},
+	{Name: "TLSKey", Type: String, Description: "path of the TLS key, reloaded on changes"},
+	{Name: "TLSClientCA", Type: String, Description: "path of the CA used to verify clients for mutual TLS and servers by static clients"},
+	{Name: "UseTLSIdentity", Type: Bool, Description: "enable TLS using a certificate derived from the libp2p identity"},
+	{Name: "LogLevels", Type: StringMap, Description: "log levels of subsystems"},
+}
+
+// Rules are the checks for combinations of keys
+var Rules = []Rule{
+	requireKeys("UseTCP", "TCPPort"),
+	requireKeys("UseUDS", "UDSocket"),
+	requireKeys("UseHTTP", "HTTPPort"),
+	requireKeys("UseP2P", "SwarmPort"),
+	requireKeys("UseAuth", "JWTSecret"),
+	requireKeys("UseTLS", "TLSCert", "TLSKey"),
+	requireJaegerHost,
+	requireKeys("UseLocker", "Locker"),
+	requireKeys("UseStaticDiscovery", "StaticAddresses"),
+	requireAnyFlag("UseGRPC", "UseTCP", "UseP2PGRPC", "UseUDS"),
+	requireFlags("UseTCP", "UseGRPC"),
+	requireFlags("UseUDS", "UseGRPC"),
+	requireFlags("UseP2PGRPC", "UseGRPC", "UseP2P"),
+	requireFlags("UseStaticDiscovery", "UseGRPC"),
+	requireFlags("UseReflection", "UseGRPC"),
+	requireFlags("DisableGRPCHealth", "UseGRPC"),
+	requireFlags("UseFiles", "UseP2P"),
+	requireFlags("UseDebug", "UseHTTP"),
+	requireFlags("UseAdmin", "UseHTTP"),
+	requireFlags("UsePrometheusLatency", "UsePrometheus"),
+	requireAnyFlag("UseTLS", "UseTCP", "UseHTTP", "UseStaticDiscovery"),
+	requireAnyFlag("UseTLSIdentity", "UseTCP", "UseHTTP", "UseStaticDiscovery"),
+	excludeFlags("UseTLSIdentity", "UseTLS"),
+	distinctPorts,
+}
+
+// Lookup returns the key with the name
+func Lookup(name string) (Key, bool) {
+	for _, k := range Keys {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// Validate checks all the keys present in the config and the rules. All the
+// problems found are returned together. Additional rules can be passed to check
+// things outside the schema
+func Validate(c config.Config, extra ...Rule) error {
+	var errs *multierror.Error
+	typeErrs := map[string]bool{}
+	for _, k := range Keys {
+		if !c.Exists(k.Name) {
+			continue
+		}
+		if err := checkType(c, k); err != nil {
+			typeErrs[k.Name] = true
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		if k.Check != nil {
+			if err := k.Check(c); err != nil {
+				errs = appendWithPrefix(errs, k.Name, err)
+			}
+		}
+	}
+	// Rules are not checked if the types are incorrect as the errors would be
+	// misleading
+	if len(typeErrs) > 0 {
+		return errs.ErrorOrNil()
+	}
+	for _, r := range append(Rules, extra...) {
+		if err := r(c); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+func appendWithPrefix(errs *multierror.Error, prefix string, err error) *multierror.Error {
+	if merr, ok := err.(*multierror.Error); ok {
+		for _, e := range merr.Errors {
+			errs = multierror.Append(errs, fmt.Errorf("%s: %w", prefix, e))
+		}
+		return errs
+	}
+	return multierror.Append(errs, fmt.Errorf("%s: %w", prefix, err))
+}
+
+func checkType(c config.Config, k Key) error {
+	var ok bool
+	switch k.Type {
+	case Bool:
+		ok = c.Get(k.Name, new(bool))
+	case Int:
+		ok = c.Get(k.Name, new(int))
+	case String:
+		ok = c.Get(k.Name, new(string))
+	case Strings:
+		ok = c.Get(k.Name, new([]string))
+	case StringMap:
+		ok = c.Get(k.Name, &map[string]string{})
+	case Object:
+		ok = c.Get(k.Name, &map[string]interface{}{})
+	}
+	if !ok {
+		return fmt.Errorf("%s: expected value of type %s", k.Name, k.Type)
+	}
+	return nil
+}
+
+func requireKeys(flag string, keys ...string) Rule {
+	return func(c config.Config) error {
+		if !c.IsSet(flag) {
+			return nil
+		}
+		var errs *multierror.Error
+		for _, k := range keys {
+			if !c.Exists(k) {
+				errs = multierror.Append(errs, fmt.Errorf("%s requires %s to be configured", flag, k))
+			}
+		}
+		return errs.ErrorOrNil()
+	}
+}
+
+// requireJaegerHost checks the host is configured if jaeger tracer is used. Other
+// tracer backends are validated when they are created
+func requireJaegerHost(c config.Config) error {
+	var tracer string
+	if c.Get("Tracer", &tracer) && tracer != "jaeger" {
+		return nil
+	}
+	return requireKeys("UseTracing", "TracingHost")(c)
+}
+
+func requireFlags(flag string, flags ...string) Rule {
+	return func(c config.Config) error {
+		if !c.IsSet(flag) {
+			return nil
+		}
+		var errs *multierror.Error
+		for _, f := range flags {
+			if !c.IsSet(f) {
+				errs = multierror.Append(errs, fmt.Errorf("%s requires %s to be enabled", flag, f))
+			}
+		}
+		return errs.ErrorOrNil()
+	}
+}
+
+func requireAnyFlag(flag string, flags ...string) Rule {
+	return func(c config.Config) error {
+		if !c.IsSet(flag) {
+			return nil
+		}
+		for _, f := range flags {
+			if c.IsSet(f) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s requires one of %v to be enabled", flag, flags)
+	}
+}
+
+func excludeFlags(flag string, flags ...string) Rule {
+	return func(c config.Config) error {
+		if !c.IsSet(flag) {
+			return nil
+		}
+		var errs *multierror.Error
+		for _, f := range flags {
+			if c.IsSet(f) {
+				errs = multierror.Append(errs, fmt.Errorf("%s cannot be used with %s", flag, f))
+			}
+		}
+		return errs.ErrorOrNil()
+	}
+}
+
+func distinctPorts(c config.Config) error {
+	used := map[int]string{}
+	var errs *multierror.Error
+	for _, p := range []struct{ flag, key string }{
+		{"UseTCP", "TCPPort"},
+		{"UseHTTP", "HTTPPort"},
+		{"UseP2P", "SwarmPort"},
+	} {
+		var port int
+		if !c.IsSet(p.flag) || !c.Get(p.key, &port) || port == 0 {
+			continue
+		}
+		if other, found := used[port]; found {
+			errs = multierror.Append(errs, fmt.Errorf("%s and %s use the same port %d", other, p.key, port))
+			continue
+		}
+		used[port] = p.key
+	}
+	return errs.ErrorOrNil()
+}
+
+func checkPort(key string) func(config.Config) error {
+	return func(c config.Config) error {
+		var port int
+		_ = c.Get(key, &port)
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+		return nil
+	}
+}
+
+func checkNonNegative(key string) func(config.Config) error {
+	return func(c config.Config) error {
+		var v int
+		_ = c.Get(key, &v)
+		if v < 0 {
+			return errors.New("should not be negative")
+		}
+		return nil
+	}
+}
+
+func checkServices(c config.Config) error {
+	var svcs []string
+	_ = c.Get("Services", &svcs)
+	for _, s := range svcs {
+		if s == "" {
+			return errors.New("service name cannot be empty")
+		}
+	}
+	return nil
+}
+
+func checkIdentity(c config.Config) error {
+	id := map[string]interface{}{}
+	_ = c.Get("Identity", &id)
+	if _, ok := id["PrivKey"].(string); !ok {
+		return errors.New("PrivKey missing")
+	}
+	return nil
+}
+
+func checkDrain(c config.Config) error {
+	drain := map[string]int{}
+	if !c.Get("Drain", &drain) {
+		return errors.New("expected Delay and Timeout in seconds")
+	}
+	for k, v := range drain {
+		if k != "Delay" && k != "Timeout" {
+			return fmt.Errorf("unknown drain setting %s", k)
+		}
+		if v < 0 {
+			return fmt.Errorf("%s should not be negative", k)
+		}
+	}
+	return nil
+}
+
+func checkRateLimits(c config.Config) error {
+	limits := settings.RateLimits{}
+	if !c.Get("RateLimits", &limits) {
+		return errors.New("expected rate limits with Match, By, Rate and Burst")
+	}
+	var errs *multierror.Error
+	for name, l := range limits {
+		if err := l.Validate(); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+func checkLoadBalancer(c config.Config) error {
+	var lb string
+	_ = c.Get("LoadBalancer", &lb)
+	switch lb {
+	case "", settings.RoundRobin, settings.LeastRequest:
+		return nil
+	}
+	return fmt.Errorf("invalid balancer %q, should be one of %v", lb,
+		[]string{settings.RoundRobin, settings.LeastRequest})
+}
+
+func checkClientPolicies(c config.Config) error {
+	policies := settings.ClientPolicies{}
+	if !c.Get("ClientPolicies", &policies) {
+		return errors.New("expected client policies by service name")
+	}
+	var errs *multierror.Error
+	for svc, p := range policies {
+		if err := p.Validate(); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("%s: %w", svc, err))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+func checkTMWorkers(c config.Config) error {
+	tmCfg := map[string]int{}
+	if !c.Get("TMWorkers", &tmCfg) {
+		return errors.New("expected Min and Max worker counts")
+	}
+	if tmCfg["Max"] <= 0 {
+		return errors.New("Max workers should be more than 0")
+	}
+	if tmCfg["Min"] < 0 || tmCfg["Min"] > tmCfg["Max"] {
+		return errors.New("Min workers should be between 0 and Max")
+	}
+	return nil
+}
+
+func checkMounts(c config.Config) error {
+	mnts := map[string]interface{}{}
+	_ = c.Get("Mounts", &mnts)
+	var errs *multierror.Error
+	for k, v := range mnts {
+		dCfg, ok := v.(map[string]interface{})
+		if !ok {
+			errs = multierror.Append(errs, fmt.Errorf("config missing for datastore %s", k))
+			continue
+		}
+		if _, ok := dCfg["prefix"].(string); !ok {
+			errs = multierror.Append(errs, fmt.Errorf("prefix missing for datastore %s", k))
+		}
+	}
+	return errs.ErrorOrNil()
+}
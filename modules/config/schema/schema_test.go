@@ -0,0 +1,166 @@
+package schema_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/plexsysio/go-msuite/modules/config"
+	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	"github.com/plexsysio/go-msuite/modules/config/schema"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		vals   map[string]interface{}
+		extra  []schema.Rule
+		errors []string
+	}{
+		{
+			name: "valid",
+			vals: map[string]interface{}{
+				"UseGRPC":   true,
+				"UseTCP":    true,
+				"TCPPort":   10000,
+				"UseHTTP":   true,
+				"HTTPPort":  10001,
+				"TMWorkers": map[string]int{"Min": 1, "Max": 10},
+				"UseLocker": true,
+				"Locker":    "inmem",
+			},
+		},
+		{
+			name: "incorrect types",
+			vals: map[string]interface{}{
+				"UseGRPC":  "yes",
+				"TCPPort":  "10000",
+				"Services": "svc",
+			},
+			errors: []string{
+				"UseGRPC: expected value of type bool",
+				"TCPPort: expected value of type int",
+				"Services: expected value of type list of strings",
+			},
+		},
+		{
+			name: "inconsistent options",
+			vals: map[string]interface{}{
+				"UseGRPC":    true,
+				"UseP2PGRPC": true,
+				"UseTCP":     true,
+				"UseFiles":   true,
+			},
+			errors: []string{
+				"UseTCP requires TCPPort to be configured",
+				"UseP2PGRPC requires UseP2P to be enabled",
+				"UseFiles requires UseP2P to be enabled",
+			},
+		},
+		{
+			name: "grpc without transport",
+			vals: map[string]interface{}{
+				"UseGRPC": true,
+			},
+			errors: []string{"UseGRPC requires one of"},
+		},
+		{
+			name: "tls without certificate",
+			vals: map[string]interface{}{
+				"UseTLS": true,
+			},
+			errors: []string{
+				"UseTLS requires TLSCert to be configured",
+				"UseTLS requires TLSKey to be configured",
+				"UseTLS requires one of",
+			},
+		},
+		{
+			name: "tls with identity",
+			vals: map[string]interface{}{
+				"UseGRPC":        true,
+				"UseTCP":         true,
+				"TCPPort":        10000,
+				"UseTLS":         true,
+				"TLSCert":        "cert.pem",
+				"TLSKey":         "key.pem",
+				"UseTLSIdentity": true,
+			},
+			errors: []string{
+				"UseTLSIdentity cannot be used with UseTLS",
+			},
+		},
+		{
+			name: "invalid values",
+			vals: map[string]interface{}{
+				"TMWorkers": map[string]int{"Min": 10, "Max": 5},
+				"Mounts": map[string]interface{}{
+					"level": map[string]interface{}{"path": "kv"},
+				},
+				"HTTPPort":      70000,
+				"Drain":         map[string]int{"Timeout": -1},
+				"ElectionLease": -1,
+				"RateLimits": map[string]interface{}{
+					"api": map[string]interface{}{"Match": "/api/*", "By": "ip"},
+				},
+				"ClientPolicies": map[string]interface{}{
+					"svc": map[string]interface{}{"Timeout": "1x"},
+				},
+			},
+			errors: []string{
+				"Drain: Timeout should not be negative",
+				"ElectionLease: should not be negative",
+				"RateLimits: api: Rate should be more than 0",
+				"ClientPolicies: svc: invalid Timeout",
+				"TMWorkers: Min workers should be between 0 and Max",
+				"Mounts: prefix missing for datastore level",
+				"HTTPPort: invalid port 70000",
+			},
+		},
+		{
+			name: "same ports",
+			vals: map[string]interface{}{
+				"UseP2P":    true,
+				"SwarmPort": 10000,
+				"UseHTTP":   true,
+				"HTTPPort":  10000,
+			},
+			errors: []string{"HTTPPort and SwarmPort use the same port 10000"},
+		},
+		{
+			name: "extra rules",
+			vals: map[string]interface{}{},
+			extra: []schema.Rule{
+				func(config.Config) error { return errors.New("extra failed") },
+			},
+			errors: []string{"extra failed"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := jsonConf.DefaultConfig()
+			for k, v := range tc.vals {
+				c.Set(k, v)
+			}
+			err := schema.Validate(c, tc.extra...)
+			if len(tc.errors) == 0 {
+				if err != nil {
+					t.Fatal("unexpected error", err)
+				}
+				return
+			}
+			merr, ok := err.(*multierror.Error)
+			if !ok {
+				t.Fatal("expected multierror found", err)
+			}
+			if len(merr.Errors) != len(tc.errors) {
+				t.Fatalf("expected %d errors found %v", len(tc.errors), merr.Errors)
+			}
+			for _, exp := range tc.errors {
+				if !strings.Contains(err.Error(), exp) {
+					t.Fatalf("expected error %q in %v", exp, err)
+				}
+			}
+		})
+	}
+}
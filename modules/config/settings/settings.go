@@ -0,0 +1,482 @@
+// Package settings provides a typed view of the msuite configuration. Each field
+// maps to a key of the string-keyed config using the `config` tag, so the
+// settings can be converted to and from the stored config.
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/plexsysio/go-msuite/modules/config"
+	"go.uber.org/fx"
+)
+
+// Settings is the complete msuite configuration
+type Settings struct {
+	Services    []string          `config:"Services"`
+	LogLevels   map[string]string `config:"LogLevels"`
+	Repo        Repo
+	TaskManager TaskManager `config:"TMWorkers"`
+	Drain       Drain       `config:"Drain"`
+	RateLimit   bool        `config:"UseRateLimit"`
+	RateLimits  RateLimits  `config:"RateLimits"`
+	GRPC        GRPC
+	HTTP        HTTP
+	P2P         P2P
+	Auth        Auth
+	Locker      Locker
+	Tracing     Tracing
+	Metrics     Metrics
+	TLS         TLS
+}
+
+// Repo configures the repository
+type Repo struct {
+	RootPath string                 `config:"RootPath"`
+	Mounts   map[string]interface{} `config:"Mounts"`
+}
+
+// TaskManager configures the number of workers
+type TaskManager struct {
+	Min int
+	Max int
+}
+
+// DefaultDrainTimeout is used if the drain timeout is not configured
+const DefaultDrainTimeout = 10 * time.Second
+
+// Drain configures the graceful shutdown in seconds. The node is marked as not
+// ready and waits for Delay before the servers are stopped, so that clients and
+// load balancers move away. The servers and tasks then get Timeout to finish
+// before they are stopped forcefully
+type Drain struct {
+	Delay   int
+	Timeout int
+}
+
+// DelayDuration returns the delay before the servers are stopped
+func (d Drain) DelayDuration() time.Duration {
+	return time.Duration(d.Delay) * time.Second
+}
+
+// TimeoutDuration returns the time allowed for the servers and tasks to finish
+func (d Drain) TimeoutDuration() time.Duration {
+	if d.Timeout <= 0 {
+		return DefaultDrainTimeout
+	}
+	return time.Duration(d.Timeout) * time.Second
+}
+
+// RateLimits are the named rate limits applied on the gRPC and HTTP servers
+type RateLimits map[string]RateLimit
+
+// Keys used by the rate limits to identify the callers
+const (
+	// ByMethod limits each gRPC method or HTTP path matched
+	ByMethod = "method"
+	// ByCaller limits each caller using the ID in the JWT claims. Callers without
+	// a token are identified by the peer
+	ByCaller = "caller"
+	// ByRole limits each role in the JWT claims together
+	ByRole = "role"
+	// ByPeer limits each libp2p peer or IP address
+	ByPeer = "peer"
+	// ByIP limits each IP address
+	ByIP = "ip"
+)
+
+// RateLimit is a token bucket of Rate requests per second with Burst capacity.
+// Match is a gRPC method or HTTP path, it matches the prefix if it ends with "*".
+// The methods/paths matched share the bucket of each key identified by By, so
+// the prefix is limited as a whole. Only ByMethod limits each of them separately
+type RateLimit struct {
+	Match string
+	By    string
+	Rate  float64
+	Burst int
+}
+
+// Validate checks the rate limit
+func (r RateLimit) Validate() error {
+	if r.Match == "" {
+		return errors.New("Match is required")
+	}
+	switch r.By {
+	case ByMethod, ByCaller, ByRole, ByPeer, ByIP:
+	default:
+		return fmt.Errorf("invalid By %q, should be one of %v", r.By,
+			[]string{ByMethod, ByCaller, ByRole, ByPeer, ByIP})
+	}
+	if r.Rate <= 0 {
+		return errors.New("Rate should be more than 0")
+	}
+	if r.Burst < 0 {
+		return errors.New("Burst should not be negative")
+	}
+	return nil
+}
+
+// GRPC configures the gRPC server, its transports and the client discovery
+type GRPC struct {
+	Enabled         bool              `config:"UseGRPC"`
+	TCP             bool              `config:"UseTCP"`
+	TCPPort         int               `config:"TCPPort"`
+	P2P             bool              `config:"UseP2PGRPC"`
+	Reflection      bool              `config:"UseReflection"`
+	DisableHealth   bool              `config:"DisableGRPCHealth"`
+	UDS             bool              `config:"UseUDS"`
+	UDSocket        string            `config:"UDSocket"`
+	StaticDiscovery bool              `config:"UseStaticDiscovery"`
+	StaticAddresses map[string]string `config:"StaticAddresses"`
+	ClientPolicies  ClientPolicies    `config:"ClientPolicies"`
+	LoadBalancer    string            `config:"LoadBalancer"`
+	ResolverRefresh int               `config:"ResolverRefresh"`
+	ClientCache     bool              `config:"UseClientCache"`
+	ClientIdle      int               `config:"ClientIdleTimeout"`
+	ClientHealth    int               `config:"ClientHealthInterval"`
+}
+
+// Load balancers of the libp2p gRPC clients
+const (
+	// RoundRobin sends the calls to the peers of the service in turn
+	RoundRobin = "round_robin"
+	// LeastRequest sends the calls to the peer with the least calls in progress
+	LeastRequest = "least_request"
+)
+
+// DefaultResolverRefresh is the default interval at which the peers of the
+// balanced services are discovered again
+const DefaultResolverRefresh = time.Minute
+
+// ResolverRefreshDuration is the interval at which the peers of the balanced
+// services are discovered again
+func (g GRPC) ResolverRefreshDuration() time.Duration {
+	if g.ResolverRefresh == 0 {
+		return DefaultResolverRefresh
+	}
+	return time.Duration(g.ResolverRefresh) * time.Second
+}
+
+// Defaults used by the client connection cache
+const (
+	DefaultClientIdleTimeout    = 5 * time.Minute
+	DefaultClientHealthInterval = 10 * time.Second
+)
+
+// ClientIdleDuration is the time after which the unused cached connections are
+// closed
+func (g GRPC) ClientIdleDuration() time.Duration {
+	if g.ClientIdle == 0 {
+		return DefaultClientIdleTimeout
+	}
+	return time.Duration(g.ClientIdle) * time.Second
+}
+
+// ClientHealthDuration is the interval at which the cached connections are
+// checked
+func (g GRPC) ClientHealthDuration() time.Duration {
+	if g.ClientHealth == 0 {
+		return DefaultClientHealthInterval
+	}
+	return time.Duration(g.ClientHealth) * time.Second
+}
+
+// ClientPolicies are the policies of the gRPC clients by service name. The policy
+// named "*" is used for the services without their own policy
+type ClientPolicies map[string]ClientPolicy
+
+// Defaults used by the client policies
+const (
+	DefaultClientBackoff     = 100 * time.Millisecond
+	DefaultClientMaxBackoff  = 2 * time.Second
+	DefaultClientOpenTimeout = 30 * time.Second
+)
+
+// ClientPolicy configures the calls made using the gRPC clients. The durations
+// are strings like "500ms" or "2s".
+//   - Timeout is the deadline of the calls without one
+//   - Retries is the number of times a call failing with UNAVAILABLE is retried,
+//     waiting Backoff first which is doubled on every retry up to MaxBackoff
+//   - FailureThreshold is the number of consecutive failures to a peer after
+//     which the circuit breaker opens for OpenTimeout and the calls are sent to
+//     another peer of the service. The breaker is disabled if it is 0
+type ClientPolicy struct {
+	Timeout          string `json:",omitempty"`
+	Retries          int    `json:",omitempty"`
+	Backoff          string `json:",omitempty"`
+	MaxBackoff       string `json:",omitempty"`
+	FailureThreshold int    `json:",omitempty"`
+	OpenTimeout      string `json:",omitempty"`
+}
+
+// Validate checks the client policy
+func (p ClientPolicy) Validate() error {
+	for name, d := range map[string]string{
+		"Timeout":     p.Timeout,
+		"Backoff":     p.Backoff,
+		"MaxBackoff":  p.MaxBackoff,
+		"OpenTimeout": p.OpenTimeout,
+	} {
+		if d == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		if v < 0 {
+			return fmt.Errorf("%s should not be negative", name)
+		}
+	}
+	if p.Retries < 0 {
+		return errors.New("Retries should not be negative")
+	}
+	if p.FailureThreshold < 0 {
+		return errors.New("FailureThreshold should not be negative")
+	}
+	return nil
+}
+
+func parseDuration(d string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(d)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// TimeoutDuration is the default deadline, 0 if not configured
+func (p ClientPolicy) TimeoutDuration() time.Duration {
+	return parseDuration(p.Timeout, 0)
+}
+
+// BackoffDuration is the wait before the first retry
+func (p ClientPolicy) BackoffDuration() time.Duration {
+	return parseDuration(p.Backoff, DefaultClientBackoff)
+}
+
+// MaxBackoffDuration is the longest wait between the retries
+func (p ClientPolicy) MaxBackoffDuration() time.Duration {
+	return parseDuration(p.MaxBackoff, DefaultClientMaxBackoff)
+}
+
+// OpenTimeoutDuration is the time for which the circuit breaker stays open
+func (p ClientPolicy) OpenTimeoutDuration() time.Duration {
+	return parseDuration(p.OpenTimeout, DefaultClientOpenTimeout)
+}
+
+// HTTP configures the HTTP server
+type HTTP struct {
+	Enabled bool        `config:"UseHTTP"`
+	Port    int         `config:"HTTPPort"`
+	Debug   bool        `config:"UseDebug"`
+	Admin   bool        `config:"UseAdmin"`
+	CORS    *CORSConfig `config:"CORS"`
+}
+
+// CORSConfig configures CORS on the HTTP server
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int
+}
+
+// P2P configures the libp2p host and the services using it
+type P2P struct {
+	Enabled            bool      `config:"UseP2P"`
+	SwarmPort          int       `config:"SwarmPort"`
+	Identity           *Identity `config:"Identity"`
+	BootstrapAddresses []string  `config:"BootstrapAddresses"`
+	Files              bool      `config:"UseFiles"`
+	Discovery          string    `config:"Discovery"`
+	SharedStoreNs      string    `config:"SharedStoreNs"`
+}
+
+// Identity is the libp2p identity of the node
+type Identity struct {
+	ID      string
+	PrivKey string
+}
+
+// Auth configures JWT authentication and ACLs
+type Auth struct {
+	Enabled     bool              `config:"UseAuth"`
+	JWTSecret   string            `config:"JWTSecret"`
+	ACL         map[string]string `config:"ACL"`
+	ServiceRole string            `config:"ServiceRole"`
+}
+
+// Locker configures the distributed locker
+type Locker struct {
+	Enabled       bool   `config:"UseLocker"`
+	Type          string `config:"Locker"`
+	ZookeeperHost string `config:"ZookeeperHost"`
+	ZookeeperPort int    `config:"ZookeeperPort"`
+	RedisHost     string `config:"RedisHost"`
+	RedisNetwork  string `config:"RedisNetwork"`
+	ElectionLease int    `config:"ElectionLease"`
+}
+
+// DefaultElectionLease is used if the election lease is not configured
+const DefaultElectionLease = 15 * time.Second
+
+// ElectionLeaseDuration returns the lease of the leader records. ElectionLease
+// is in seconds
+func (l Locker) ElectionLeaseDuration() time.Duration {
+	if l.ElectionLease <= 0 {
+		return DefaultElectionLease
+	}
+	return time.Duration(l.ElectionLease) * time.Second
+}
+
+// Tracing configures the jaeger tracer
+type Tracing struct {
+	Enabled bool   `config:"UseTracing"`
+	Name    string `config:"TracingName"`
+	Host    string `config:"TracingHost"`
+	Backend string `config:"Tracer"`
+}
+
+// Metrics configures prometheus metrics
+type Metrics struct {
+	Prometheus bool `config:"UsePrometheus"`
+	Latency    bool `config:"UsePrometheusLatency"`
+}
+
+// TLS configures the certificates of the TCP gRPC listener, the HTTP server and
+// the static clients. If the client CA is set, the clients are verified using it
+// and the static clients verify the servers using it. With Identity, the
+// certificate is derived from the libp2p identity instead and the peers are
+// verified using their peer IDs
+type TLS struct {
+	Enabled  bool   `config:"UseTLS"`
+	Cert     string `config:"TLSCert"`
+	Key      string `config:"TLSKey"`
+	ClientCA string `config:"TLSClientCA"`
+	Identity bool   `config:"UseTLSIdentity"`
+}
+
+// FromConfig reads the settings from the config
+func FromConfig(c config.Config) (*Settings, error) {
+	s := &Settings{}
+	err := walk(reflect.ValueOf(s).Elem(), func(key string, v reflect.Value) error {
+		if !c.Exists(key) {
+			return nil
+		}
+		if !c.Get(key, v.Addr().Interface()) {
+			return fmt.Errorf("invalid value for %s", key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Apply sets the non-empty settings on the config
+func (s *Settings) Apply(c config.Config) {
+	_ = walk(reflect.ValueOf(s).Elem(), func(key string, v reflect.Value) error {
+		if !v.IsZero() {
+			c.Set(key, v.Interface())
+		}
+		return nil
+	})
+}
+
+// Keys returns the config keys covered by the settings
+func Keys() []string {
+	var keys []string
+	_ = walk(reflect.ValueOf(&Settings{}).Elem(), func(key string, _ reflect.Value) error {
+		keys = append(keys, key)
+		return nil
+	})
+	return keys
+}
+
+// ConfigKeys returns the keys present in the config in sorted order. Configs not
+// implementing config.KeyLister only report the keys covered by the settings
+func ConfigKeys(c config.Config) []string {
+	if kl, ok := c.(config.KeyLister); ok {
+		return kl.Keys()
+	}
+	var keys []string
+	for _, k := range Keys() {
+		if c.Exists(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// walk calls fn for every field with a config key. Struct fields without the
+// tag are treated as sections and walked recursively
+func walk(v reflect.Value, fn func(string, reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, tagged := f.Tag.Lookup("config")
+		if !tagged {
+			if f.Type.Kind() == reflect.Struct {
+				if err := walk(v.Field(i), fn); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := fn(key, v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sections provides the individual sections to the fx graph, so that modules
+// can depend only on the section they use
+type Sections struct {
+	fx.Out
+
+	Settings    *Settings
+	Repo        Repo
+	TaskManager TaskManager
+	Drain       Drain
+	RateLimits  RateLimits
+	GRPC        GRPC
+	HTTP        HTTP
+	P2P         P2P
+	Auth        Auth
+	Locker      Locker
+	Tracing     Tracing
+	Metrics     Metrics
+	TLS         TLS
+}
+
+// Provide reads the settings from the config and provides all the sections
+func Provide(c config.Config) (Sections, error) {
+	s, err := FromConfig(c)
+	if err != nil {
+		return Sections{}, err
+	}
+	return Sections{
+		Settings:    s,
+		Repo:        s.Repo,
+		TaskManager: s.TaskManager,
+		Drain:       s.Drain,
+		RateLimits:  s.RateLimits,
+		GRPC:        s.GRPC,
+		HTTP:        s.HTTP,
+		P2P:         s.P2P,
+		Auth:        s.Auth,
+		Locker:      s.Locker,
+		Tracing:     s.Tracing,
+		Metrics:     s.Metrics,
+		TLS:         s.TLS,
+	}, nil
+}
@@ -0,0 +1,121 @@
+package settings_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/plexsysio/go-msuite/modules/config"
+	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	"github.com/plexsysio/go-msuite/modules/config/schema"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+)
+
+func TestRoundTrip(t *testing.T) {
+	s := settings.Settings{
+		Services:    []string{"svc1", "svc2"},
+		LogLevels:   map[string]string{"*": "debug"},
+		Repo:        settings.Repo{RootPath: "/tmp/msuite"},
+		TaskManager: settings.TaskManager{Min: 2, Max: 10},
+		GRPC: settings.GRPC{
+			Enabled:         true,
+			TCP:             true,
+			TCPPort:         10000,
+			StaticDiscovery: true,
+			StaticAddresses: map[string]string{"svc": "localhost:10001"},
+		},
+		HTTP: settings.HTTP{
+			Enabled: true,
+			Port:    8080,
+			CORS:    &settings.CORSConfig{AllowedOrigins: []string{"*"}},
+		},
+		P2P: settings.P2P{
+			Enabled:   true,
+			SwarmPort: 10002,
+			Identity:  &settings.Identity{ID: "id", PrivKey: "key"},
+		},
+		Auth: settings.Auth{
+			Enabled:   true,
+			JWTSecret: "secret",
+			ACL:       map[string]string{"svc/Method": "admin"},
+		},
+		Locker:  settings.Locker{Enabled: true, Type: "inmem"},
+		Metrics: settings.Metrics{Prometheus: true, Latency: true},
+	}
+
+	c := jsonConf.DefaultConfig()
+	s.Apply(c)
+
+	var port int
+	if !c.Get("TCPPort", &port) || port != 10000 {
+		t.Fatal("incorrect TCPPort in config", port)
+	}
+	if !c.IsSet("UsePrometheusLatency") {
+		t.Fatal("expected UsePrometheusLatency to be set")
+	}
+	if c.Exists("UseUDS") {
+		t.Fatal("zero values should not be applied")
+	}
+
+	// Round trip through the serialized form as well
+	c2 := jsonConf.DefaultConfig()
+	w := c2.Writer()
+	if _, err := w.Write([]byte(c.String())); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := settings.FromConfig(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&s, s2) {
+		t.Fatalf("settings mismatch after round trip\nexp %+v\nfound %+v", s, *s2)
+	}
+}
+
+func TestInvalidValue(t *testing.T) {
+	c := jsonConf.DefaultConfig()
+	c.Set("HTTPPort", "abc")
+	_, err := settings.FromConfig(c)
+	if err == nil {
+		t.Fatal("expected error for invalid value")
+	}
+}
+
+func TestKeysInSchema(t *testing.T) {
+	known := map[string]bool{}
+	for _, k := range schema.Keys {
+		known[k.Name] = true
+	}
+	keys := settings.Keys()
+	if len(keys) != len(known) {
+		t.Fatalf("settings keys %d schema keys %d", len(keys), len(known))
+	}
+	for _, k := range keys {
+		if !known[k] {
+			t.Fatalf("key %s not in schema", k)
+		}
+	}
+}
+
+// noKeys hides the Keys method of the config
+type noKeys struct {
+	config.Config
+}
+
+func TestConfigKeys(t *testing.T) {
+	c := &jsonConf.JsonConfig{}
+	c.Set("UseHTTP", true)
+	c.Set("AppKey", "val")
+
+	if keys := settings.ConfigKeys(c); !reflect.DeepEqual(keys, c.Keys()) {
+		t.Fatal("expected keys of the config", keys)
+	}
+
+	// Without the key listing only the known keys are reported
+	keys := settings.ConfigKeys(noKeys{c})
+	if !reflect.DeepEqual(keys, []string{"UseHTTP"}) {
+		t.Fatal("expected known keys", keys)
+	}
+}
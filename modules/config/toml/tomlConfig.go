@@ -0,0 +1,74 @@
+package tomlConf
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/BurntSushi/toml"
+	"github.com/plexsysio/go-msuite/modules/config/mapconf"
+)
+
+var codec = &mapConf.Codec{
+	Marshal: func(v interface{}) ([]byte, error) {
+		buf := bytes.NewBuffer(nil)
+		err := toml.NewEncoder(buf).Encode(v)
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	},
+	Unmarshal: toml.Unmarshal,
+}
+
+// TomlConfig stores the config in TOML format. Values are converted on Get in the
+// same way as the JSON config, so both can be used interchangeably
+type TomlConfig map[string]interface{}
+
+func (t *TomlConfig) Get(key string, val interface{}) bool {
+	return mapConf.Values(*t).Get(key, val)
+}
+
+func (t *TomlConfig) Set(key string, val interface{}) {
+	(*mapConf.Values)(t).Set(key, val)
+}
+
+func (t *TomlConfig) IsSet(key string) bool {
+	return mapConf.Values(*t).IsSet(key)
+}
+
+func (t *TomlConfig) Exists(key string) bool {
+	return mapConf.Values(*t).Exists(key)
+}
+
+func (t *TomlConfig) Keys() []string {
+	return mapConf.Values(*t).Keys()
+}
+
+func DefaultConfig() *TomlConfig {
+	var conf = make(TomlConfig)
+	return &conf
+}
+
+func FromFile(f string) (*TomlConfig, error) {
+	t := &TomlConfig{}
+	if err := codec.FromFile(f, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *TomlConfig) String() string {
+	return codec.String(t)
+}
+
+func (t *TomlConfig) Pretty() string {
+	return codec.Pretty(t)
+}
+
+func (t *TomlConfig) Reader() (io.Reader, error) {
+	return codec.Reader(t)
+}
+
+func (t *TomlConfig) Writer() io.WriteCloser {
+	return codec.Writer(t)
+}
@@ -0,0 +1,65 @@
+package tomlConf_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/plexsysio/go-msuite/modules/config/toml"
+	"github.com/plexsysio/go-msuite/utils"
+)
+
+func TestConfig(t *testing.T) {
+	conf := tomlConf.DefaultConfig()
+	conf.Set("Name", "dummy")
+	conf.Set("Int", 10)
+	conf.Set("Bool", true)
+	conf.Set("StrArray", []string{"hello", "world"})
+	conf.Set("Mounts", map[string]interface{}{
+		"level": map[string]interface{}{
+			"path":   "kv",
+			"prefix": "/",
+		},
+	})
+
+	rdr, err := conf.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = utils.WriteToFile(rdr, "newfile.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll("newfile.toml")
+
+	newConf, err := tomlConf.FromFile("newfile.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.String() != newConf.String() {
+		t.Fatal("string values dont match expected:", conf.String(), "found:", newConf.String())
+	}
+
+	var intVal int
+	if !newConf.Get("Int", &intVal) || intVal != 10 {
+		t.Fatal("incorrect int value after decoding", intVal)
+	}
+	var strArray []string
+	if !newConf.Get("StrArray", &strArray) || !reflect.DeepEqual(strArray, []string{"hello", "world"}) {
+		t.Fatal("incorrect array after decoding", strArray)
+	}
+	mnts := map[string]map[string]string{}
+	if !newConf.Get("Mounts", &mnts) || mnts["level"]["prefix"] != "/" {
+		t.Fatal("incorrect nested map after decoding", mnts)
+	}
+	if !newConf.IsSet("Bool") {
+		t.Fatal("expected Bool to be set after decoding")
+	}
+
+	if _, err := tomlConf.FromFile("absent.toml"); err == nil {
+		t.Fatal("expected error for absent file")
+	}
+}
@@ -0,0 +1,65 @@
+package yamlConf
+
+import (
+	"github.com/plexsysio/go-msuite/modules/config/mapconf"
+	"gopkg.in/yaml.v3"
+	"io"
+)
+
+var codec = &mapConf.Codec{
+	Marshal:   yaml.Marshal,
+	Unmarshal: yaml.Unmarshal,
+}
+
+// YamlConfig stores the config in YAML format. Values are converted on Get in the
+// same way as the JSON config, so both can be used interchangeably
+type YamlConfig map[string]interface{}
+
+func (y *YamlConfig) Get(key string, val interface{}) bool {
+	return mapConf.Values(*y).Get(key, val)
+}
+
+func (y *YamlConfig) Set(key string, val interface{}) {
+	(*mapConf.Values)(y).Set(key, val)
+}
+
+func (y *YamlConfig) IsSet(key string) bool {
+	return mapConf.Values(*y).IsSet(key)
+}
+
+func (y *YamlConfig) Exists(key string) bool {
+	return mapConf.Values(*y).Exists(key)
+}
+
+func (y *YamlConfig) Keys() []string {
+	return mapConf.Values(*y).Keys()
+}
+
+func DefaultConfig() *YamlConfig {
+	var conf = make(YamlConfig)
+	return &conf
+}
+
+func FromFile(f string) (*YamlConfig, error) {
+	y := &YamlConfig{}
+	if err := codec.FromFile(f, y); err != nil {
+		return nil, err
+	}
+	return y, nil
+}
+
+func (y *YamlConfig) String() string {
+	return codec.String(y)
+}
+
+func (y *YamlConfig) Pretty() string {
+	return codec.Pretty(y)
+}
+
+func (y *YamlConfig) Reader() (io.Reader, error) {
+	return codec.Reader(y)
+}
+
+func (y *YamlConfig) Writer() io.WriteCloser {
+	return codec.Writer(y)
+}
@@ -0,0 +1,227 @@
+// Package admin provides the operations used to manage a node and its repository.
+// The operations can be performed directly on a repository or on a running node
+// using the HTTP handlers registered on the node.
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/plexsysio/go-msuite/modules/auth"
+	"github.com/plexsysio/go-msuite/modules/config/loader"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/node/validate"
+	"github.com/plexsysio/go-msuite/modules/repo"
+)
+
+var (
+	// ErrKeyNotFound is returned if the config key is not present
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrP2PDisabled is returned for operations which need the libp2p host
+	ErrP2PDisabled = errors.New("p2p is not enabled")
+	// ErrSecretKey is returned on updates of the secret keys
+	ErrSecretKey = errors.New("secret keys cannot be updated")
+)
+
+// Redacted replaces the values of the secret keys
+const Redacted = "REDACTED"
+
+// secretKeys are the keys which are redacted and cannot be updated using the
+// admin operations. For objects, only the fields listed are redacted
+var secretKeys = map[string][]string{
+	"JWTSecret": nil,
+	"TLSKey":    nil,
+	"Identity":  {"PrivKey"},
+}
+
+// redact replaces the secret value of the key
+func redact(key string, v interface{}) interface{} {
+	fields, secret := secretKeys[key]
+	if !secret {
+		return v
+	}
+	if len(fields) == 0 {
+		return Redacted
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return Redacted
+	}
+	for _, f := range fields {
+		if _, found := obj[f]; found {
+			obj[f] = Redacted
+		}
+	}
+	return obj
+}
+
+// IDInfo is the identity of the node
+type IDInfo struct {
+	ID        string
+	Addresses []string `json:",omitempty"`
+}
+
+// PeerInfo is a peer connected to the node
+type PeerInfo struct {
+	ID        string
+	Addresses []string
+}
+
+// Admin is the set of operations to manage a node
+type Admin interface {
+	// Config returns all the config values. The secret values are redacted
+	Config(ctx context.Context) (map[string]interface{}, error)
+	// GetConfig returns the value of the config key. The secret values are
+	// redacted
+	GetConfig(ctx context.Context, key string) (interface{}, error)
+	// SetConfig parses the value based on the key type and updates the config.
+	// The secret keys cannot be updated
+	SetConfig(ctx context.Context, key, value string) error
+	ID(ctx context.Context) (IDInfo, error)
+	RepoStat(ctx context.Context) (interface{}, error)
+	ACL(ctx context.Context) (map[string]string, error)
+	SetACL(ctx context.Context, resource string, role auth.Role) error
+	DeleteACL(ctx context.Context, resource string) error
+	Peers(ctx context.Context) ([]PeerInfo, error)
+}
+
+type impl struct {
+	r repo.Repo
+	h host.Host
+}
+
+// New returns the Admin operating on the repository. The host is optional and
+// is used to report the addresses and peers of a running node
+func New(r repo.Repo, h host.Host) Admin {
+	return &impl{r: r, h: h}
+}
+
+func (a *impl) Config(_ context.Context) (map[string]interface{}, error) {
+	c := a.r.Config()
+	vals := make(map[string]interface{})
+	for _, k := range settings.ConfigKeys(c) {
+		var v interface{}
+		if !c.Get(k, &v) {
+			return nil, fmt.Errorf("failed reading key %s", k)
+		}
+		vals[k] = redact(k, v)
+	}
+	return vals, nil
+}
+
+func (a *impl) GetConfig(_ context.Context, key string) (interface{}, error) {
+	c := a.r.Config()
+	if !c.Exists(key) {
+		return nil, ErrKeyNotFound
+	}
+	var v interface{}
+	if !c.Get(key, &v) {
+		return nil, fmt.Errorf("failed reading key %s", key)
+	}
+	return redact(key, v), nil
+}
+
+func (a *impl) SetConfig(_ context.Context, key, value string) error {
+	key, val, err := loader.Value(key, value)
+	if err != nil {
+		return err
+	}
+	if _, secret := secretKeys[key]; secret {
+		return fmt.Errorf("%s: %w", key, ErrSecretKey)
+	}
+	return a.update(func(c map[string]interface{}) {
+		c[key] = val
+	})
+}
+
+func (a *impl) ID(_ context.Context) (IDInfo, error) {
+	if a.h != nil {
+		info := IDInfo{ID: a.h.ID().Pretty()}
+		for _, addr := range a.h.Addrs() {
+			info.Addresses = append(info.Addresses, addr.String())
+		}
+		return info, nil
+	}
+	id := map[string]interface{}{}
+	if !a.r.Config().Get("Identity", &id) {
+		return IDInfo{}, errors.New("identity not configured")
+	}
+	pid, ok := id["ID"].(string)
+	if !ok {
+		return IDInfo{}, errors.New("identity not configured")
+	}
+	return IDInfo{ID: pid}, nil
+}
+
+func (a *impl) RepoStat(_ context.Context) (interface{}, error) {
+	return a.r.Status(), nil
+}
+
+func (a *impl) ACL(_ context.Context) (map[string]string, error) {
+	acls := map[string]string{}
+	_ = a.r.Config().Get("ACL", &acls)
+	return acls, nil
+}
+
+func (a *impl) SetACL(ctx context.Context, resource string, role auth.Role) error {
+	if !auth.ValidRole(role) {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	acls, err := a.ACL(ctx)
+	if err != nil {
+		return err
+	}
+	acls[resource] = string(role)
+	return a.update(func(c map[string]interface{}) {
+		c["ACL"] = acls
+	})
+}
+
+func (a *impl) DeleteACL(ctx context.Context, resource string) error {
+	acls, err := a.ACL(ctx)
+	if err != nil {
+		return err
+	}
+	if _, found := acls[resource]; !found {
+		return ErrKeyNotFound
+	}
+	delete(acls, resource)
+	return a.update(func(c map[string]interface{}) {
+		c["ACL"] = acls
+	})
+}
+
+func (a *impl) Peers(_ context.Context) ([]PeerInfo, error) {
+	if a.h == nil {
+		return nil, ErrP2PDisabled
+	}
+	peers := []PeerInfo{}
+	for _, p := range a.h.Network().Peers() {
+		info := PeerInfo{ID: p.Pretty()}
+		for _, addr := range a.h.Peerstore().Addrs(p) {
+			info.Addresses = append(info.Addresses, addr.String())
+		}
+		peers = append(peers, info)
+	}
+	return peers, nil
+}
+
+// update applies the changes on a copy of the config. The config is saved only
+// if it is valid, so the repository is never left with an invalid config
+func (a *impl) update(apply func(map[string]interface{})) error {
+	c, err := loader.Clone(a.r.Config())
+	if err != nil {
+		return err
+	}
+	vals := map[string]interface{}{}
+	apply(vals)
+	if err := loader.Apply(c, loader.Map(vals)); err != nil {
+		return err
+	}
+	if err := validate.Config(c); err != nil {
+		return err
+	}
+	return a.r.SetConfig(c)
+}
@@ -0,0 +1,214 @@
+package admin_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/plexsysio/go-msuite/modules/auth"
+	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	"github.com/plexsysio/go-msuite/modules/diag/admin"
+	"github.com/plexsysio/go-msuite/modules/repo"
+	"github.com/plexsysio/go-msuite/modules/repo/inmem"
+)
+
+func TestAdmin(t *testing.T) {
+	c := jsonConf.DefaultConfig()
+	c.Set("UseHTTP", true)
+	c.Set("HTTPPort", 8080)
+	c.Set("JWTSecret", "secret")
+
+	r, err := inmem.CreateOrOpen(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	mux := http.NewServeMux()
+	admin.Register(admin.New(r, nil), mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for name, a := range map[string]admin.Admin{
+		"local":  admin.New(r, nil),
+		"remote": admin.NewClient(srv.URL, ""),
+	} {
+		t.Run(name, func(t *testing.T) {
+			testAdmin(t, a, r)
+		})
+	}
+}
+
+func testAdmin(t *testing.T, a admin.Admin, r repo.Repo) {
+	ctx := context.Background()
+
+	info, err := a.ID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := map[string]interface{}{}
+	r.Config().Get("Identity", &id)
+	if info.ID != id["ID"] {
+		t.Fatal("incorrect ID", info.ID)
+	}
+
+	err = a.SetConfig(ctx, "http-port", "9090")
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := a.GetConfig(ctx, "HTTPPort")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.(float64) != 9090 {
+		t.Fatal("incorrect value", val)
+	}
+	_, err = a.GetConfig(ctx, "UseTCP")
+	if !errors.Is(err, admin.ErrKeyNotFound) {
+		t.Fatal("expected key not found", err)
+	}
+	val, err = a.GetConfig(ctx, "JWTSecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != admin.Redacted {
+		t.Fatal("expected secret to be redacted", val)
+	}
+	val, err = a.GetConfig(ctx, "Identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.(map[string]interface{})["PrivKey"] != admin.Redacted ||
+		val.(map[string]interface{})["ID"] != id["ID"] {
+		t.Fatal("expected private key to be redacted", val)
+	}
+	// Secret keys cannot be updated
+	for _, k := range []string{"jwt-secret", "TLSKey"} {
+		err = a.SetConfig(ctx, k, "updated")
+		if err == nil {
+			t.Fatal("expected error updating secret", k)
+		}
+	}
+	var secret string
+	if !r.Config().Get("JWTSecret", &secret) || secret != "secret" {
+		t.Fatal("secret should not be updated", secret)
+	}
+	// Invalid config is not saved
+	err = a.SetConfig(ctx, "UseTCP", "true")
+	if err == nil {
+		t.Fatal("expected error for invalid config")
+	}
+	if r.Config().Exists("UseTCP") {
+		t.Fatal("invalid config should not be saved")
+	}
+	vals, err := a.Config(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals["UseHTTP"] != true {
+		t.Fatal("incorrect config", vals)
+	}
+	if vals["JWTSecret"] != admin.Redacted ||
+		vals["Identity"].(map[string]interface{})["PrivKey"] != admin.Redacted {
+		t.Fatal("expected secrets to be redacted", vals)
+	}
+
+	err = a.SetACL(ctx, "/admin/config", auth.Admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.SetACL(ctx, "/admin/id", auth.Role("invalid"))
+	if err == nil {
+		t.Fatal("expected error for invalid role")
+	}
+	acls, err := a.ACL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acls) != 1 || acls["/admin/config"] != string(auth.Admin) {
+		t.Fatal("incorrect ACLs", acls)
+	}
+	err = a.DeleteACL(ctx, "/admin/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.DeleteACL(ctx, "/admin/config")
+	if !errors.Is(err, admin.ErrKeyNotFound) {
+		t.Fatal("expected key not found", err)
+	}
+
+	_, err = a.Peers(ctx)
+	if !errors.Is(err, admin.ErrP2PDisabled) {
+		t.Fatal("expected p2p disabled", err)
+	}
+}
+
+type adminUser struct {
+	role auth.Role
+}
+
+func (u adminUser) ID() string                   { return "user" }
+func (u adminUser) Role() string                 { return string(u.role) }
+func (u adminUser) Mtdt() map[string]interface{} { return nil }
+
+func TestAdminAuth(t *testing.T) {
+	c := jsonConf.DefaultConfig()
+	c.Set("JWTSecret", "secret")
+
+	r, err := inmem.CreateOrOpen(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	jm, err := auth.NewJWTManager(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	admin.RegisterHTTP(admin.AdminIn{Mux: mux, R: r, Jm: jm})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	token := func(role auth.Role) string {
+		t.Helper()
+		tkn, err := jm.Generate(adminUser{role: role}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tkn
+	}
+
+	for name, tkn := range map[string]string{
+		"no token":   "",
+		"other role": token(auth.AuthWrite),
+	} {
+		t.Run(name, func(t *testing.T) {
+			a := admin.NewClient(srv.URL, tkn)
+			if _, err := a.Config(context.Background()); err == nil {
+				t.Fatal("expected config to be denied")
+			}
+			if err := a.SetConfig(context.Background(), "http-port", "9090"); err == nil {
+				t.Fatal("expected update to be denied")
+			}
+			if r.Config().Exists("HTTPPort") {
+				t.Fatal("config should not be updated")
+			}
+		})
+	}
+
+	a := admin.NewClient(srv.URL, token(auth.Admin))
+	vals, err := a.Config(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals["JWTSecret"] != admin.Redacted {
+		t.Fatal("expected secret to be redacted", vals["JWTSecret"])
+	}
+	if err := a.SetConfig(context.Background(), "http-port", "9090"); err != nil {
+		t.Fatal(err)
+	}
+}
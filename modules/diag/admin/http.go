@@ -0,0 +1,269 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/plexsysio/go-msuite/modules/auth"
+	"github.com/plexsysio/go-msuite/modules/repo"
+	"go.uber.org/fx"
+)
+
+// Paths of the admin handlers
+const (
+	ConfigPath = "/admin/config"
+	IDPath     = "/admin/id"
+	RepoPath   = "/admin/repo"
+	ACLPath    = "/admin/acl"
+	PeersPath  = "/admin/peers"
+)
+
+type AdminIn struct {
+	fx.In
+
+	Mux *http.ServeMux
+	R   repo.Repo
+	H   host.Host       `name:"mainHost" optional:"true"`
+	Jm  auth.JWTManager `optional:"true"`
+}
+
+// RegisterHTTP registers the admin handlers on the HTTP server of the node. If
+// auth is enabled, the handlers are only allowed for the Admin role
+func RegisterHTTP(in AdminIn) {
+	if in.Jm == nil {
+		Register(New(in.R, in.H), in.Mux)
+		return
+	}
+	mux := http.NewServeMux()
+	Register(New(in.R, in.H), mux)
+	in.Mux.Handle("/admin/", requireAdmin(in.Jm, mux))
+}
+
+// requireAdmin allows only the requests with a token of the Admin role
+func requireAdmin(jm auth.JWTManager, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenArr := strings.Split(r.Header.Get("Authorization"), " ")
+		if len(tokenArr) != 2 {
+			http.Error(w, "token is absent", http.StatusUnauthorized)
+			return
+		}
+		claims, err := jm.Verify(tokenArr[1])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed verifying token: %s", err.Error()), http.StatusUnauthorized)
+			return
+		}
+		if claims.Role != string(auth.Admin) {
+			http.Error(w, "admin role required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Register registers the handlers for the Admin operations on the mux
+func Register(a Admin, mux *http.ServeMux) {
+	mux.HandleFunc(ConfigPath, func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		switch r.Method {
+		case http.MethodGet:
+			if key == "" {
+				writeResponse(w)(a.Config(r.Context()))
+				return
+			}
+			writeResponse(w)(a.GetConfig(r.Context(), key))
+		case http.MethodPut:
+			if key == "" {
+				http.Error(w, "key not provided", http.StatusBadRequest)
+				return
+			}
+			val, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "failed reading value Err:"+err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeError(w, a.SetConfig(r.Context(), key, string(val)))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc(IDPath, func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w)(a.ID(r.Context()))
+	})
+	mux.HandleFunc(RepoPath, func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w)(a.RepoStat(r.Context()))
+	})
+	mux.HandleFunc(ACLPath, func(w http.ResponseWriter, r *http.Request) {
+		rsc := r.URL.Query().Get("resource")
+		switch r.Method {
+		case http.MethodGet:
+			writeResponse(w)(a.ACL(r.Context()))
+		case http.MethodPut:
+			role := r.URL.Query().Get("role")
+			if rsc == "" || role == "" {
+				http.Error(w, "resource or role not provided", http.StatusBadRequest)
+				return
+			}
+			writeError(w, a.SetACL(r.Context(), rsc, auth.Role(role)))
+		case http.MethodDelete:
+			if rsc == "" {
+				http.Error(w, "resource not provided", http.StatusBadRequest)
+				return
+			}
+			writeError(w, a.DeleteACL(r.Context(), rsc))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc(PeersPath, func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w)(a.Peers(r.Context()))
+	})
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrP2PDisabled):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	case errors.Is(err, ErrSecretKey):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func writeResponse(w http.ResponseWriter) func(interface{}, error) {
+	return func(resp interface{}, err error) {
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		buf, err := json.MarshalIndent(resp, "", "\t")
+		if err != nil {
+			http.Error(w, "Failed to encode response Err:"+err.Error(),
+				http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(buf)
+	}
+}
+
+type client struct {
+	addr  string
+	token string
+	c     *http.Client
+}
+
+// NewClient returns the Admin which uses the handlers on a running node. The
+// token is sent as bearer token if provided
+func NewClient(addr, token string) Admin {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &client{
+		addr:  strings.TrimSuffix(addr, "/"),
+		token: token,
+		c:     &http.Client{},
+	}
+}
+
+func (c *client) do(
+	ctx context.Context,
+	method, path string,
+	query url.Values,
+	body string,
+	resp interface{},
+) error {
+	u := c.addr + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	res, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	case http.StatusNotFound:
+		return ErrKeyNotFound
+	case http.StatusNotImplemented:
+		return ErrP2PDisabled
+	default:
+		msg, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("request failed with status %d: %s", res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+func (c *client) Config(ctx context.Context) (map[string]interface{}, error) {
+	vals := map[string]interface{}{}
+	err := c.do(ctx, http.MethodGet, ConfigPath, nil, "", &vals)
+	return vals, err
+}
+
+func (c *client) GetConfig(ctx context.Context, key string) (interface{}, error) {
+	var val interface{}
+	err := c.do(ctx, http.MethodGet, ConfigPath, url.Values{"key": {key}}, "", &val)
+	return val, err
+}
+
+func (c *client) SetConfig(ctx context.Context, key, value string) error {
+	return c.do(ctx, http.MethodPut, ConfigPath, url.Values{"key": {key}}, value, nil)
+}
+
+func (c *client) ID(ctx context.Context) (IDInfo, error) {
+	var info IDInfo
+	err := c.do(ctx, http.MethodGet, IDPath, nil, "", &info)
+	return info, err
+}
+
+func (c *client) RepoStat(ctx context.Context) (interface{}, error) {
+	var stat interface{}
+	err := c.do(ctx, http.MethodGet, RepoPath, nil, "", &stat)
+	return stat, err
+}
+
+func (c *client) ACL(ctx context.Context) (map[string]string, error) {
+	acls := map[string]string{}
+	err := c.do(ctx, http.MethodGet, ACLPath, nil, "", &acls)
+	return acls, err
+}
+
+func (c *client) SetACL(ctx context.Context, resource string, role auth.Role) error {
+	return c.do(ctx, http.MethodPut, ACLPath, url.Values{
+		"resource": {resource},
+		"role":     {string(role)},
+	}, "", nil)
+}
+
+func (c *client) DeleteACL(ctx context.Context, resource string) error {
+	return c.do(ctx, http.MethodDelete, ACLPath, url.Values{"resource": {resource}}, "", nil)
+}
+
+func (c *client) Peers(ctx context.Context) ([]PeerInfo, error) {
+	var peers []PeerInfo
+	err := c.do(ctx, http.MethodGet, PeersPath, nil, "", &peers)
+	return peers, err
+}
@@ -3,10 +3,14 @@ package metrics
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 
 	gtrace "github.com/moxiaomomo/grpc-jaeger"
 	"github.com/opentracing/opentracing-go"
 	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/utils"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"go.uber.org/fx"
@@ -25,17 +29,60 @@ func New() *prometheus.Registry {
 	return r
 }
 
-func NewTracer(lc fx.Lifecycle, conf config.Config) (opentracing.Tracer, error) {
-	svcName := "default"
-	conf.Get("TracingName", &svcName)
+// TracerConstructor creates the tracer using the config. The closer is called
+// when the node is stopped
+type TracerConstructor func(settings.Tracing) (opentracing.Tracer, io.Closer, error)
 
-	var tHost string
-	ok := conf.Get("TracingHost", &tHost)
-	if !ok {
-		return nil, errors.New("Tracing host not specified")
+var tracers = utils.NewRegistry("tracer")
+
+// DefaultTracer is the tracer used if not configured
+const DefaultTracer = "jaeger"
+
+// RegisterTracer adds the tracer backend
+func RegisterTracer(name string, ctor TracerConstructor) {
+	tracers.Register(name, ctor)
+}
+
+// Tracers returns the names of the registered tracer backends
+func Tracers() []string {
+	return tracers.Names()
+}
+
+func init() {
+	RegisterTracer(DefaultTracer, func(trCfg settings.Tracing) (opentracing.Tracer, io.Closer, error) {
+		if trCfg.Host == "" {
+			return nil, nil, errors.New("Tracing host not specified")
+		}
+		return gtrace.NewJaegerTracer(trCfg.Name, trCfg.Host)
+	})
+}
+
+// ValidateTracer checks that the configured tracer backend is registered
+func ValidateTracer(c config.Config) error {
+	var name string
+	if !c.Get("Tracer", &name) {
+		return nil
+	}
+	if _, err := tracers.Get(name); err != nil {
+		return fmt.Errorf("Tracer: %w", err)
+	}
+	return nil
+}
+
+func NewTracer(lc fx.Lifecycle, trCfg settings.Tracing) (opentracing.Tracer, error) {
+	if trCfg.Name == "" {
+		trCfg.Name = "default"
+	}
+	if trCfg.Backend == "" {
+		trCfg.Backend = DefaultTracer
+	}
+
+	ctor, err := tracers.Get(trCfg.Backend)
+	if err != nil {
+		return nil, err
 	}
 
-	tracer, closer, err := gtrace.NewJaegerTracer(svcName, tHost)
+	tracer, closer, err := ctor.(TracerConstructor)(trCfg)
 	if err != nil {
 		return nil, err
 	}
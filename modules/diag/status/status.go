@@ -10,17 +10,63 @@ type Reporter interface {
 	Status() interface{}
 }
 
+// Health of a reporter
+type Health string
+
+const (
+	OK       Health = "ok"
+	Degraded Health = "degraded"
+	Failed   Health = "failed"
+)
+
+var healthOrder = map[Health]int{
+	OK:       0,
+	Degraded: 1,
+	Failed:   2,
+}
+
+// Worse returns true if h is worse than other
+func (h Health) Worse(other Health) bool {
+	return healthOrder[h] > healthOrder[other]
+}
+
+// HealthReporter can be implemented by reporters to report their health. This
+// is used for the liveness check
+type HealthReporter interface {
+	Health() Health
+}
+
+// ReadinessReporter can be implemented by reporters to report if they are ready
+// to serve. This is used for the readiness check
+type ReadinessReporter interface {
+	Ready() bool
+}
+
 type Manager interface {
 	AddReporter(string, Reporter)
 	Status() map[string]interface{}
+	// Health returns the worst health reported along with the health of each
+	// reporter implementing HealthReporter
+	Health() (Health, map[string]Health)
+	// Ready returns true if all the reporters implementing ReadinessReporter are
+	// ready along with the readiness of each of them. It returns false once the
+	// node is draining
+	Ready() (bool, map[string]bool)
+	// Drain marks the node as draining before it is stopped. It is safe to call
+	// multiple times
+	Drain()
+	// Draining returns a channel which is closed once the node starts draining
+	Draining() <-chan struct{}
 }
 
 type impl struct {
-	mp sync.Map
+	mp        sync.Map
+	drainOnce sync.Once
+	draining  chan struct{}
 }
 
 func New() Manager {
-	return new(impl)
+	return &impl{draining: make(chan struct{})}
 }
 
 func (m *impl) AddReporter(key string, reporter Reporter) {
@@ -36,15 +82,106 @@ func (m *impl) Status() map[string]interface{} {
 	return retStatus
 }
 
+func (m *impl) Health() (Health, map[string]Health) {
+	health := OK
+	reporters := make(map[string]Health)
+	m.mp.Range(func(k, v interface{}) bool {
+		hr, ok := v.(HealthReporter)
+		if !ok {
+			return true
+		}
+		h := hr.Health()
+		reporters[k.(string)] = h
+		if h.Worse(health) {
+			health = h
+		}
+		return true
+	})
+	return health, reporters
+}
+
+func (m *impl) Ready() (bool, map[string]bool) {
+	ready := !m.isDraining()
+	reporters := make(map[string]bool)
+	m.mp.Range(func(k, v interface{}) bool {
+		rr, ok := v.(ReadinessReporter)
+		if !ok {
+			return true
+		}
+		r := rr.Ready()
+		reporters[k.(string)] = r
+		ready = ready && r
+		return true
+	})
+	return ready, reporters
+}
+
+func (m *impl) Drain() {
+	m.drainOnce.Do(func() {
+		close(m.draining)
+	})
+}
+
+func (m *impl) Draining() <-chan struct{} {
+	return m.draining
+}
+
+func (m *impl) isDraining() bool {
+	select {
+	case <-m.draining:
+		return true
+	default:
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, code int, val interface{}) {
+	buf, err := json.MarshalIndent(val, "", "\t")
+	if err != nil {
+		http.Error(w, "Failed to get status Err:"+err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(buf)
+}
+
+// RegisterHTTP registers the /status endpoint along with /healthz and /readyz
+// which can be used for liveness and readiness probes. /healthz fails only if
+// some reporter has failed, degraded reporters are still considered live. /readyz
+// fails once the node starts draining
 func RegisterHTTP(m Manager, mux *http.ServeMux) {
 	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-		buf, err := json.MarshalIndent(m.Status(), "", "\t")
-		if err != nil {
-			http.Error(w, "Failed to get status Err:"+err.Error(),
-				http.StatusInternalServerError)
-			return
+		writeJSON(w, http.StatusOK, m.Status())
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		health, reporters := m.Health()
+		code := http.StatusOK
+		if health == Failed {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, map[string]interface{}{
+			"Status":    health,
+			"Reporters": reporters,
+		})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ready, reporters := m.Ready()
+		code := http.StatusOK
+		if !ready {
+			code = http.StatusServiceUnavailable
+		}
+		draining := false
+		select {
+		case <-m.Draining():
+			draining = true
+		default:
 		}
-		w.Header().Set("Content-Type", "application/json")
-		_, _ = w.Write(buf)
+		writeJSON(w, code, map[string]interface{}{
+			"Ready":     ready,
+			"Draining":  draining,
+			"Reporters": reporters,
+		})
 	})
 }
@@ -0,0 +1,109 @@
+package status_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/plexsysio/go-msuite/modules/diag/status"
+)
+
+type reporter struct {
+	health status.Health
+	ready  bool
+}
+
+func (r *reporter) Status() interface{} { return "status" }
+
+func (r *reporter) Health() status.Health { return r.health }
+
+func (r *reporter) Ready() bool { return r.ready }
+
+type plainReporter struct{}
+
+func (plainReporter) Status() interface{} { return "plain" }
+
+func TestHealthAndReadiness(t *testing.T) {
+	m := status.New()
+	r1 := &reporter{health: status.OK, ready: true}
+	r2 := &reporter{health: status.OK, ready: true}
+	m.AddReporter("r1", r1)
+	m.AddReporter("r2", r2)
+	m.AddReporter("plain", plainReporter{})
+
+	mux := http.NewServeMux()
+	status.RegisterHTTP(m, mux)
+
+	check := func(path string, code int, key string, val interface{}) {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != code {
+			t.Fatalf("%s: expected code %d found %d", path, code, rec.Code)
+		}
+		resp := map[string]interface{}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp[key] != val {
+			t.Fatalf("%s: expected %s %v found %v", path, key, val, resp[key])
+		}
+		if _, found := resp["Reporters"].(map[string]interface{})["plain"]; found {
+			t.Fatalf("%s: reporter without health or readiness reported", path)
+		}
+	}
+
+	check("/healthz", http.StatusOK, "Status", "ok")
+	check("/readyz", http.StatusOK, "Ready", true)
+
+	r1.health = status.Degraded
+	r2.ready = false
+	check("/healthz", http.StatusOK, "Status", "degraded")
+	check("/readyz", http.StatusServiceUnavailable, "Ready", false)
+
+	r2.health = status.Failed
+	check("/healthz", http.StatusServiceUnavailable, "Status", "failed")
+
+	health, reporters := m.Health()
+	if health != status.Failed || reporters["r1"] != status.Degraded || reporters["r2"] != status.Failed {
+		t.Fatal("incorrect health", health, reporters)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	m := status.New()
+	m.AddReporter("r1", &reporter{health: status.OK, ready: true})
+
+	if ready, _ := m.Ready(); !ready {
+		t.Fatal("expected ready before draining")
+	}
+
+	m.Drain()
+	m.Drain()
+
+	select {
+	case <-m.Draining():
+	default:
+		t.Fatal("expected draining channel to be closed")
+	}
+	ready, reporters := m.Ready()
+	if ready || !reporters["r1"] {
+		t.Fatal("expected not ready while draining", ready, reporters)
+	}
+
+	mux := http.NewServeMux()
+	status.RegisterHTTP(m, mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatal("expected readyz to fail while draining", rec.Code)
+	}
+	// Liveness is not affected by draining
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatal("expected healthz to pass while draining", rec.Code)
+	}
+}
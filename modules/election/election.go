@@ -0,0 +1,625 @@
+// Package election provides leader election over the distributed locker. The
+// leader holds the lock of the election till it resigns or the node is stopped.
+// The locks are held using the context of the manager, so that the lockers
+// extending the locks in the background are not stopped by the campaigns. If the
+// locker implements locker.LeaseLocker, the leader is demoted as soon as its lock
+// is lost.
+// The leader also maintains a record with a lease in the store, which is used by
+// the other nodes to observe the leader. If the store is shared across the nodes,
+// all of them can see the current leader. Otherwise the leader is only known to
+// itself.
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	logger "github.com/ipfs/go-log/v2"
+	"github.com/plexsysio/dLocker"
+	store "github.com/plexsysio/gkvstore"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/diag/status"
+	"github.com/plexsysio/go-msuite/modules/node/locker"
+	"github.com/plexsysio/go-msuite/modules/repo"
+	"github.com/plexsysio/go-msuite/modules/sharedStorage"
+	"go.uber.org/fx"
+)
+
+var log = logger.Logger("election")
+
+var (
+	// ErrClosed is returned once the manager is closed
+	ErrClosed = errors.New("elections closed")
+	// ErrInvalidName is returned for an empty election name
+	ErrInvalidName = errors.New("election name cannot be empty")
+)
+
+// ns is the namespace of the leader records in the store
+const ns = "election"
+
+// Leader is the leader of an election as seen by this node. The ID is empty if
+// there is no leader or the lease of the last leader has expired
+type Leader struct {
+	ID      string
+	Expires time.Time
+}
+
+// Election is a named election between the nodes sharing the locker
+type Election interface {
+	Name() string
+	// Campaign blocks till this node is elected or the context is done. The
+	// context only bounds the wait, the leadership is held till Resign is called,
+	// the lock is lost or the node is stopped
+	Campaign(context.Context) error
+	// Resign gives up the leadership. It is a no-op if this node is not the
+	// leader
+	Resign(context.Context) error
+	// IsLeader returns true if this node is the leader
+	IsLeader() bool
+	// Leader returns the current leader
+	Leader(context.Context) (Leader, error)
+	// Observe sends the current leader followed by the changes till the context
+	// is done
+	Observe(context.Context) <-chan Leader
+	// OnElected registers a callback called when this node is elected. The
+	// context passed is cancelled once the leadership is lost
+	OnElected(func(context.Context))
+	// OnResigned registers a callback called when this node loses the leadership,
+	// either on Resign or if the lock is lost
+	OnResigned(func())
+}
+
+// Status is the status of an election reported on the diagnostic endpoint
+type Status struct {
+	Leader   string
+	IsLeader bool
+	Since    *time.Time `json:",omitempty"`
+	Error    string     `json:",omitempty"`
+}
+
+// Manager creates the elections. Each election is created once and shared by
+// all its users
+type Manager struct {
+	lk    dLocker.DLocker
+	st    store.Store
+	id    string
+	lease time.Duration
+
+	// ctx is used for the locks, so they are held till the manager is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mtx       sync.Mutex
+	elections map[string]*election
+	stopped   chan struct{}
+}
+
+// NewManager returns the elections using the locker. The leader records are
+// saved in the store with the ID of this node and are renewed till the lease
+func NewManager(lk dLocker.DLocker, st store.Store, id string, lease time.Duration) *Manager {
+	if lease <= 0 {
+		lease = settings.DefaultElectionLease
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		lk:        lk,
+		st:        st,
+		id:        id,
+		lease:     lease,
+		ctx:       ctx,
+		cancel:    cancel,
+		elections: make(map[string]*election),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// New is the constructor used by the node. The shared storage is used for the
+// records if P2P is enabled, otherwise the records are in the repository store
+func New(
+	lc fx.Lifecycle,
+	lk dLocker.DLocker,
+	r repo.Repo,
+	p2pCfg settings.P2P,
+	lkCfg settings.Locker,
+	st status.Manager,
+	shStore sharedStorage.Provider,
+) (*Manager, error) {
+	if p2pCfg.Identity == nil || p2pCfg.Identity.ID == "" {
+		return nil, errors.New("identity not configured")
+	}
+	recs := r.Store()
+	if shStore != nil {
+		var err error
+		recs, err = shStore.SharedStorage("/"+ns, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	m := NewManager(lk, recs, p2pCfg.Identity.ID, lkCfg.ElectionLeaseDuration())
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			log.Debugf("stopping elections")
+			defer log.Debugf("stopped elections")
+			return m.Close(ctx)
+		},
+	})
+	st.AddReporter("Elections", m)
+	return m, nil
+}
+
+// Election returns the election with the name
+func (m *Manager) Election(name string) (Election, error) {
+	if name == "" {
+		return nil, ErrInvalidName
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	select {
+	case <-m.stopped:
+		return nil, ErrClosed
+	default:
+	}
+	e, found := m.elections[name]
+	if !found {
+		e = &election{name: name, m: m, changed: make(chan struct{})}
+		m.elections[name] = e
+	}
+	return e, nil
+}
+
+// Close resigns from all the elections. The elections cannot be used after this
+func (m *Manager) Close(ctx context.Context) error {
+	m.mtx.Lock()
+	select {
+	case <-m.stopped:
+		m.mtx.Unlock()
+		return nil
+	default:
+	}
+	close(m.stopped)
+	elections := m.list()
+	m.mtx.Unlock()
+
+	defer m.cancel()
+
+	var err error
+	for _, e := range elections {
+		if rErr := e.Resign(ctx); rErr != nil {
+			log.Warnf("failed resigning from %s: %v", e.name, rErr)
+			err = rErr
+		}
+	}
+	return err
+}
+
+func (m *Manager) Status() interface{} {
+	m.mtx.Lock()
+	elections := m.list()
+	m.mtx.Unlock()
+
+	if len(elections) == 0 {
+		return "no elections"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	sts := map[string]Status{}
+	for _, e := range elections {
+		sts[e.name] = e.status(ctx)
+	}
+	return sts
+}
+
+// Health is degraded if the leader records could not be renewed. The leadership
+// is still held, but the other nodes will not see the leader once the lease
+// expires
+func (m *Manager) Health() status.Health {
+	m.mtx.Lock()
+	elections := m.list()
+	m.mtx.Unlock()
+
+	for _, e := range elections {
+		e.mtx.Lock()
+		failed := e.renewErr != nil
+		e.mtx.Unlock()
+		if failed {
+			return status.Degraded
+		}
+	}
+	return status.OK
+}
+
+func (m *Manager) list() []*election {
+	elections := make([]*election, 0, len(m.elections))
+	for _, e := range m.elections {
+		elections = append(elections, e)
+	}
+	return elections
+}
+
+// retryInterval is used between the campaign attempts and to refresh the leader
+// records
+func (m *Manager) retryInterval() time.Duration {
+	return m.lease / 3
+}
+
+type record struct {
+	Name   string
+	Leader string
+	// Since is the time the leader was elected. If a leader lost its lock
+	// without noticing, the record of the newer leader is used
+	Since   int64
+	Expires int64
+}
+
+func (r *record) GetNamespace() string { return ns }
+
+func (r *record) GetID() string { return r.Name }
+
+func (r *record) Marshal() ([]byte, error) { return json.Marshal(r) }
+
+func (r *record) Unmarshal(buf []byte) error { return json.Unmarshal(buf, r) }
+
+type election struct {
+	name string
+	m    *Manager
+
+	mtx      sync.Mutex
+	unlock   func()
+	release  context.CancelFunc
+	cancel   context.CancelFunc
+	done     chan struct{}
+	since    time.Time
+	renewErr error
+	// changed is closed and replaced on every leadership change of this node
+	changed  chan struct{}
+	elected  []func(context.Context)
+	resigned []func()
+}
+
+func (e *election) Name() string { return e.name }
+
+func (e *election) lockKey() string { return ns + "/" + e.name }
+
+func (e *election) Campaign(ctx context.Context) error {
+	for {
+		if e.IsLeader() {
+			return nil
+		}
+		select {
+		case <-e.m.stopped:
+			return ErrClosed
+		default:
+		}
+		unlock, lost, release, err := e.tryLock(ctx)
+		if err == nil {
+			return e.elect(unlock, lost, release)
+		}
+		log.Debugf("campaign for %s failed: %v", e.name, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.m.stopped:
+			return ErrClosed
+		case <-time.After(e.m.retryInterval()):
+		}
+	}
+}
+
+// tryLock acquires the lock using a context derived from the manager. The lock
+// is held on this context till it is released, the context of the caller is only
+// used to stop waiting for the lock. The channel returned is closed once the lock
+// is lost, it is nil if the locker cannot report it
+func (e *election) tryLock(ctx context.Context) (func(), <-chan struct{}, context.CancelFunc, error) {
+	lockCtx, release := context.WithCancel(e.m.ctx)
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			release()
+		case <-stop:
+		}
+	}()
+	var (
+		unlock func()
+		lost   <-chan struct{}
+		err    error
+	)
+	if ll, ok := e.m.lk.(locker.LeaseLocker); ok {
+		unlock, lost, err = ll.TryLockLease(lockCtx, e.lockKey(), e.m.lease)
+	} else {
+		unlock, err = e.m.lk.TryLock(lockCtx, e.lockKey(), e.m.lease)
+	}
+	close(stop)
+	<-stopped
+	if err == nil && lockCtx.Err() != nil {
+		// Acquired while the caller was cancelled, the lock could no longer be
+		// extended on this context
+		unlock()
+		err = lockCtx.Err()
+	}
+	if err != nil {
+		release()
+		return nil, nil, nil, err
+	}
+	return unlock, lost, release, nil
+}
+
+func (e *election) elect(unlock func(), lost <-chan struct{}, release context.CancelFunc) error {
+	e.mtx.Lock()
+	select {
+	case <-e.m.stopped:
+		e.mtx.Unlock()
+		unlock()
+		release()
+		return ErrClosed
+	default:
+	}
+	if e.unlock != nil {
+		// Some other campaign got elected in the meantime
+		e.mtx.Unlock()
+		unlock()
+		release()
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	e.unlock, e.release, e.cancel, e.done = unlock, release, cancel, done
+	e.since = time.Now()
+	cbs := append([]func(context.Context){}, e.elected...)
+	e.notify()
+	e.mtx.Unlock()
+
+	log.Infof("elected leader for %s", e.name)
+	e.renew(ctx)
+	go e.keepAlive(ctx, lost, done)
+	for _, cb := range cbs {
+		go cb(ctx)
+	}
+	return nil
+}
+
+// keepAlive renews the leader record till the leadership is given up. The node
+// is demoted once the locker reports the lock as lost, or a newer leader saves
+// its record in the store
+func (e *election) keepAlive(ctx context.Context, lost <-chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(e.m.retryInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-lost:
+			if ctx.Err() == nil {
+				e.lost(done)
+			}
+			return
+		case <-ticker.C:
+			if !e.renew(ctx) {
+				e.lost(done)
+				return
+			}
+		}
+	}
+}
+
+// renew extends the lease on the leader record. It returns false if a newer
+// leader has saved its record, which means that the lock of this node has
+// expired
+func (e *election) renew(ctx context.Context) bool {
+	e.mtx.Lock()
+	since := e.since
+	e.mtx.Unlock()
+
+	r := &record{Name: e.name}
+	if err := e.m.st.Read(ctx, r); err == nil && r.Leader != e.m.id &&
+		r.Since > since.UnixNano() && time.Now().Before(time.Unix(0, r.Expires)) {
+		return false
+	}
+	err := e.m.st.Update(ctx, &record{
+		Name:    e.name,
+		Leader:  e.m.id,
+		Since:   since.UnixNano(),
+		Expires: time.Now().Add(e.m.lease).UnixNano(),
+	})
+	if ctx.Err() != nil {
+		// Resigned in the meantime
+		return true
+	}
+	if err != nil {
+		log.Warnf("failed renewing lease of %s: %v", e.name, err)
+	}
+	e.mtx.Lock()
+	e.renewErr = err
+	e.mtx.Unlock()
+	return true
+}
+
+// lost demotes this node once the lock is lost. The lock and the record could
+// belong to the new leader, so they are left as is
+func (e *election) lost(done chan struct{}) {
+	e.mtx.Lock()
+	if e.done != done {
+		// Resigned in the meantime
+		e.mtx.Unlock()
+		return
+	}
+	release, cancel := e.release, e.cancel
+	e.unlock, e.release, e.cancel, e.done = nil, nil, nil, nil
+	e.since, e.renewErr = time.Time{}, nil
+	cbs := append([]func(){}, e.resigned...)
+	e.notify()
+	e.mtx.Unlock()
+
+	cancel()
+	release()
+	log.Warnf("lost leadership of %s", e.name)
+	for _, cb := range cbs {
+		cb()
+	}
+}
+
+func (e *election) Resign(ctx context.Context) error {
+	e.mtx.Lock()
+	if e.unlock == nil {
+		e.mtx.Unlock()
+		return nil
+	}
+	unlock, release, cancel, done := e.unlock, e.release, e.cancel, e.done
+	e.unlock, e.release, e.cancel, e.done = nil, nil, nil, nil
+	e.since, e.renewErr = time.Time{}, nil
+	cbs := append([]func(){}, e.resigned...)
+	e.notify()
+	e.mtx.Unlock()
+
+	cancel()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	// The record is removed before the lock is released, so that it does not
+	// remove the record of the next leader
+	err := e.clear(ctx)
+	unlock()
+	release()
+	log.Infof("resigned leader for %s", e.name)
+	for _, cb := range cbs {
+		cb()
+	}
+	return err
+}
+
+func (e *election) clear(ctx context.Context) error {
+	r := &record{Name: e.name}
+	err := e.m.st.Read(ctx, r)
+	if err != nil {
+		if errors.Is(err, store.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if r.Leader != e.m.id {
+		return nil
+	}
+	return e.m.st.Delete(ctx, r)
+}
+
+func (e *election) IsLeader() bool {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	return e.unlock != nil
+}
+
+func (e *election) Leader(ctx context.Context) (Leader, error) {
+	r := &record{Name: e.name}
+	err := e.m.st.Read(ctx, r)
+	if err != nil && !errors.Is(err, store.ErrRecordNotFound) {
+		return Leader{}, err
+	}
+	if e.IsLeader() {
+		if err != nil || r.Leader != e.m.id {
+			return Leader{ID: e.m.id, Expires: time.Now().Add(e.m.lease)}, nil
+		}
+		return Leader{ID: r.Leader, Expires: time.Unix(0, r.Expires)}, nil
+	}
+	if err != nil || r.Leader == e.m.id {
+		// Records left by this node are stale as it is not the leader
+		return Leader{}, nil
+	}
+	expires := time.Unix(0, r.Expires)
+	if time.Now().After(expires) {
+		return Leader{}, nil
+	}
+	return Leader{ID: r.Leader, Expires: expires}, nil
+}
+
+func (e *election) Observe(ctx context.Context) <-chan Leader {
+	ch := make(chan Leader, 1)
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(e.m.retryInterval())
+		defer ticker.Stop()
+
+		var (
+			last Leader
+			sent bool
+		)
+		for {
+			e.mtx.Lock()
+			changed := e.changed
+			e.mtx.Unlock()
+
+			l, err := e.Leader(ctx)
+			if err != nil {
+				log.Debugf("failed reading leader of %s: %v", e.name, err)
+			} else if !sent || l.ID != last.ID {
+				select {
+				case ch <- l:
+				case <-ctx.Done():
+					return
+				}
+				last, sent = l, true
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-e.m.stopped:
+				return
+			case <-changed:
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch
+}
+
+func (e *election) OnElected(cb func(context.Context)) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	e.elected = append(e.elected, cb)
+}
+
+func (e *election) OnResigned(cb func()) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	e.resigned = append(e.resigned, cb)
+}
+
+// notify wakes up the observers. It should be called with the lock held
+func (e *election) notify() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+func (e *election) status(ctx context.Context) Status {
+	st := Status{}
+	l, err := e.Leader(ctx)
+	if err != nil {
+		st.Error = err.Error()
+	}
+	st.Leader = l.ID
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	st.IsLeader = e.unlock != nil
+	if st.IsLeader {
+		since := e.since
+		st.Since = &since
+	}
+	if e.renewErr != nil {
+		st.Error = e.renewErr.Error()
+	}
+	return st
+}
@@ -0,0 +1,331 @@
+package election_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/plexsysio/dLocker"
+	inmemlock "github.com/plexsysio/dLocker/handlers/memlock"
+	"github.com/plexsysio/gkvstore/inmem"
+	syncstore "github.com/plexsysio/gkvstore/sync"
+	"github.com/plexsysio/go-msuite/modules/election"
+)
+
+func TestElection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The managers share the locker and the store like nodes of a cluster
+	lk := inmemlock.NewLocker()
+	st := syncstore.New(inmem.New())
+	lease := 300 * time.Millisecond
+
+	m1 := election.NewManager(lk, st, "node1", lease)
+	m2 := election.NewManager(lk, st, "node2", lease)
+
+	e1, err := m1.Election("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := m2.Election("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m1.Election(""); err != election.ErrInvalidName {
+		t.Fatal("expected invalid name", err)
+	}
+
+	elected := make(chan struct{})
+	lost := make(chan struct{})
+	e1.OnElected(func(leaderCtx context.Context) {
+		close(elected)
+		<-leaderCtx.Done()
+		close(lost)
+	})
+	resigned := make(chan struct{})
+	e1.OnResigned(func() { close(resigned) })
+
+	obsCtx, obsCancel := context.WithCancel(ctx)
+	defer obsCancel()
+	leaders := e2.Observe(obsCtx)
+	if l := <-leaders; l.ID != "" {
+		t.Fatal("expected no leader", l)
+	}
+
+	if err := e1.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-elected
+	if !e1.IsLeader() || e2.IsLeader() {
+		t.Fatal("incorrect leadership")
+	}
+	if l := <-leaders; l.ID != "node1" {
+		t.Fatal("expected node1 to be observed as leader", l)
+	}
+
+	// The lease is renewed by the leader
+	time.Sleep(2 * lease)
+	l, err := e2.Leader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.ID != "node1" || !l.Expires.After(time.Now()) {
+		t.Fatal("expected lease of node1 to be renewed", l)
+	}
+
+	cctx, ccancel := context.WithTimeout(ctx, lease)
+	defer ccancel()
+	if err := e2.Campaign(cctx); err == nil {
+		t.Fatal("expected campaign to fail while node1 is leader")
+	}
+
+	campaigned := make(chan error, 1)
+	go func() {
+		campaigned <- e2.Campaign(ctx)
+	}()
+	if err := e1.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-resigned
+	<-lost
+	if err := <-campaigned; err != nil {
+		t.Fatal(err)
+	}
+	if e1.IsLeader() || !e2.IsLeader() {
+		t.Fatal("incorrect leadership after resign")
+	}
+	for l := range leaders {
+		if l.ID == "node2" {
+			break
+		}
+	}
+
+	sts, ok := m2.Status().(map[string]election.Status)
+	if !ok || !sts["test"].IsLeader || sts["test"].Leader != "node2" {
+		t.Fatal("incorrect status", m2.Status())
+	}
+
+	if err := m2.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if e2.IsLeader() {
+		t.Fatal("expected leadership to be given up on close")
+	}
+	if _, err := m2.Election("test"); err != election.ErrClosed {
+		t.Fatal("expected closed", err)
+	}
+	l, err = e1.Leader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.ID != "" {
+		t.Fatal("expected no leader", l)
+	}
+	if err := m1.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expiringLocker can expire the locks held, like the lockers which extend the
+// locks in the background using the context of TryLock
+type expiringLocker struct {
+	dLocker.DLocker
+
+	mtx    sync.Mutex
+	ctxs   map[string]context.Context
+	expire map[string]func()
+}
+
+func (l *expiringLocker) TryLock(ctx context.Context, key string, d time.Duration) (func(), error) {
+	unlock, err := l.DLocker.TryLock(ctx, key, d)
+	if err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	release := func() { once.Do(unlock) }
+	l.mtx.Lock()
+	l.ctxs[key] = ctx
+	l.expire[key] = release
+	l.mtx.Unlock()
+	return release, nil
+}
+
+func (l *expiringLocker) lockCtx(key string) context.Context {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.ctxs[key]
+}
+
+func (l *expiringLocker) expireLock(key string) {
+	l.mtx.Lock()
+	release := l.expire[key]
+	l.mtx.Unlock()
+
+	release()
+}
+
+func TestElectionLockLost(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lk := &expiringLocker{
+		DLocker: inmemlock.NewLocker(),
+		ctxs:    make(map[string]context.Context),
+		expire:  make(map[string]func()),
+	}
+	st := syncstore.New(inmem.New())
+	lease := 300 * time.Millisecond
+
+	m1 := election.NewManager(lk, st, "node1", lease)
+	m2 := election.NewManager(lk, st, "node2", lease)
+
+	e1, err := m1.Election("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := m2.Election("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resigned := make(chan struct{})
+	e1.OnResigned(func() { close(resigned) })
+
+	cctx, ccancel := context.WithTimeout(ctx, lease)
+	if err := e1.Campaign(cctx); err != nil {
+		t.Fatal(err)
+	}
+	ccancel()
+
+	// The lock should be held after the campaign context is done
+	if err := lk.lockCtx("election/test").Err(); err != nil {
+		t.Fatal("expected lock context to be active after campaign", err)
+	}
+	time.Sleep(lease)
+	if !e1.IsLeader() {
+		t.Fatal("expected node1 to be leader")
+	}
+
+	lk.expireLock("election/test")
+	if err := e2.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-resigned:
+	case <-time.After(2 * lease):
+		t.Fatal("expected node1 to be demoted once the lock is lost")
+	}
+	if e1.IsLeader() || !e2.IsLeader() {
+		t.Fatal("incorrect leadership after lock is lost")
+	}
+	time.Sleep(lease)
+	l, err := e1.Leader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.ID != "node2" {
+		t.Fatal("expected node2 to be the leader", l)
+	}
+
+	if err := m1.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m2.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := lk.lockCtx("election/test").Err(); err == nil {
+		t.Fatal("expected lock context to be cancelled on close")
+	}
+}
+
+// leaseLocker reports the locks expired, like the lockers which fail extending
+// the leases of the locks
+type leaseLocker struct {
+	*expiringLocker
+
+	mtx  sync.Mutex
+	lost map[string]chan struct{}
+}
+
+func (l *leaseLocker) TryLockLease(ctx context.Context, key string, d time.Duration) (func(), <-chan struct{}, error) {
+	unlock, err := l.TryLock(ctx, key, d)
+	if err != nil {
+		return nil, nil, err
+	}
+	lost := make(chan struct{})
+	l.mtx.Lock()
+	l.lost[key] = lost
+	l.mtx.Unlock()
+	return unlock, lost, nil
+}
+
+func (l *leaseLocker) loseLock(key string) {
+	l.mtx.Lock()
+	lost := l.lost[key]
+	l.mtx.Unlock()
+
+	l.expireLock(key)
+	close(lost)
+}
+
+func TestElectionLeaseLost(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lk := &leaseLocker{
+		expiringLocker: &expiringLocker{
+			DLocker: inmemlock.NewLocker(),
+			ctxs:    make(map[string]context.Context),
+			expire:  make(map[string]func()),
+		},
+		lost: make(map[string]chan struct{}),
+	}
+	lease := 300 * time.Millisecond
+
+	// The records are not shared, so the nodes only know about the locks
+	m1 := election.NewManager(lk, syncstore.New(inmem.New()), "node1", lease)
+	m2 := election.NewManager(lk, syncstore.New(inmem.New()), "node2", lease)
+
+	e1, err := m1.Election("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := m2.Election("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resigned := make(chan struct{})
+	e1.OnResigned(func() { close(resigned) })
+
+	if err := e1.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	lk.loseLock("election/test")
+	select {
+	case <-resigned:
+	case <-time.After(lease):
+		t.Fatal("expected node1 to be demoted once the lease is lost")
+	}
+	if e1.IsLeader() {
+		t.Fatal("expected node1 not to be leader")
+	}
+	if err := e2.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !e2.IsLeader() {
+		t.Fatal("expected node2 to be leader")
+	}
+
+	if err := m1.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m2.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
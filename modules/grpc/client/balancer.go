@@ -0,0 +1,228 @@
+package grpcclient
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/balancer/roundrobin"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+const (
+	// P2PScheme is the resolver scheme used for the balanced libp2p connections.
+	// The target is p2p:///<service>
+	P2PScheme = "p2p"
+	// Scheme is the resolver scheme of the msuite service names. The target is
+	// msuite:///<service>, or msuite://<peer ID>/<service> to use a specific
+	// node if there are many running
+	Scheme = "msuite"
+	// leastRequest is the name of the least request balancer
+	leastRequest = "msuite_least_request"
+	// resolveTimeout bounds the time spent discovering the peers
+	resolveTimeout = 30 * time.Second
+)
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(leastRequest, &lrPickerBuilder{}, base.Config{}))
+}
+
+// lookupFunc returns the addresses of the service
+type lookupFunc func(context.Context, string) ([]resolver.Address, error)
+
+// resolverBuilder builds the resolvers which look up the addresses of the
+// service and refresh them in the background. The service config with the
+// balancer is set by the resolver
+type resolverBuilder struct {
+	scheme   string
+	lookup   lookupFunc
+	refresh  time.Duration
+	lbConfig string
+}
+
+func (b *resolverBuilder) Scheme() string {
+	return b.scheme
+}
+
+func (b *resolverBuilder) Build(
+	target resolver.Target,
+	cc resolver.ClientConn,
+	_ resolver.BuildOptions,
+) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &refreshResolver{
+		b:       b,
+		svc:     strings.TrimPrefix(target.URL.Path, "/"),
+		cc:      cc,
+		cancel:  cancel,
+		now:     make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+		tracker: &loadTracker{inflight: make(map[balancer.SubConn]*int32)},
+	}
+	if b.lbConfig != "" {
+		r.sc = cc.ParseServiceConfig(b.lbConfig)
+	}
+	go r.run(ctx)
+	return r, nil
+}
+
+type refreshResolver struct {
+	b       *resolverBuilder
+	svc     string
+	cc      resolver.ClientConn
+	sc      *serviceconfig.ParseResult
+	cancel  context.CancelFunc
+	now     chan struct{}
+	stopped chan struct{}
+	tracker *loadTracker
+}
+
+// ResolveNow is called by gRPC when the connections fail. The addresses are
+// looked up again without waiting for the refresh
+func (r *refreshResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.now <- struct{}{}:
+	default:
+	}
+}
+
+func (r *refreshResolver) Close() {
+	r.cancel()
+	<-r.stopped
+}
+
+func (r *refreshResolver) run(ctx context.Context) {
+	defer close(r.stopped)
+
+	for {
+		r.resolve(ctx)
+		t := time.NewTimer(r.b.refresh)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-r.now:
+			t.Stop()
+		case <-t.C:
+		}
+	}
+}
+
+func (r *refreshResolver) resolve(ctx context.Context) {
+	cCtx, cCancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cCancel()
+
+	addrs, err := r.b.lookup(cCtx, r.svc)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		r.cc.ReportError(err)
+		return
+	}
+	for i := range addrs {
+		addrs[i].BalancerAttributes = attributes.New(loadTrackerKey{}, r.tracker)
+	}
+	log.Debugf("resolved %d addresses for service %s", len(addrs), r.svc)
+	state := resolver.State{Addresses: addrs}
+	if r.sc != nil && r.sc.Err == nil {
+		state.ServiceConfig = r.sc
+	}
+	if err := r.cc.UpdateState(state); err != nil {
+		log.Warnf("failed updating addresses of %s: %v", r.svc, err)
+	}
+}
+
+type loadTrackerKey struct{}
+
+// loadTracker counts the calls in progress on the connections of a client. It
+// is shared by the pickers built for the client, so that the counts are not
+// lost when the peers change
+type loadTracker struct {
+	mtx      sync.Mutex
+	inflight map[balancer.SubConn]*int32
+}
+
+type lrPickerBuilder struct{}
+
+func (*lrPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	var tracker *loadTracker
+	for _, sci := range info.ReadySCs {
+		tracker, _ = sci.Address.BalancerAttributes.Value(loadTrackerKey{}).(*loadTracker)
+		break
+	}
+	if tracker == nil {
+		tracker = &loadTracker{inflight: make(map[balancer.SubConn]*int32)}
+	}
+
+	tracker.mtx.Lock()
+	defer tracker.mtx.Unlock()
+
+	p := &lrPicker{}
+	inflight := make(map[balancer.SubConn]*int32, len(info.ReadySCs))
+	for sc := range info.ReadySCs {
+		count, found := tracker.inflight[sc]
+		if !found {
+			count = new(int32)
+		}
+		inflight[sc] = count
+		p.subConns = append(p.subConns, sc)
+		p.inflight = append(p.inflight, count)
+	}
+	// Only the ready connections are tracked
+	tracker.inflight = inflight
+	p.next = uint32(rand.Intn(len(p.subConns)))
+	return p
+}
+
+type lrPicker struct {
+	subConns []balancer.SubConn
+	inflight []*int32
+	next     uint32
+}
+
+// Pick returns the connection with the least calls in progress. The search
+// starts at a different connection every time, so that the ties are spread
+func (p *lrPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	start := atomic.AddUint32(&p.next, 1)
+	best := -1
+	var least int32
+	for i := range p.subConns {
+		idx := int((start + uint32(i)) % uint32(len(p.subConns)))
+		if count := atomic.LoadInt32(p.inflight[idx]); best == -1 || count < least {
+			best, least = idx, count
+		}
+	}
+	count := p.inflight[best]
+	atomic.AddInt32(count, 1)
+	return balancer.PickResult{
+		SubConn: p.subConns[best],
+		Done: func(balancer.DoneInfo) {
+			atomic.AddInt32(count, -1)
+		},
+	}, nil
+}
+
+// balancerConfig is the service config using the balancer configured. It is
+// empty if the balancing is not enabled
+func balancerConfig(lb string) string {
+	switch lb {
+	case settings.RoundRobin:
+		return fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, roundrobin.Name)
+	case settings.LeastRequest:
+		return fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, leastRequest)
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+package grpcclient_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p-blankhost"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
+	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	grpcclient "github.com/plexsysio/go-msuite/modules/grpc/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func balancedClient(t *testing.T, lb string, d *peersDiscovery) healthpb.HealthClient {
+	t.Helper()
+
+	dialer := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	local := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	t.Cleanup(func() {
+		dialer.Close()
+		local.Close()
+	})
+
+	cfg := jsonConf.DefaultConfig()
+	cfg.Set("LoadBalancer", lb)
+	cfg.Set("ResolverRefresh", 1)
+	cs, err := grpcclient.NewP2PClientService(cfg, d, dialer, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := cs.Get(context.TODO(), "svc", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if conn.Target() != "p2p:///svc" {
+		t.Fatal("incorrect target", conn.Target())
+	}
+	return healthpb.NewHealthClient(conn)
+}
+
+func peerInfo(h host.Host) peer.AddrInfo {
+	return h.Peerstore().PeerInfo(h.ID())
+}
+
+// waitCalls calls the service till all the servers have received calls and
+// resets the counts
+func waitCalls(t *testing.T, hc healthpb.HealthClient, srvs ...*testServer) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		if _, err := hc.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+			t.Fatal(err)
+		}
+		done := true
+		for _, s := range srvs {
+			if atomic.LoadInt32(&s.calls) == 0 {
+				done = false
+			}
+		}
+		if done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, s := range srvs {
+		atomic.StoreInt32(&s.calls, 0)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	s1, s2, s3 := &testServer{}, &testServer{}, &testServer{}
+	d := &peersDiscovery{peers: []peer.AddrInfo{
+		peerInfo(servePeer(t, s1)),
+		peerInfo(servePeer(t, s2)),
+	}}
+	hc := balancedClient(t, settings.RoundRobin, d)
+
+	waitCalls(t, hc, s1, s2)
+	for i := 0; i < 4; i++ {
+		if _, err := hc.Check(context.TODO(), &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if atomic.LoadInt32(&s1.calls) != 2 || atomic.LoadInt32(&s2.calls) != 2 {
+		t.Fatal("expected calls to be balanced", s1.calls, s2.calls)
+	}
+
+	// New peers are found on refresh
+	d.add(peerInfo(servePeer(t, s3)))
+	waitCalls(t, hc, s1, s2, s3)
+}
+
+func TestLeastRequest(t *testing.T) {
+	var held int32
+	hold := make(chan struct{})
+	s1 := &testServer{hold: hold, held: &held}
+	s2 := &testServer{hold: hold, held: &held}
+	d := &peersDiscovery{peers: []peer.AddrInfo{
+		peerInfo(servePeer(t, s1)),
+		peerInfo(servePeer(t, s2)),
+	}}
+	// The first call is held only after all the peers are ready
+	atomic.StoreInt32(&held, 1)
+	hc := balancedClient(t, settings.LeastRequest, d)
+	waitCalls(t, hc, s1, s2)
+	atomic.StoreInt32(&held, 0)
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := hc.Check(context.TODO(), &healthpb.HealthCheckRequest{})
+		slow <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// The calls avoid the peer with the call in progress
+	for i := 0; i < 3; i++ {
+		if _, err := hc.Check(context.TODO(), &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(hold)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+	c1, c2 := atomic.LoadInt32(&s1.calls), atomic.LoadInt32(&s2.calls)
+	if !(c1 == 1 && c2 == 3) && !(c1 == 3 && c2 == 1) {
+		t.Fatal("expected calls to avoid the busy peer", c1, c2)
+	}
+}
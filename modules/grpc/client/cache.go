@@ -0,0 +1,429 @@
+package grpcclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// ErrClientClosed is returned once the client service is closed
+var ErrClientClosed = errors.New("client service closed")
+
+// Conn is the connection returned by the client service. The callers should
+// close it once done. Closing a cached connection only releases it, the cache
+// closes it once it is evicted and no caller holds it anymore
+type Conn struct {
+	*grpc.ClientConn
+
+	once    sync.Once
+	release func() error
+}
+
+// Close releases the connection. It is safe to call it more than once
+func (c *Conn) Close() error {
+	var err error
+	c.once.Do(func() {
+		err = c.release()
+	})
+	return err
+}
+
+// NewConn returns the connection on which Close calls release instead. The
+// connection is closed on Close if release is nil
+func NewConn(conn *grpc.ClientConn, release func() error) *Conn {
+	if release == nil {
+		release = conn.Close
+	}
+	return &Conn{ClientConn: conn, release: release}
+}
+
+// cachedConn is the connection of a service to a peer. The callers holding the
+// connection and the calls in progress are tracked, so that the connections in
+// use are not closed
+type cachedConn struct {
+	conn     *grpc.ClientConn
+	lastUsed time.Time
+	refs     int
+	active   int
+	evicted  bool
+}
+
+// inUse reports if the connection is held by some caller or has calls in
+// progress. It should be called with the lock held
+func (cc *cachedConn) inUse() bool {
+	return cc.refs > 0 || cc.active > 0
+}
+
+// connCache keeps the connections by service and peer. Only the connections
+// dialed without options by the caller are cached, as the options cannot be
+// compared. The connections are checked every interval and are evicted if they
+// are idle, have failed or the health service of the peer reports the service
+// is not serving. The evicted connections are no longer returned and are closed
+// once all the callers holding them release them
+type connCache struct {
+	idle     time.Duration
+	interval time.Duration
+
+	mtx     sync.Mutex
+	conns   map[string]map[string]*cachedConn
+	evicted map[*cachedConn]struct{}
+	started bool
+	closed  bool
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// newConnCache returns the cache if it is enabled in the config
+func newConnCache(c config.Config) *connCache {
+	if !c.IsSet("UseClientCache") {
+		return nil
+	}
+	var cfg settings.GRPC
+	_ = c.Get("ClientIdleTimeout", &cfg.ClientIdle)
+	_ = c.Get("ClientHealthInterval", &cfg.ClientHealth)
+	return &connCache{
+		idle:     cfg.ClientIdleDuration(),
+		interval: cfg.ClientHealthDuration(),
+		conns:    make(map[string]map[string]*cachedConn),
+		evicted:  make(map[*cachedConn]struct{}),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// getOrDial returns the connection cached for the service or dials a new one.
+// Without the cache or with the options of the caller, the connection is always
+// dialed and is closed along with the Conn returned
+func (c *connCache) getOrDial(
+	svc string,
+	opts []grpc.DialOption,
+	dial func(...grpc.DialOption) (*grpc.ClientConn, error),
+) (*Conn, error) {
+	if c == nil || len(opts) > 0 {
+		conn, err := dial(opts...)
+		if err != nil {
+			return nil, err
+		}
+		return NewConn(conn, nil), nil
+	}
+	cc, err := c.get(svc)
+	if err != nil {
+		return nil, err
+	}
+	if cc == nil {
+		cc = &cachedConn{}
+		conn, err := dial(
+			grpc.WithChainUnaryInterceptor(c.unaryTracker(cc)),
+			grpc.WithChainStreamInterceptor(c.streamTracker(cc)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		cc.conn = conn
+		if cc, err = c.put(svc, cc); err != nil {
+			return nil, err
+		}
+	}
+	return NewConn(cc.conn, func() error {
+		c.release(cc)
+		return nil
+	}), nil
+}
+
+type healthCheckKey struct{}
+
+// begin records the start of a call on the connection. The health checks of the
+// cache are not counted, so that they do not keep the connection in use
+func (c *connCache) begin(ctx context.Context, cc *cachedConn) func() {
+	if ctx.Value(healthCheckKey{}) != nil {
+		return func() {}
+	}
+	c.mtx.Lock()
+	cc.active++
+	cc.lastUsed = time.Now()
+	c.mtx.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mtx.Lock()
+			defer c.mtx.Unlock()
+
+			cc.active--
+			cc.lastUsed = time.Now()
+			c.closeEvicted(cc)
+		})
+	}
+}
+
+func (c *connCache) unaryTracker(cc *cachedConn) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		conn *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		defer c.begin(ctx, cc)()
+		return invoker(ctx, method, req, reply, conn, opts...)
+	}
+}
+
+// streamTracker tracks the streams until their context is done, which happens
+// once the stream finishes
+func (c *connCache) streamTracker(cc *cachedConn) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		conn *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		done := c.begin(ctx, cc)
+		s, err := streamer(ctx, desc, conn, method, opts...)
+		if err != nil {
+			done()
+			return nil, err
+		}
+		go func() {
+			<-s.Context().Done()
+			done()
+		}()
+		return s, nil
+	}
+}
+
+// get returns a connection of the service which can be used and holds it for the
+// caller. The connections which are ready are preferred, and among them the one
+// with the least calls in progress
+func (c *connCache) get(svc string) (*cachedConn, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	var (
+		best      *cachedConn
+		bestReady bool
+	)
+	for target, cc := range c.conns[svc] {
+		state := cc.conn.GetState()
+		switch state {
+		case connectivity.Shutdown:
+			// Closed using the underlying connection
+			c.delete(svc, target)
+			continue
+		case connectivity.TransientFailure:
+			continue
+		}
+		ready := state == connectivity.Ready
+		if best == nil || (ready && !bestReady) || (ready == bestReady && cc.active < best.active) {
+			best, bestReady = cc, ready
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	best.refs++
+	best.lastUsed = time.Now()
+	return best, nil
+}
+
+// put caches the connection dialed and holds it for the caller. If another
+// usable connection to the same peer was cached in the meantime, it is used
+// instead
+func (c *connCache) put(svc string, nc *cachedConn) (*cachedConn, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.closed {
+		nc.conn.Close()
+		return nil, ErrClientClosed
+	}
+	target := nc.conn.Target()
+	if cc, found := c.conns[svc][target]; found {
+		switch cc.conn.GetState() {
+		case connectivity.Shutdown:
+			c.delete(svc, target)
+		case connectivity.TransientFailure:
+			c.evict(svc, target, "connection failed")
+		default:
+			nc.conn.Close()
+			cc.refs++
+			cc.lastUsed = time.Now()
+			return cc, nil
+		}
+	}
+	nc.refs++
+	nc.lastUsed = time.Now()
+	if c.conns[svc] == nil {
+		c.conns[svc] = make(map[string]*cachedConn)
+	}
+	c.conns[svc][target] = nc
+	if !c.started {
+		c.started = true
+		go c.monitor()
+	}
+	return nc, nil
+}
+
+// release is called once the caller closes the connection
+func (c *connCache) release(cc *cachedConn) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	cc.refs--
+	cc.lastUsed = time.Now()
+	c.closeEvicted(cc)
+}
+
+// closeEvicted closes the evicted connection once it is no longer in use. It
+// should be called with the lock held
+func (c *connCache) closeEvicted(cc *cachedConn) {
+	if !cc.evicted || cc.inUse() {
+		return
+	}
+	delete(c.evicted, cc)
+	cc.conn.Close()
+}
+
+// delete removes the connection of the service to the peer. It should be called
+// with the lock held
+func (c *connCache) delete(svc, target string) {
+	delete(c.conns[svc], target)
+	if len(c.conns[svc]) == 0 {
+		delete(c.conns, svc)
+	}
+}
+
+// evict removes the connection of the service to the peer, so that it is not
+// returned to the later callers. It should be called with the lock held. The
+// connection is closed once the callers holding it release it
+func (c *connCache) evict(svc, target, reason string) {
+	cc, found := c.conns[svc][target]
+	if !found {
+		return
+	}
+	log.Infof("evicting connection of %s to %s: %s", svc, target, reason)
+	c.delete(svc, target)
+	cc.evicted = true
+	c.evicted[cc] = struct{}{}
+	c.closeEvicted(cc)
+}
+
+func (c *connCache) remove(svc string) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for target := range c.conns[svc] {
+		c.evict(svc, target, "address updated")
+	}
+}
+
+func (c *connCache) monitor() {
+	defer close(c.stopped)
+
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+			c.check()
+		}
+	}
+}
+
+func (c *connCache) check() {
+	type ready struct {
+		svc  string
+		conn *grpc.ClientConn
+	}
+	var conns []ready
+
+	c.mtx.Lock()
+	for svc, peers := range c.conns {
+		for target, cc := range peers {
+			switch {
+			case !cc.inUse() && time.Since(cc.lastUsed) > c.idle:
+				c.evict(svc, target, "idle")
+			case cc.conn.GetState() == connectivity.Shutdown:
+				c.delete(svc, target)
+			case cc.conn.GetState() == connectivity.TransientFailure:
+				c.evict(svc, target, "connection failed")
+			case cc.conn.GetState() == connectivity.Ready:
+				conns = append(conns, ready{svc: svc, conn: cc.conn})
+			}
+		}
+	}
+	c.mtx.Unlock()
+
+	for _, r := range conns {
+		if !serving(r.conn, r.svc, c.interval) {
+			c.mtx.Lock()
+			if cc, found := c.conns[r.svc][r.conn.Target()]; found && cc.conn == r.conn {
+				c.evict(r.svc, r.conn.Target(), "service not serving")
+			}
+			c.mtx.Unlock()
+		}
+	}
+}
+
+// serving checks the service using the health service of the peer. Only the
+// NOT_SERVING response is considered a failure, as the health service could be
+// protected or missing on the peer
+func serving(conn *grpc.ClientConn, svc string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), healthCheckKey{}, true), timeout)
+	defer cancel()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: svc})
+	if err != nil {
+		return true
+	}
+	return resp.Status != healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+// close closes all the connections of the cache, including the ones still held
+// by the callers, as the client service cannot be used anymore
+func (c *connCache) close() {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	if c.closed {
+		c.mtx.Unlock()
+		return
+	}
+	c.closed = true
+	started := c.started
+	close(c.stop)
+	for svc, peers := range c.conns {
+		for _, cc := range peers {
+			cc.conn.Close()
+		}
+		delete(c.conns, svc)
+	}
+	for cc := range c.evicted {
+		cc.conn.Close()
+		delete(c.evicted, cc)
+	}
+	c.mtx.Unlock()
+
+	if started {
+		<-c.stopped
+	}
+}
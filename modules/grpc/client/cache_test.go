@@ -0,0 +1,305 @@
+package grpcclient_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p-blankhost"
+	"github.com/libp2p/go-libp2p-core/peer"
+	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
+	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	grpcclient "github.com/plexsysio/go-msuite/modules/grpc/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestConnCache(t *testing.T) {
+	l, err := net.Listen("unix", "/tmp/cache.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		l.Close()
+		_ = os.RemoveAll("/tmp/cache.sock")
+	})
+	hs := health.NewServer()
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	t.Cleanup(srv.Stop)
+
+	cfg := jsonConf.DefaultConfig()
+	cfg.Set("UseClientCache", true)
+	cfg.Set("ClientHealthInterval", 1)
+	cfg.Set("StaticAddresses", map[string]string{
+		"svc1": "/tmp/cache.sock",
+		"svc2": "/tmp/cache.sock",
+	})
+	cs := grpcclient.NewStaticClientService(
+		cfg,
+		grpcclient.WithDialOptions(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+
+	get := func(svc string) *grpcclient.Conn {
+		t.Helper()
+		conn, err := cs.Get(context.TODO(), svc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	conn := get("svc1")
+	reused := get("svc1")
+	if reused.ClientConn != conn.ClientConn {
+		t.Fatal("expected connection to be reused")
+	}
+	reused.Close()
+	other := get("svc2")
+	if other.ClientConn == conn.ClientConn {
+		t.Fatal("expected connection by service")
+	}
+	other.Close()
+
+	check := func(conn *grpcclient.Conn) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+		defer cancel()
+		_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("dial options", func(t *testing.T) {
+		oconn, err := cs.Get(context.TODO(), "svc1", grpc.WithUserAgent("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if oconn.ClientConn == conn.ClientConn {
+			t.Fatal("expected connection with options not to be cached")
+		}
+		check(oconn)
+		oconn.Close()
+		if oconn.GetState() != connectivity.Shutdown {
+			t.Fatal("expected connection with options to be closed")
+		}
+		cached := get("svc1")
+		defer cached.Close()
+		if cached.ClientConn != conn.ClientConn {
+			t.Fatal("expected cached connection without options")
+		}
+		// Closing the connection of the caller does not affect the cached one
+		check(conn)
+	})
+
+	t.Run("closed by user", func(t *testing.T) {
+		other := get("svc1")
+		conn.Close()
+		conn.Close()
+		// Closing only releases the connection, the other callers keep using it
+		check(other)
+		reused := get("svc1")
+		defer reused.Close()
+		if reused.ClientConn != other.ClientConn {
+			t.Fatal("expected released connection to be reused")
+		}
+		conn = other
+	})
+
+	t.Run("not serving", func(t *testing.T) {
+		check(conn)
+		hs.SetServingStatus("svc1", healthpb.HealthCheckResponse_NOT_SERVING)
+		defer hs.SetServingStatus("svc1", healthpb.HealthCheckResponse_SERVING)
+		time.Sleep(2500 * time.Millisecond)
+
+		nconn := get("svc1")
+		defer nconn.Close()
+		if nconn.ClientConn == conn.ClientConn {
+			t.Fatal("expected connection to be evicted")
+		}
+		// Evicted connections are closed once released by the callers
+		check(conn)
+		conn.Close()
+		if conn.GetState() != connectivity.Shutdown {
+			t.Fatal("expected evicted connection to be closed", conn.GetState())
+		}
+	})
+
+	t.Run("address updated", func(t *testing.T) {
+		conn := get("svc2")
+		cs.(grpcclient.StaticClientSvc).SetAddresses(map[string]string{
+			"svc2": "/tmp/cache.sock",
+		})
+		reused := get("svc2")
+		if reused.ClientConn != conn.ClientConn {
+			t.Fatal("expected connection to be reused with same address")
+		}
+		reused.Close()
+		cs.(grpcclient.StaticClientSvc).SetAddresses(map[string]string{})
+		if _, err := cs.Get(context.TODO(), "svc2"); err == nil {
+			t.Fatal("expected error without address")
+		}
+		check(conn)
+		conn.Close()
+		if conn.GetState() != connectivity.Shutdown {
+			t.Fatal("expected evicted connection to be closed once released")
+		}
+	})
+
+	t.Run("calls in progress", func(t *testing.T) {
+		icfg := jsonConf.DefaultConfig()
+		icfg.Set("UseClientCache", true)
+		icfg.Set("ClientHealthInterval", 1)
+		icfg.Set("ClientIdleTimeout", 1)
+		icfg.Set("StaticAddresses", map[string]string{"svc3": "/tmp/cache.sock"})
+		ics := grpcclient.NewStaticClientService(
+			icfg,
+			grpcclient.WithDialOptions(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		)
+		defer ics.Close()
+
+		iget := func() *grpcclient.Conn {
+			t.Helper()
+			conn, err := ics.Get(context.TODO(), "svc3")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return conn
+		}
+
+		conn := iget()
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{Service: "svc3"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		time.Sleep(2500 * time.Millisecond)
+
+		c := iget()
+		c.Close()
+		if c.ClientConn != conn.ClientConn {
+			t.Fatal("expected connection with stream open not to be evicted")
+		}
+		cancel()
+		time.Sleep(2500 * time.Millisecond)
+
+		held := iget()
+		defer held.Close()
+		if held.ClientConn == conn.ClientConn {
+			t.Fatal("expected idle connection to be evicted")
+		}
+		if conn.GetState() != connectivity.Shutdown {
+			t.Fatal("expected idle connection to be closed")
+		}
+		time.Sleep(2500 * time.Millisecond)
+
+		c = iget()
+		c.Close()
+		if c.ClientConn != held.ClientConn {
+			t.Fatal("expected connection held by caller not to be evicted")
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		cs.(grpcclient.StaticClientSvc).SetAddresses(map[string]string{
+			"svc1": "/tmp/cache.sock",
+		})
+		conn := get("svc1")
+		if err := cs.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if conn.GetState() != connectivity.Shutdown {
+			t.Fatal("expected connection to be closed")
+		}
+		_, err := cs.Get(context.TODO(), "svc1")
+		if !errors.Is(err, grpcclient.ErrClientClosed) {
+			t.Fatal("expected closed error", err)
+		}
+	})
+}
+
+func TestConnCacheHealthyPeer(t *testing.T) {
+	first, second := &testServer{}, &testServer{}
+	h1 := servePeer(t, first)
+	h2 := servePeer(t, second)
+
+	dialer := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	local := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	t.Cleanup(func() {
+		dialer.Close()
+		local.Close()
+	})
+
+	cfg := jsonConf.DefaultConfig()
+	cfg.Set("UseClientCache", true)
+	cs, err := grpcclient.NewP2PClientService(
+		cfg,
+		&peersDiscovery{peers: []peer.AddrInfo{
+			h1.Peerstore().PeerInfo(h1.ID()),
+			h2.Peerstore().PeerInfo(h2.ID()),
+		}},
+		dialer,
+		local,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+
+	call := func() *grpcclient.Conn {
+		t.Helper()
+		conn, err := cs.Get(context.TODO(), "svc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		_, err = healthpb.NewHealthClient(conn).Check(context.TODO(), &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	conn := call()
+	if atomic.LoadInt32(&first.calls) != 1 {
+		t.Fatal("expected first peer to be dialed", first.calls)
+	}
+
+	// Once the connection to the first peer fails, the second peer is dialed and
+	// used instead
+	h1.Close()
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	for state := conn.GetState(); state != connectivity.TransientFailure; state = conn.GetState() {
+		conn.Connect()
+		if !conn.WaitForStateChange(ctx, state) {
+			t.Fatal("expected connection to first peer to fail", state)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if call().ClientConn == conn.ClientConn {
+			t.Fatal("expected failed connection not to be used")
+		}
+	}
+	if atomic.LoadInt32(&first.calls) != 1 || atomic.LoadInt32(&second.calls) != 2 {
+		t.Fatal("expected second peer to be used", first.calls, second.calls)
+	}
+}
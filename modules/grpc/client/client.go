@@ -2,10 +2,13 @@ package grpcclient
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	logger "github.com/ipfs/go-log/v2"
@@ -14,9 +17,13 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/diag/status"
 	"github.com/plexsysio/go-msuite/modules/grpc/p2pgrpc"
 	"github.com/plexsysio/taskmanager"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/resolver"
 )
 
 var log = logger.Logger("grpc/client")
@@ -25,15 +32,25 @@ const discoveryTTL = 15 * time.Minute
 
 var ErrNoPeerForSvc = errors.New("failed to find any usable peer for service")
 
+// ClientSvc returns the connections to the services. The callers close the
+// connections once done. If the connection cache is enabled, the connections of
+// the calls without dial options are shared by the callers of the service, and
+// closing them only releases them. The cache closes them once they are evicted
+// and released by all the callers, or along with the client service
 type ClientSvc interface {
-	Get(context.Context, string, ...grpc.DialOption) (*grpc.ClientConn, error)
+	Get(context.Context, string, ...grpc.DialOption) (*Conn, error)
+	Close() error
 }
 
+// NewP2PClientService returns the client service dialing the peers using libp2p.
+// The dial options are used for all the connections, before the ones of the
+// callers
 func NewP2PClientService(
 	cfg config.Config,
 	d discovery.Discovery,
 	localDialer host.Host,
 	mainHost host.Host,
+	opts ...grpc.DialOption,
 ) (ClientSvc, error) {
 
 	var services []string
@@ -48,11 +65,23 @@ func NewP2PClientService(
 
 	log.Debugf("Client service dialer %s Localhost %s", localDialer.ID(), mainHost.ID())
 
+	var (
+		lb      string
+		refresh int
+	)
+	_ = cfg.Get("LoadBalancer", &lb)
+	_ = cfg.Get("ResolverRefresh", &refresh)
+
 	return &clientImpl{
 		ds:       d,
 		h:        localDialer,
 		hostAddr: hostAddr,
 		svcs:     services,
+		pol:      newPolicies(cfg),
+		cache:    newConnCache(cfg),
+		dopts:    opts,
+		lb:       lb,
+		refresh:  settings.GRPC{ResolverRefresh: refresh}.ResolverRefreshDuration(),
 	}, nil
 }
 
@@ -60,12 +89,14 @@ func NewP2PClientAdvertiser(
 	cfg config.Config,
 	d discovery.Discovery,
 	tm *taskmanager.TaskManager,
+	st status.Manager,
 ) error {
 	var services []string
 	found := cfg.Get("Services", &services)
 	if found {
-		// Start discovery provider
-		dp := &discoveryProvider{ds: d, services: services}
+		// Start discovery provider. The services are not advertised once the
+		// node starts draining
+		dp := &discoveryProvider{ds: d, services: services, draining: st.Draining()}
 		_, err := tm.Go(dp)
 		if err != nil {
 			return err
@@ -77,6 +108,7 @@ func NewP2PClientAdvertiser(
 type discoveryProvider struct {
 	services []string
 	ds       discovery.Discovery
+	draining <-chan struct{}
 }
 
 func (d *discoveryProvider) Name() string {
@@ -99,6 +131,9 @@ func (d *discoveryProvider) Execute(ctx context.Context) error {
 			select {
 			case <-time.After(time.Minute * 2):
 				continue
+			case <-d.draining:
+				log.Info("stopping advertiser, node is draining")
+				return nil
 			case <-ctx.Done():
 				return nil
 			}
@@ -106,6 +141,9 @@ func (d *discoveryProvider) Execute(ctx context.Context) error {
 		wait := 7 * discoveryTTL / 8
 		select {
 		case <-time.After(wait):
+		case <-d.draining:
+			log.Info("stopping advertiser, node is draining")
+			return nil
 		case <-ctx.Done():
 			log.Info("stopping advertiser")
 			return nil
@@ -118,20 +156,76 @@ type clientImpl struct {
 	h        host.Host
 	svcs     []string
 	hostAddr peer.AddrInfo
+	pol      *policies
+	cache    *connCache
+	dopts    []grpc.DialOption
+	lb       string
+	refresh  time.Duration
 }
 
+// Get dials the service. Local services are dialed on the local host. If the
+// load balancer is configured, the connection is balanced across all the peers
+// of the service, otherwise the first peer found is dialed
 func (c *clientImpl) Get(
 	ctx context.Context,
 	svc string,
 	opts ...grpc.DialOption,
+) (*Conn, error) {
+	return c.cache.getOrDial(svc, opts, func(opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+		return c.dial(ctx, svc, append(append([]grpc.DialOption{}, c.dopts...), opts...)...)
+	})
+}
+
+// Close closes the connections cached and the ones used by the circuit breakers
+func (c *clientImpl) Close() error {
+	c.cache.close()
+	c.pol.close()
+	return nil
+}
+
+func (c *clientImpl) dial(
+	ctx context.Context,
+	svc string,
+	opts ...grpc.DialOption,
 ) (*grpc.ClientConn, error) {
+	if c.lb != "" && !c.isLocal(svc) {
+		// The balancer avoids the peers which cannot be reached, so the circuit
+		// breaker applies to all the peers together
+		opts = append(
+			c.pol.dialOptions(svc, nil, opts),
+			grpc.WithResolvers(&resolverBuilder{
+				scheme:   P2PScheme,
+				lookup:   c.findPeers,
+				refresh:  c.refresh,
+				lbConfig: balancerConfig(c.lb),
+			}),
+		)
+		return p2pgrpc.NewP2PDialer(c.h).Dial(ctx, P2PScheme+":///"+svc, opts...)
+	}
+	return c.dialExcept(ctx, svc, nil, c.pol.dialOptions(svc, c, opts)...)
+}
 
-	// Local service, dial to locally running P2P host
+func (c *clientImpl) isLocal(svc string) bool {
 	for _, v := range c.svcs {
 		if svc == v {
-			return p2pgrpc.NewP2PDialer(c.h).Dial(ctx, c.hostAddr.ID.String(), opts...)
+			return true
 		}
 	}
+	return false
+}
+
+// dialExcept dials a peer of the service other than the ones excluded
+func (c *clientImpl) dialExcept(
+	ctx context.Context,
+	svc string,
+	exclude map[string]bool,
+	opts ...grpc.DialOption,
+) (*grpc.ClientConn, error) {
+
+	// Local service, dial to locally running P2P host
+	if c.isLocal(svc) && !exclude[c.hostAddr.ID.String()] {
+		return p2pgrpc.NewP2PDialer(c.h).Dial(ctx, c.hostAddr.ID.String(), opts...)
+	}
 
 	// FindPeers is called without limit opt, so this cancel is required to release
 	// any resources used by it
@@ -151,6 +245,9 @@ func (c *clientImpl) Get(
 			if !more {
 				return nil, ErrNoPeerForSvc
 			}
+			if exclude[pAddr.ID.String()] {
+				continue
+			}
 			err = c.h.Connect(ctx, pAddr)
 			if err != nil {
 				log.Errorf("failed to connect to peer %v err %v", pAddr, err)
@@ -162,57 +259,163 @@ func (c *clientImpl) Get(
 	}
 }
 
-func NewStaticClientService(c config.Config) ClientSvc {
+// findPeers returns all the peers of the service found using the discovery. The
+// address of each peer is its ID
+func (c *clientImpl) findPeers(ctx context.Context, svc string) ([]resolver.Address, error) {
+	peers, err := c.ds.FindPeers(ctx, svc)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []resolver.Address
+	for pAddr := range peers {
+		if pAddr.ID == c.h.ID() {
+			continue
+		}
+		if err := c.h.Connect(ctx, pAddr); err != nil {
+			log.Errorf("failed to connect to peer %v err %v", pAddr, err)
+			continue
+		}
+		addrs = append(addrs, resolver.Address{Addr: pAddr.ID.String()})
+	}
+	if len(addrs) == 0 {
+		return nil, ErrNoPeerForSvc
+	}
+	return addrs, nil
+}
+
+// StaticClientSvc is the ClientSvc using the static addresses configured. The
+// addresses can be updated while running
+type StaticClientSvc interface {
+	ClientSvc
+	SetAddresses(map[string]string)
+}
+
+// StaticOption configures the static client service
+type StaticOption func(*staticClientImpl)
+
+// WithTLS dials the TCP addresses using TLS. The config is called for each
+// connection, so that the certificates can be updated. The peer ID is set if the
+// address ends with /p2p/<peer ID>, so that the server can be verified using it
+func WithTLS(config func(peer.ID) (*tls.Config, error)) StaticOption {
+	return func(c *staticClientImpl) {
+		c.tlsConfig = config
+	}
+}
+
+// WithDialOptions sets the dial options used for all the connections, before the
+// ones of the callers
+func WithDialOptions(opts ...grpc.DialOption) StaticOption {
+	return func(c *staticClientImpl) {
+		c.dopts = append(c.dopts, opts...)
+	}
+}
+
+func NewStaticClientService(c config.Config, opts ...StaticOption) ClientSvc {
 	svcAddrs := make(map[string]string)
 	c.Get("StaticAddresses", &svcAddrs)
-	return &staticClientImpl{
+	sc := &staticClientImpl{
 		svcAddrs: svcAddrs,
+		pol:      newPolicies(c),
+		cache:    newConnCache(c),
+	}
+	for _, opt := range opts {
+		opt(sc)
 	}
+	return sc
 }
 
 type staticClientImpl struct {
-	svcAddrs map[string]string
+	mtx       sync.RWMutex
+	svcAddrs  map[string]string
+	pol       *policies
+	cache     *connCache
+	dopts     []grpc.DialOption
+	tlsConfig func(peer.ID) (*tls.Config, error)
+}
+
+// splitPeer returns the address and the peer ID of the static addresses ending
+// with /p2p/<peer ID>
+func splitPeer(addr string) (string, peer.ID, error) {
+	idx := strings.LastIndex(addr, "/p2p/")
+	if idx == -1 {
+		return addr, "", nil
+	}
+	id, err := peer.Decode(addr[idx+len("/p2p/"):])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid peer ID in %s: %w", addr, err)
+	}
+	return addr[:idx], id, nil
+}
+
+// SetAddresses updates the addresses. The connections cached for the services
+// whose address changed are evicted
+func (c *staticClientImpl) SetAddresses(svcAddrs map[string]string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for svc, addr := range c.svcAddrs {
+		if svcAddrs[svc] != addr {
+			c.cache.remove(svc)
+		}
+	}
+	c.svcAddrs = svcAddrs
 }
 
 func (c *staticClientImpl) Get(
 	ctx context.Context,
 	svc string,
 	opts ...grpc.DialOption,
+) (*Conn, error) {
+	return c.cache.getOrDial(svc, opts, func(opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+		return c.dial(ctx, svc, append(append([]grpc.DialOption{}, c.dopts...), opts...)...)
+	})
+}
+
+// Close closes the connections cached
+func (c *staticClientImpl) Close() error {
+	c.cache.close()
+	c.pol.close()
+	return nil
+}
+
+func (c *staticClientImpl) dial(
+	ctx context.Context,
+	svc string,
+	opts ...grpc.DialOption,
 ) (*grpc.ClientConn, error) {
+	c.mtx.RLock()
 	addr, ok := c.svcAddrs[svc]
+	c.mtx.RUnlock()
 	if !ok {
 		return nil, errors.New("service address not configured")
 	}
 
-	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
-		var (
-			conn net.Conn
-			err  error
-			done = make(chan struct{})
-		)
-		go func() {
-			defer close(done)
-
-			if ipAddr := net.ParseIP(addr); ipAddr != nil {
-				conn, err = net.Dial("tcp", addr)
-				return
-			}
-			if _, e := os.Stat(addr); e == nil {
-				conn, err = net.Dial("unix", addr)
-				return
-			}
-			err = fmt.Errorf("transport not supported %s", addr)
-		}()
-
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case <-done:
-			return conn, err
+	addr, remote, err := splitPeer(addr)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(c.pol.dialOptions(svc, nil, opts), grpc.WithContextDialer(c.dialer))
+	if _, _, err := net.SplitHostPort(addr); err == nil && c.tlsConfig != nil {
+		// Unix sockets are local, so only the TCP addresses use TLS
+		cfg, err := c.tlsConfig(remote)
+		if err != nil {
+			return nil, err
 		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
 	}
 
-	opts = append(opts, grpc.WithContextDialer(dialer))
-
 	return grpc.DialContext(ctx, addr, opts...)
 }
+
+// dialer dials the TCP addresses given as host:port and the unix sockets
+func (c *staticClientImpl) dialer(ctx context.Context, addr string) (net.Conn, error) {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	if _, err := os.Stat(addr); err == nil {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", addr)
+	}
+	return nil, fmt.Errorf("transport not supported %s", addr)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
 	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	"github.com/plexsysio/go-msuite/modules/diag/status"
 	grpcclient "github.com/plexsysio/go-msuite/modules/grpc/client"
 	"github.com/plexsysio/go-msuite/modules/grpc/p2pgrpc"
 	"github.com/plexsysio/taskmanager"
@@ -148,7 +149,8 @@ func TestP2PAdvertiser(t *testing.T) {
 
 	adv := make(chan string)
 	d := &testDiscovery{adv: adv}
-	err := grpcclient.NewP2PClientAdvertiser(cfg, d, tm)
+	st := status.New()
+	err := grpcclient.NewP2PClientAdvertiser(cfg, d, tm, st)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -164,4 +166,10 @@ func TestP2PAdvertiser(t *testing.T) {
 	if s != "svc2" {
 		t.Fatal("incorrect advertisement", s)
 	}
+
+	st.Drain()
+	time.Sleep(100 * time.Millisecond)
+	if _, found := tm.TaskStatus()["DiscoveryProvider"]; found {
+		t.Fatal("advertiser running after drain")
+	}
 }
@@ -0,0 +1,328 @@
+package grpcclient
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// peerDialer is implemented by the client services which can dial another peer
+// of the service. It is used once the circuit breaker of a peer opens
+type peerDialer interface {
+	dialExcept(context.Context, string, map[string]bool, ...grpc.DialOption) (*grpc.ClientConn, error)
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// policies applies the client policies configured using interceptors. Breakers
+// are kept for each service and peer. The connections dialed to other peers are
+// reused by the later calls till their breakers open, after which they are
+// closed
+type policies struct {
+	cfg settings.ClientPolicies
+
+	mtx       sync.Mutex
+	breakers  map[string]*breaker
+	fallbacks map[string]map[string]*grpc.ClientConn
+}
+
+func newPolicies(c config.Config) *policies {
+	cfg := settings.ClientPolicies{}
+	_ = c.Get("ClientPolicies", &cfg)
+	return &policies{
+		cfg:       cfg,
+		breakers:  make(map[string]*breaker),
+		fallbacks: make(map[string]map[string]*grpc.ClientConn),
+	}
+}
+
+func (p *policies) policy(svc string) (settings.ClientPolicy, bool) {
+	if pol, found := p.cfg[svc]; found {
+		return pol, true
+	}
+	pol, found := p.cfg["*"]
+	return pol, found
+}
+
+// dialOptions adds the interceptors applying the policy of the service. The
+// options passed are used as is to dial the other peers
+func (p *policies) dialOptions(svc string, d peerDialer, opts []grpc.DialOption) []grpc.DialOption {
+	pol, found := p.policy(svc)
+	if !found {
+		return opts
+	}
+	base := append([]grpc.DialOption{}, opts...)
+	return append(
+		base,
+		grpc.WithChainUnaryInterceptor(p.unary(svc, pol, d, opts)),
+		grpc.WithChainStreamInterceptor(p.stream(svc, pol, d, opts)),
+	)
+}
+
+// unary applies the default deadline, retries and the circuit breaker
+func (p *policies) unary(
+	svc string,
+	pol settings.ClientPolicy,
+	d peerDialer,
+	opts []grpc.DialOption,
+) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		callOpts ...grpc.CallOption,
+	) error {
+		if timeout := pol.TimeoutDuration(); timeout > 0 {
+			if _, found := ctx.Deadline(); !found {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+		}
+		return p.retry(ctx, pol, func() error {
+			conn, target, err := p.pick(ctx, svc, pol, cc, d, opts)
+			if err != nil {
+				return err
+			}
+			if conn == cc {
+				err = invoker(ctx, method, req, reply, cc, callOpts...)
+			} else {
+				err = conn.Invoke(ctx, method, req, reply, callOpts...)
+			}
+			p.record(ctx, svc, target, pol, err)
+			return err
+		})
+	}
+}
+
+// stream applies the retries and the circuit breaker while creating the stream.
+// The default deadline is not used for streams
+func (p *policies) stream(
+	svc string,
+	pol settings.ClientPolicy,
+	d peerDialer,
+	opts []grpc.DialOption,
+) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		callOpts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		var cs grpc.ClientStream
+		err := p.retry(ctx, pol, func() error {
+			conn, target, err := p.pick(ctx, svc, pol, cc, d, opts)
+			if err != nil {
+				return err
+			}
+			if conn == cc {
+				cs, err = streamer(ctx, desc, cc, method, callOpts...)
+			} else {
+				cs, err = conn.NewStream(ctx, desc, method, callOpts...)
+			}
+			p.record(ctx, svc, target, pol, err)
+			return err
+		})
+		return cs, err
+	}
+}
+
+// retry calls the function again if it fails with UNAVAILABLE. The wait between
+// the retries is doubled every time
+func (p *policies) retry(ctx context.Context, pol settings.ClientPolicy, call func() error) error {
+	backoff := pol.BackoffDuration()
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if status.Code(err) != codes.Unavailable || attempt >= pol.Retries {
+			return err
+		}
+		log.Debugf("retrying call after %s: %v", backoff, err)
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff *= 2
+		if max := pol.MaxBackoffDuration(); backoff > max {
+			backoff = max
+		}
+	}
+}
+
+// pick returns the connection for the call. If the breaker of the peer is open,
+// the call is sent to another peer of the service
+func (p *policies) pick(
+	ctx context.Context,
+	svc string,
+	pol settings.ClientPolicy,
+	cc *grpc.ClientConn,
+	d peerDialer,
+	opts []grpc.DialOption,
+) (*grpc.ClientConn, string, error) {
+	if pol.FailureThreshold == 0 {
+		return cc, cc.Target(), nil
+	}
+
+	p.mtx.Lock()
+	if p.allow(svc, cc.Target()) {
+		p.mtx.Unlock()
+		return cc, cc.Target(), nil
+	}
+	for target, conn := range p.fallbacks[svc] {
+		if p.allow(svc, target) {
+			p.mtx.Unlock()
+			return conn, target, nil
+		}
+	}
+	exclude := map[string]bool{cc.Target(): true}
+	for target := range p.fallbacks[svc] {
+		exclude[target] = true
+	}
+	// The peers evicted from the fallbacks are not dialed till their breakers can
+	// be probed
+	for key, b := range p.breakers {
+		if target := strings.TrimPrefix(key, svc+"/"); target != key && time.Now().Before(b.openUntil) {
+			exclude[target] = true
+		}
+	}
+	p.mtx.Unlock()
+
+	if d == nil {
+		return nil, "", status.Errorf(codes.Unavailable, "circuit breaker open for %s", svc)
+	}
+	conn, err := d.dialExcept(ctx, svc, exclude, opts...)
+	if err != nil {
+		return nil, "", status.Errorf(codes.Unavailable, "circuit breaker open for %s, no other peer: %v", svc, err)
+	}
+	log.Infof("circuit breaker open for %s on %s, using %s", svc, cc.Target(), conn.Target())
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.fallbacks[svc] == nil {
+		p.fallbacks[svc] = make(map[string]*grpc.ClientConn)
+	}
+	if existing, found := p.fallbacks[svc][conn.Target()]; found {
+		// Another call dialed the same peer
+		conn.Close()
+		return existing, existing.Target(), nil
+	}
+	p.fallbacks[svc][conn.Target()] = conn
+	return conn, conn.Target(), nil
+}
+
+// allow reports if the breaker of the peer lets the call through. Once the open
+// timeout is over, a single call is let through to probe the peer. It should be
+// called with the lock held
+func (p *policies) allow(svc, target string) bool {
+	b, found := p.breakers[svc+"/"+target]
+	if !found || b.openUntil.IsZero() {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record updates the breaker of the peer with the result of the call. Only the
+// failures to reach the peer are counted and only the responses from the peer
+// close the breaker. Calls cancelled by the caller, or which ran out of the time
+// given by the caller or the default timeout, do not tell anything about the
+// peer, so the breaker is left as is apart from letting another call probe
+func (p *policies) record(ctx context.Context, svc, target string, pol settings.ClientPolicy, err error) {
+	if pol.FailureThreshold == 0 {
+		return
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	key := svc + "/" + target
+	b, found := p.breakers[key]
+	code := status.Code(err)
+	switch {
+	case code == codes.Unavailable || (code == codes.DeadlineExceeded && ctx.Err() == nil):
+		if !found {
+			b = &breaker{}
+			p.breakers[key] = b
+		}
+		b.failures++
+		b.probing = false
+		if b.failures >= pol.FailureThreshold {
+			if b.openUntil.IsZero() {
+				log.Warnf("circuit breaker opened for %s on %s: %v", svc, target, err)
+			}
+			b.openUntil = time.Now().Add(pol.OpenTimeoutDuration())
+			p.evict(svc, target)
+		}
+	case code == codes.Canceled || code == codes.DeadlineExceeded:
+		if found {
+			b.probing = false
+		}
+	case isResponse(err):
+		if found && !b.openUntil.IsZero() {
+			log.Infof("circuit breaker closed for %s on %s", svc, target)
+		}
+		delete(p.breakers, key)
+	default:
+		if found {
+			b.probing = false
+		}
+	}
+}
+
+// evict closes the connection to the peer if it was dialed as a fallback. It
+// should be called with the lock held
+func (p *policies) evict(svc, target string) {
+	conn, found := p.fallbacks[svc][target]
+	if !found {
+		return
+	}
+	delete(p.fallbacks[svc], target)
+	if len(p.fallbacks[svc]) == 0 {
+		delete(p.fallbacks, svc)
+	}
+	conn.Close()
+}
+
+// isResponse reports if the call got a response from the peer. Errors without
+// a gRPC status are from the client side
+func isResponse(err error) bool {
+	if err == nil {
+		return true
+	}
+	_, ok := status.FromError(err)
+	return ok
+}
+
+// close closes the connections dialed to the other peers
+func (p *policies) close() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	for svc, conns := range p.fallbacks {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		delete(p.fallbacks, svc)
+	}
+}
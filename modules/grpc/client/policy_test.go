@@ -0,0 +1,422 @@
+package grpcclient_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p-blankhost"
+	"github.com/libp2p/go-libp2p-core/discovery"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	gostream "github.com/libp2p/go-libp2p-gostream"
+	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
+	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	grpcclient "github.com/plexsysio/go-msuite/modules/grpc/client"
+	"github.com/plexsysio/go-msuite/modules/grpc/p2pgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// testServer serves the health service. The first failures calls fail with
+// UNAVAILABLE and each call is delayed by delay. If hold is set, the first call
+// across the servers sharing held waits till it is closed
+type testServer struct {
+	calls    int32
+	failures int32
+	delay    time.Duration
+	hold     chan struct{}
+	held     *int32
+}
+
+func (s *testServer) serve(t *testing.T, l net.Listener) {
+	t.Helper()
+
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(
+		ctx context.Context,
+		req interface{},
+		_ *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if atomic.AddInt32(&s.calls, 1) <= atomic.LoadInt32(&s.failures) {
+			return nil, status.Error(codes.Unavailable, "dummy unavailable")
+		}
+		if s.hold != nil && atomic.CompareAndSwapInt32(s.held, 0, 1) {
+			<-s.hold
+		}
+		time.Sleep(s.delay)
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	t.Cleanup(srv.Stop)
+}
+
+func check(cs grpcclient.ClientSvc, svc string) error {
+	conn, err := cs.Get(context.TODO(), svc, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = healthpb.NewHealthClient(conn).Check(context.TODO(), &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestRetryAndTimeout(t *testing.T) {
+	l, err := net.Listen("unix", "/tmp/policy.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		l.Close()
+		_ = os.RemoveAll("/tmp/policy.sock")
+	})
+	srv := &testServer{}
+	srv.serve(t, l)
+
+	cfg := jsonConf.DefaultConfig()
+	cfg.Set("StaticAddresses", map[string]string{
+		"retry":   "/tmp/policy.sock",
+		"noretry": "/tmp/policy.sock",
+		"timeout": "/tmp/policy.sock",
+	})
+	cfg.Set("ClientPolicies", settings.ClientPolicies{
+		"retry":   {Retries: 2, Backoff: "10ms"},
+		"timeout": {Timeout: "50ms"},
+	})
+	cs := grpcclient.NewStaticClientService(cfg)
+
+	atomic.StoreInt32(&srv.failures, 2)
+	start := time.Now()
+	if err := check(cs, "retry"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&srv.calls); calls != 3 {
+		t.Fatal("expected 2 retries", calls)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatal("expected backoff between retries", elapsed)
+	}
+
+	atomic.StoreInt32(&srv.calls, 0)
+	if err := check(cs, "noretry"); status.Code(err) != codes.Unavailable {
+		t.Fatal("expected unavailable without policy", err)
+	}
+
+	atomic.StoreInt32(&srv.failures, 0)
+	srv.delay = 200 * time.Millisecond
+	if err := check(cs, "timeout"); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatal("expected default deadline", err)
+	}
+}
+
+type peersDiscovery struct {
+	mtx   sync.Mutex
+	peers []peer.AddrInfo
+}
+
+func (d *peersDiscovery) add(p peer.AddrInfo) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.peers = append(d.peers, p)
+}
+
+func (d *peersDiscovery) Advertise(context.Context, string, ...discovery.Option) (time.Duration, error) {
+	return time.Second, nil
+}
+
+func (d *peersDiscovery) FindPeers(context.Context, string, ...discovery.Option) (<-chan peer.AddrInfo, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	res := make(chan peer.AddrInfo, len(d.peers))
+	for _, p := range d.peers {
+		res <- p
+	}
+	close(res)
+	return res, nil
+}
+
+func servePeer(t *testing.T, srv *testServer) host.Host {
+	t.Helper()
+
+	h := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	t.Cleanup(func() { h.Close() })
+	l, err := gostream.Listen(h, p2pgrpc.Protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.serve(t, l)
+	return h
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	failing, healthy := &testServer{failures: 100}, &testServer{}
+	h1 := servePeer(t, failing)
+	h2 := servePeer(t, healthy)
+
+	dialer := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	local := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	t.Cleanup(func() {
+		dialer.Close()
+		local.Close()
+	})
+
+	cfg := jsonConf.DefaultConfig()
+	cfg.Set("ClientPolicies", settings.ClientPolicies{
+		"*": {Retries: 3, Backoff: "1ms", FailureThreshold: 2, OpenTimeout: "1m"},
+	})
+	cs, err := grpcclient.NewP2PClientService(
+		cfg,
+		&peersDiscovery{peers: []peer.AddrInfo{
+			h1.Peerstore().PeerInfo(h1.ID()),
+			h2.Peerstore().PeerInfo(h2.ID()),
+		}},
+		dialer,
+		local,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := cs.Get(context.TODO(), "svc", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Target() != h1.ID().String() {
+		t.Fatal("expected first peer to be dialed", conn.Target())
+	}
+
+	hc := healthpb.NewHealthClient(conn)
+	if _, err := hc.Check(context.TODO(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&failing.calls); calls != 2 {
+		t.Fatal("expected breaker to open after 2 failures", calls)
+	}
+	if calls := atomic.LoadInt32(&healthy.calls); calls != 1 {
+		t.Fatal("expected call to be sent to the other peer", calls)
+	}
+
+	// Calls go to the other peer while the breaker is open
+	if _, err := hc.Check(context.TODO(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&failing.calls) != 2 || atomic.LoadInt32(&healthy.calls) != 2 {
+		t.Fatal("incorrect calls with breaker open", failing.calls, healthy.calls)
+	}
+}
+
+func TestCircuitBreakerCancelledProbe(t *testing.T) {
+	failing, healthy := &testServer{failures: 2, delay: 300 * time.Millisecond}, &testServer{}
+	h1 := servePeer(t, failing)
+	h2 := servePeer(t, healthy)
+
+	dialer := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	local := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	t.Cleanup(func() {
+		dialer.Close()
+		local.Close()
+	})
+
+	cfg := jsonConf.DefaultConfig()
+	cfg.Set("ClientPolicies", settings.ClientPolicies{
+		"*": {Retries: 3, Backoff: "1ms", FailureThreshold: 2, OpenTimeout: "100ms"},
+	})
+	cs, err := grpcclient.NewP2PClientService(
+		cfg,
+		&peersDiscovery{peers: []peer.AddrInfo{
+			h1.Peerstore().PeerInfo(h1.ID()),
+			h2.Peerstore().PeerInfo(h2.ID()),
+		}},
+		dialer,
+		local,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := cs.Get(context.TODO(), "svc", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	hc := healthpb.NewHealthClient(conn)
+	if _, err := hc.Check(context.TODO(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&failing.calls) != 2 || atomic.LoadInt32(&healthy.calls) != 1 {
+		t.Fatal("expected breaker to open", failing.calls, healthy.calls)
+	}
+
+	// The probe after the open timeout is cancelled by the caller
+	time.Sleep(150 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.TODO())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = hc.Check(ctx, &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.Canceled {
+		t.Fatal("expected probe to be cancelled", err)
+	}
+	if calls := atomic.LoadInt32(&failing.calls); calls != 3 {
+		t.Fatal("expected probe to be sent to the failing peer", calls)
+	}
+
+	// The breaker is still half-open, so only one of the calls probes the peer
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := hc.Check(context.TODO(), &healthpb.HealthCheckRequest{}); err != nil {
+				t.Error(err)
+			}
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&failing.calls) != 4 || atomic.LoadInt32(&healthy.calls) != 2 {
+		t.Fatal("expected cancelled probe to leave the breaker open", failing.calls, healthy.calls)
+	}
+}
+
+func TestCircuitBreakerCallerDeadline(t *testing.T) {
+	slow := &testServer{delay: 200 * time.Millisecond}
+	h := servePeer(t, slow)
+
+	dialer := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	local := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	t.Cleanup(func() {
+		dialer.Close()
+		local.Close()
+	})
+
+	cfg := jsonConf.DefaultConfig()
+	cfg.Set("ClientPolicies", settings.ClientPolicies{
+		"*":       {FailureThreshold: 1, OpenTimeout: "1m"},
+		"timeout": {Timeout: "50ms", FailureThreshold: 1, OpenTimeout: "1m"},
+	})
+	cs, err := grpcclient.NewP2PClientService(
+		cfg,
+		&peersDiscovery{peers: []peer.AddrInfo{h.Peerstore().PeerInfo(h.ID())}},
+		dialer,
+		local,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, svc := range []string{"svc", "timeout"} {
+		conn, err := cs.Get(context.TODO(), svc, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		hc := healthpb.NewHealthClient(conn)
+		// The deadlines of the caller or the policy expire before the slow peer
+		// responds, which does not mean the peer failed
+		for i := 0; i < 2; i++ {
+			ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+			_, err := hc.Check(ctx, &healthpb.HealthCheckRequest{})
+			cancel()
+			if status.Code(err) != codes.DeadlineExceeded {
+				t.Fatal("expected deadline exceeded", svc, err)
+			}
+		}
+		calls := atomic.LoadInt32(&slow.calls)
+		if svc == "timeout" {
+			_, err = hc.Check(context.TODO(), &healthpb.HealthCheckRequest{})
+			if status.Code(err) != codes.DeadlineExceeded {
+				t.Fatal("expected default deadline", err)
+			}
+		} else if _, err := hc.Check(context.TODO(), &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatal("expected breaker to be closed", err)
+		}
+		if atomic.LoadInt32(&slow.calls) != calls+1 {
+			t.Fatal("expected call to be sent to the peer", svc)
+		}
+	}
+}
+
+func TestCircuitBreakerFallbackEvicted(t *testing.T) {
+	failing, fallback, healthy := &testServer{failures: 100}, &testServer{}, &testServer{}
+	h1 := servePeer(t, failing)
+	h2 := servePeer(t, fallback)
+	h3 := servePeer(t, healthy)
+
+	dialer := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	local := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	t.Cleanup(func() {
+		dialer.Close()
+		local.Close()
+	})
+
+	cfg := jsonConf.DefaultConfig()
+	cfg.Set("ClientPolicies", settings.ClientPolicies{
+		"*": {Retries: 4, Backoff: "1ms", FailureThreshold: 2, OpenTimeout: "1m"},
+	})
+	cs, err := grpcclient.NewP2PClientService(
+		cfg,
+		&peersDiscovery{peers: []peer.AddrInfo{
+			h1.Peerstore().PeerInfo(h1.ID()),
+			h2.Peerstore().PeerInfo(h2.ID()),
+			h3.Peerstore().PeerInfo(h3.ID()),
+		}},
+		dialer,
+		local,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := cs.Get(context.TODO(), "svc", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	hc := healthpb.NewHealthClient(conn)
+	if _, err := hc.Check(context.TODO(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&fallback.calls) != 1 || atomic.LoadInt32(&healthy.calls) != 0 {
+		t.Fatal("expected call to be sent to the first fallback", fallback.calls, healthy.calls)
+	}
+
+	// Once the breaker of the fallback opens, another peer is dialed
+	atomic.StoreInt32(&fallback.failures, 100)
+	if _, err := hc.Check(context.TODO(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&fallback.calls) != 3 || atomic.LoadInt32(&healthy.calls) != 1 {
+		t.Fatal("expected fallback to be replaced", fallback.calls, healthy.calls)
+	}
+	// The connection to the evicted fallback is closed
+	time.Sleep(100 * time.Millisecond)
+	for _, c := range h2.Network().ConnsToPeer(dialer.ID()) {
+		if streams := len(c.GetStreams()); streams != 0 {
+			t.Fatal("expected connection to evicted fallback to be closed", streams)
+		}
+	}
+	if _, err := hc.Check(context.TODO(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&fallback.calls) != 3 || atomic.LoadInt32(&healthy.calls) != 2 {
+		t.Fatal("expected evicted fallback not to be used", fallback.calls, healthy.calls)
+	}
+}
@@ -0,0 +1,231 @@
+package grpcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	gostream "github.com/libp2p/go-libp2p-gostream"
+	"github.com/plexsysio/go-msuite/modules/grpc/p2pgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+)
+
+// ErrNoNode is returned if the msuite scheme is used without a node running
+var ErrNoNode = errors.New("no msuite node running for the target")
+
+func init() {
+	resolver.Register(names)
+}
+
+// names is the resolver builder of the msuite scheme. It uses the name
+// resolvers of the nodes running
+var names = &nameRegistry{}
+
+type nameRegistry struct {
+	mtx   sync.Mutex
+	nodes []*NameResolver
+}
+
+func (r *nameRegistry) Scheme() string {
+	return Scheme
+}
+
+func (r *nameRegistry) Build(
+	target resolver.Target,
+	cc resolver.ClientConn,
+	opts resolver.BuildOptions,
+) (resolver.Resolver, error) {
+	r.mtx.Lock()
+	var nr *NameResolver
+	for i := len(r.nodes) - 1; i >= 0; i-- {
+		if target.URL.Host == "" || target.URL.Host == r.nodes[i].id {
+			nr = r.nodes[i]
+			break
+		}
+	}
+	r.mtx.Unlock()
+
+	if nr == nil {
+		return nil, ErrNoNode
+	}
+	return nr.b.Build(target, cc, opts)
+}
+
+func (r *nameRegistry) add(nr *NameResolver) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.nodes = append(r.nodes, nr)
+}
+
+// get returns the resolver of the node registered
+func (r *nameRegistry) get(id string) *NameResolver {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, n := range r.nodes {
+		if n.id == id {
+			return n
+		}
+	}
+	return nil
+}
+
+func (r *nameRegistry) remove(nr *NameResolver) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for i, n := range r.nodes {
+		if n == nr {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+			return
+		}
+	}
+}
+
+// NameResolver resolves the msuite service names of a node. Static addresses are
+// used if configured for the service, otherwise the peers are found using the
+// libp2p discovery.
+//
+// Plain gRPC connections can only dial TCP addresses, so the libp2p peers and
+// the unix sockets are resolved to addresses which are dialed by NameDialer.
+// No ports are opened for them, so the peers can only be reached by the
+// connections of the process
+type NameResolver struct {
+	id     string
+	p2p    *clientImpl
+	static *staticClientImpl
+	b      *resolverBuilder
+}
+
+// NewNameResolver returns the resolver using the client services of the node.
+// Either of them can be nil. The id is the peer ID of the node, used to select
+// the node in the target authority
+func NewNameResolver(
+	id string,
+	p2p ClientSvc,
+	static ClientSvc,
+	lb string,
+	refresh time.Duration,
+) *NameResolver {
+	nr := &NameResolver{id: id}
+	nr.p2p, _ = p2p.(*clientImpl)
+	nr.static, _ = static.(*staticClientImpl)
+	nr.b = &resolverBuilder{
+		scheme:   Scheme,
+		lookup:   nr.lookup,
+		refresh:  refresh,
+		lbConfig: balancerConfig(lb),
+	}
+	return nr
+}
+
+// Register makes the resolver available for the msuite scheme. The last node
+// registered is used for the targets without authority
+func (n *NameResolver) Register() {
+	names.add(n)
+}
+
+// Close unregisters the resolver. The libp2p peers resolved can no longer be
+// dialed after this
+func (n *NameResolver) Close() error {
+	names.remove(n)
+	return nil
+}
+
+func (n *NameResolver) lookup(ctx context.Context, svc string) ([]resolver.Address, error) {
+	if n.static != nil {
+		n.static.mtx.RLock()
+		addr, found := n.static.svcAddrs[svc]
+		n.static.mtx.RUnlock()
+		if found {
+			return staticAddr(addr)
+		}
+	}
+	if n.p2p == nil {
+		return nil, errors.New("service address not configured")
+	}
+
+	var peers []string
+	if n.p2p.isLocal(svc) {
+		// Local services are dialed on the local host like the client service
+		peers = append(peers, n.p2p.hostAddr.ID.String())
+	} else {
+		found, err := n.p2p.findPeers(ctx, svc)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range found {
+			peers = append(peers, a.Addr)
+		}
+	}
+	addrs := make([]resolver.Address, 0, len(peers))
+	for _, p := range peers {
+		if _, err := peer.Decode(p); err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, resolver.Address{Addr: p2pAddrPrefix + n.id + "/" + p})
+	}
+	return addrs, nil
+}
+
+// staticAddr returns the TCP addresses as is and the unix sockets with the
+// prefix used by the dialer. The peer IDs of the addresses are not used, the
+// credentials are set by the caller
+func staticAddr(addr string) ([]resolver.Address, error) {
+	addr, _, err := splitPeer(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return []resolver.Address{{Addr: addr}}, nil
+	}
+	if _, err := os.Stat(addr); err != nil {
+		return nil, fmt.Errorf("transport not supported %s", addr)
+	}
+	return []resolver.Address{{Addr: unixAddrPrefix + addr}}, nil
+}
+
+// Prefixes of the addresses resolved which are not dialed using TCP. The libp2p
+// addresses are p2p/<node ID>/<peer ID>, so that the host of the node is used
+const (
+	p2pAddrPrefix  = "p2p/"
+	unixAddrPrefix = "unix/"
+)
+
+// NameDialer returns the dial option needed by the connections to the msuite
+// targets. The libp2p peers and unix sockets resolved are dialed directly, the
+// TCP addresses are dialed as usual
+func NameDialer() grpc.DialOption {
+	return grpc.WithContextDialer(dialName)
+}
+
+func dialName(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	switch {
+	case strings.HasPrefix(addr, unixAddrPrefix):
+		return d.DialContext(ctx, "unix", strings.TrimPrefix(addr, unixAddrPrefix))
+	case strings.HasPrefix(addr, p2pAddrPrefix):
+		parts := strings.SplitN(strings.TrimPrefix(addr, p2pAddrPrefix), "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid address %s", addr)
+		}
+		pid, err := peer.Decode(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		nr := names.get(parts[0])
+		if nr == nil || nr.p2p == nil {
+			return nil, ErrNoNode
+		}
+		return gostream.Dial(ctx, nr.p2p.h, pid, p2pgrpc.Protocol)
+	}
+	return d.DialContext(ctx, "tcp", addr)
+}
@@ -0,0 +1,114 @@
+package grpcclient_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p-blankhost"
+	"github.com/libp2p/go-libp2p-core/peer"
+	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
+	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	grpcclient "github.com/plexsysio/go-msuite/modules/grpc/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func dialCheck(t *testing.T, target string, srv *testServer) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := grpc.Dial(
+		target,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpcclient.NameDialer(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	calls := atomic.LoadInt32(&srv.calls)
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatal(target, err)
+	}
+	if atomic.LoadInt32(&srv.calls) != calls+1 {
+		t.Fatal("call not received by the server", target)
+	}
+}
+
+func TestNameResolver(t *testing.T) {
+	l, err := net.Listen("unix", "/tmp/resolver.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		l.Close()
+		_ = os.RemoveAll("/tmp/resolver.sock")
+	})
+	udsSrv := &testServer{}
+	udsSrv.serve(t, l)
+
+	tl, err := net.Listen("tcp", "127.0.0.1:10083")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpSrv := &testServer{}
+	tcpSrv.serve(t, tl)
+
+	p2pSrv := &testServer{}
+	h := servePeer(t, p2pSrv)
+
+	cfg := jsonConf.DefaultConfig()
+	cfg.Set("StaticAddresses", map[string]string{
+		"uds": "/tmp/resolver.sock",
+		"tcp": "127.0.0.1:10083",
+	})
+	static := grpcclient.NewStaticClientService(cfg)
+
+	dialer := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	local := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	t.Cleanup(func() {
+		dialer.Close()
+		local.Close()
+	})
+	p2p, err := grpcclient.NewP2PClientService(
+		jsonConf.DefaultConfig(),
+		&peersDiscovery{peers: []peer.AddrInfo{h.Peerstore().PeerInfo(h.ID())}},
+		dialer,
+		local,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := grpc.Dial("msuite:///tcp", grpc.WithTransportCredentials(insecure.NewCredentials())); err == nil {
+		t.Fatal("expected dial to fail without node")
+	}
+
+	nr := grpcclient.NewNameResolver(local.ID().Pretty(), p2p, static, "", time.Minute)
+	nr.Register()
+
+	dialCheck(t, "msuite:///tcp", tcpSrv)
+	dialCheck(t, "msuite:///uds", udsSrv)
+	dialCheck(t, "msuite:///p2p", p2pSrv)
+	dialCheck(t, "msuite://"+local.ID().Pretty()+"/p2p", p2pSrv)
+
+	if _, err := grpc.Dial("msuite://unknown/p2p", grpc.WithTransportCredentials(insecure.NewCredentials())); err == nil {
+		t.Fatal("expected dial to fail for unknown node")
+	}
+
+	if err := nr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := grpc.Dial("msuite:///tcp", grpc.WithTransportCredentials(insecure.NewCredentials())); err == nil {
+		t.Fatal("expected dial to fail after close")
+	}
+}
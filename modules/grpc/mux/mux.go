@@ -10,6 +10,7 @@ import (
 
 	"github.com/hashicorp/go-multierror"
 	logger "github.com/ipfs/go-log/v2"
+	"github.com/plexsysio/go-msuite/modules/diag/status"
 	"github.com/plexsysio/taskmanager"
 )
 
@@ -20,6 +21,16 @@ type MuxListener struct {
 	Tag   string
 }
 
+// Listener states reported in the status
+const (
+	notRunning = "not running"
+	running    = "running"
+	stopped    = "stopped"
+)
+
+// defaultCloseTimeout is the time allowed for the listeners to stop on Close
+const defaultCloseTimeout = 3 * time.Second
+
 type Mux struct {
 	muxCtx    context.Context
 	muxCancel context.CancelFunc
@@ -29,8 +40,13 @@ type Mux struct {
 	wg        sync.WaitGroup
 	statusMtx sync.Mutex
 	status    map[string]string
+	failed    map[string]bool
 	addrs     []net.Addr
 	closers   []io.Closer
+
+	closeTimeout time.Duration
+	closeOnce    sync.Once
+	closeErr     error
 }
 
 func New(
@@ -46,9 +62,12 @@ func New(
 		tm:        tm,
 		connChan:  make(chan net.Conn, 50),
 		status:    make(map[string]string),
+		failed:    make(map[string]bool),
+
+		closeTimeout: defaultCloseTimeout,
 	}
 	for _, v := range listeners {
-		m.updateStatus(v.Tag, "not running")
+		m.updateStatus(v.Tag, notRunning)
 	}
 	return m
 }
@@ -60,11 +79,66 @@ func (m *Mux) updateStatus(key, value string) {
 	m.status[key] = value
 }
 
+// markRunning updates the status unless the listener has already failed
+func (m *Mux) markRunning(key string) {
+	m.statusMtx.Lock()
+	defer m.statusMtx.Unlock()
+
+	if !m.failed[key] {
+		m.status[key] = running
+	}
+}
+
+func (m *Mux) reportFailure(key, value string) {
+	m.statusMtx.Lock()
+	defer m.statusMtx.Unlock()
+
+	m.status[key] = value
+	m.failed[key] = true
+}
+
 func (m *Mux) Status() interface{} {
 	m.statusMtx.Lock()
 	defer m.statusMtx.Unlock()
 
-	return m.status
+	st := make(map[string]string, len(m.status))
+	for k, v := range m.status {
+		st[k] = v
+	}
+	return st
+}
+
+// Health reports failed if all the listeners have failed and degraded if some
+// of them have failed
+func (m *Mux) Health() status.Health {
+	m.statusMtx.Lock()
+	defer m.statusMtx.Unlock()
+
+	switch {
+	case len(m.failed) == 0:
+		return status.OK
+	case len(m.failed) == len(m.listeners):
+		return status.Failed
+	default:
+		return status.Degraded
+	}
+}
+
+// Ready reports true if the mux is not closed and atleast one listener is
+// accepting connections
+func (m *Mux) Ready() bool {
+	if m.muxCtx.Err() != nil {
+		return false
+	}
+	m.statusMtx.Lock()
+	defer m.statusMtx.Unlock()
+
+	for _, v := range m.status {
+		if v == running {
+			return true
+		}
+	}
+	return false
 }
 
 func (m *Mux) Start(ctx context.Context) error {
@@ -82,7 +156,12 @@ func (m *Mux) Start(ctx context.Context) error {
 			listener: listener,
 			connChan: m.connChan,
 			reportErr: func(k string, err error) {
-				m.updateStatus(k, "failed with err: "+err.Error())
+				// Listeners are closed on stopping the mux, so this is not a failure
+				if m.muxCtx.Err() != nil {
+					m.updateStatus(k, stopped)
+					return
+				}
+				m.reportFailure(k, "failed with err: "+err.Error())
 			},
 		}
 		m.wg.Add(1)
@@ -91,12 +170,12 @@ func (m *Mux) Start(ctx context.Context) error {
 			return l.Execute(c)
 		})
 		if err != nil {
-			m.updateStatus(m.listeners[i].Tag, "failed to start err: "+err.Error())
+			m.reportFailure(m.listeners[i].Tag, "failed to start err: "+err.Error())
 			continue
 		}
 		select {
 		case <-sched:
-			m.updateStatus(m.listeners[i].Tag, "running")
+			m.markRunning(m.listeners[i].Tag)
 		case <-ctx.Done():
 			return ctx.Err()
 		}
@@ -115,7 +194,22 @@ func (m *Mux) Accept() (net.Conn, error) {
 	}
 }
 
+// SetCloseTimeout sets the time allowed for the listeners to stop on Close. It
+// should be called before the mux is started
+func (m *Mux) SetCloseTimeout(d time.Duration) {
+	m.closeTimeout = d
+}
+
+// Close stops all the listeners. The gRPC server closes the mux on stop, so it
+// is safe to call multiple times and the result of the first call is returned
 func (m *Mux) Close() error {
+	m.closeOnce.Do(func() {
+		m.closeErr = m.close()
+	})
+	return m.closeErr
+}
+
+func (m *Mux) close() error {
 	stopped := make(chan struct{})
 	go func() {
 		m.wg.Wait()
@@ -133,7 +227,7 @@ func (m *Mux) Close() error {
 
 	select {
 	case <-stopped:
-	case <-time.After(3 * time.Second):
+	case <-time.After(m.closeTimeout):
 		err = multierror.Append(err, errors.New("failed to stop listeners"))
 	}
 
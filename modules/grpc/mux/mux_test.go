@@ -2,6 +2,7 @@ package grpcmux_test
 
 import (
 	"context"
+	"errors"
 	"net"
 	"strings"
 	"sync"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	logger "github.com/ipfs/go-log/v2"
+	"github.com/plexsysio/go-msuite/modules/diag/status"
 	grpcmux "github.com/plexsysio/go-msuite/modules/grpc/mux"
 	"github.com/plexsysio/taskmanager"
 )
@@ -122,7 +124,69 @@ func TestMultipleListeners(t *testing.T) {
 		t.Fatal("waited 3 secs for done")
 	}
 
-	checkStatus("1", "failed")
-	checkStatus("2", "failed")
-	checkStatus("3", "failed")
+	// Listeners closed by the mux are not failures
+	checkStatus("1", "stopped")
+	checkStatus("2", "stopped")
+	checkStatus("3", "stopped")
+
+	if m.Ready() {
+		t.Fatal("mux should not be ready after close")
+	}
+	if m.Health() != status.OK {
+		t.Fatal("unexpected health", m.Health())
+	}
+}
+
+type failingListener struct {
+	net.Listener
+}
+
+func (failingListener) Accept() (net.Conn, error) {
+	return nil, errors.New("accept failed")
+}
+
+func TestFailedListener(t *testing.T) {
+	tm := taskmanager.New(4, 10, time.Second*10)
+
+	listeners := []grpcmux.MuxListener{
+		{
+			Tag: "ok",
+			Start: func() (net.Listener, error) {
+				return net.Listen("tcp", ":10084")
+			},
+		},
+		{
+			Tag: "failing",
+			Start: func() (net.Listener, error) {
+				l, err := net.Listen("tcp", ":10085")
+				return failingListener{l}, err
+			},
+		},
+	}
+
+	m := grpcmux.New(context.Background(), listeners, tm)
+	defer m.Close()
+
+	if m.Ready() {
+		t.Fatal("mux should not be ready before start")
+	}
+
+	err := m.Start(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	for m.Health() != status.Degraded {
+		if time.Since(started) > time.Second {
+			t.Fatal("expected degraded health found", m.Health())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !m.Ready() {
+		t.Fatal("mux should be ready with a running listener")
+	}
+	if !strings.Contains(m.Status().(map[string]string)["failing"], "accept failed") {
+		t.Fatal("failure not reported in status", m.Status())
+	}
 }
@@ -2,16 +2,25 @@ package grpcsvc
 
 import (
 	"context"
+	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	logger "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/discovery"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/plexsysio/go-msuite/modules/certs"
 	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
 	"github.com/plexsysio/go-msuite/modules/diag/status"
 	grpcclient "github.com/plexsysio/go-msuite/modules/grpc/client"
 	grpcmux "github.com/plexsysio/go-msuite/modules/grpc/mux"
+	"github.com/plexsysio/go-msuite/modules/repo"
 	"github.com/plexsysio/go-msuite/utils"
 	"go.uber.org/fx"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 var log = logger.Logger("grpc_service")
@@ -22,6 +31,7 @@ type GrpcServerParams struct {
 	Opts      []grpc.ServerOption
 	Listnr    *grpcmux.Mux
 	StManager status.Manager
+	Settings  *settings.Settings
 }
 
 type grpcReporter struct {
@@ -37,11 +47,37 @@ func (s *grpcReporter) Status() interface{} {
 	return "running"
 }
 
+func (s *grpcReporter) Health() status.Health {
+	if !s.Ready() {
+		return status.Failed
+	}
+	return status.OK
+}
+
+// Ready reports false once the server has stopped
+func (s *grpcReporter) Ready() bool {
+	select {
+	case <-s.stopped:
+		return false
+	default:
+	}
+	return true
+}
+
 func New(
 	lc fx.Lifecycle,
 	params GrpcServerParams,
 ) (*grpc.Server, error) {
 	rpcSrv := grpc.NewServer(params.Opts...)
+	// Apps registering their own health service can disable the default one
+	var hu *healthUpdater
+	if !params.Settings.GRPC.DisableHealth {
+		hu = newHealthUpdater(params.StManager, params.Settings.Services)
+		healthpb.RegisterHealthServer(rpcSrv, hu.hs)
+	}
+	if params.Settings.GRPC.Reflection {
+		reflection.Register(rpcSrv)
+	}
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
 			started, stopped := make(chan struct{}), make(chan struct{})
@@ -61,10 +97,28 @@ func New(
 			case <-started:
 			}
 			params.StManager.AddReporter("GRPC Server", &grpcReporter{stopped: stopped})
+			hu.start()
 			return nil
 		},
 		OnStop: func(ctx context.Context) error {
 			log.Info("Stopping GRPC server")
+			hu.shutdown()
+			// GracefulStop waits for the pending RPCs, so it is bounded by the
+			// drain timeout after which the remaining RPCs are closed
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				rpcSrv.GracefulStop()
+			}()
+			timeout := time.NewTimer(params.Settings.Drain.TimeoutDuration())
+			defer timeout.Stop()
+			select {
+			case <-stopped:
+				return nil
+			case <-timeout.C:
+			case <-ctx.Done():
+			}
+			log.Warn("Timed out draining GRPC server, closing pending RPCs")
 			rpcSrv.Stop()
 			return nil
 		},
@@ -88,10 +142,29 @@ func OptsAggregator(params ServerOptsParams) (outOpts []grpc.ServerOption) {
 	return outOpts
 }
 
+type ClientOptsParams struct {
+	fx.In
+
+	UnaryOpts  []grpc.UnaryClientInterceptor  `group:"unary_client_opts"`
+	StreamOpts []grpc.StreamClientInterceptor `group:"stream_client_opts"`
+}
+
+// ClientOptsAggregator returns the dial options adding the client interceptors
+// to the connections of the node clients
+func ClientOptsAggregator(params ClientOptsParams) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(params.UnaryOpts...),
+		grpc.WithChainStreamInterceptor(params.StreamOpts...),
+	}
+}
+
 func Transport(c config.Config) fx.Option {
 	return fx.Options(
 		fx.Provide(NewMuxedListener),
-		utils.MaybeProvide(NewTCPListener, c.IsSet("UseTCP")),
+		utils.MaybeProvide(
+			fx.Annotate(NewTCPListener, fx.ParamTags(``, `optional:"true"`)),
+			c.IsSet("UseTCP"),
+		),
 		utils.MaybeProvide(
 			fx.Annotate(NewP2PListener, fx.ParamTags(`name:"mainHost"`)),
 			c.IsSet("UseP2P") && c.IsSet("UseP2PGRPC"),
@@ -113,6 +186,13 @@ func Middleware(c config.Config) fx.Option {
 			),
 			c.IsSet("UseTracing"),
 		),
+		utils.MaybeProvide(
+			fx.Annotate(
+				RateLimit,
+				fx.ResultTags(`group:"unary_opts"`, `group:"stream_opts"`),
+			),
+			c.IsSet("UseRateLimit"),
+		),
 		fx.Provide(
 			fx.Annotate(
 				Validator,
@@ -128,22 +208,177 @@ func Middleware(c config.Config) fx.Option {
 	)
 }
 
+// ClientMiddleware provides the interceptors of the outgoing calls
+func ClientMiddleware(c config.Config) fx.Option {
+	return fx.Options(
+		utils.MaybeProvide(
+			fx.Annotate(
+				AuthForwarder,
+				fx.ResultTags(`group:"unary_client_opts"`, `group:"stream_client_opts"`),
+			),
+			c.IsSet("UseAuth"),
+		),
+		utils.MaybeProvide(
+			fx.Annotate(
+				JaegerClientOptions,
+				fx.ResultTags(`group:"unary_client_opts"`),
+			),
+			c.IsSet("UseTracing"),
+		),
+		utils.MaybeProvide(
+			fx.Annotate(
+				ClientMetricsOpts,
+				fx.ResultTags(`group:"unary_client_opts"`, `group:"stream_client_opts"`),
+			),
+			c.IsSet("UsePrometheus"),
+		),
+		fx.Provide(
+			fx.Annotate(
+				ClientOptsAggregator,
+				fx.ResultTags(`name:"clientOpts"`),
+			),
+		),
+	)
+}
+
 func Client(c config.Config) fx.Option {
 	return fx.Options(
+		ClientMiddleware(c),
 		utils.MaybeProvide(
 			fx.Annotate(
-				grpcclient.NewP2PClientService,
-				fx.ParamTags(``, ``, `name:"localDialer"`, `name:"mainHost"`),
+				NewP2PClientService,
+				fx.ParamTags(``, ``, `name:"localDialer"`, `name:"mainHost"`, `name:"clientOpts"`),
 				fx.ResultTags(`name:"p2pClientSvc"`),
 			),
 			c.IsSet("UseP2P"),
 		),
 		utils.MaybeInvoke(grpcclient.NewP2PClientAdvertiser, c.IsSet("UseP2P") && c.IsSet("UseP2PGRPC")),
 		utils.MaybeProvide(
-			fx.Annotate(grpcclient.NewStaticClientService, fx.ResultTags(`name:"staticClientSvc"`)),
+			fx.Annotate(
+				NewStaticClientService,
+				fx.ParamTags(``, `optional:"true"`, `name:"clientOpts"`),
+				fx.ResultTags(`name:"staticClientSvc"`),
+			),
+			c.IsSet("UseStaticDiscovery"),
+		),
+		utils.MaybeInvoke(
+			fx.Annotate(WatchStaticAddresses, fx.ParamTags(``, ``, `name:"staticClientSvc"`)),
 			c.IsSet("UseStaticDiscovery"),
 		),
+		utils.MaybeInvoke(RegisterNameResolver, c.IsSet("UseP2P") || c.IsSet("UseStaticDiscovery")),
+		utils.MaybeInvoke(CloseClients, c.IsSet("UseP2P") || c.IsSet("UseStaticDiscovery")),
+	)
+}
+
+type ClientParams struct {
+	fx.In
+
+	Lc  fx.Lifecycle
+	PCs grpcclient.ClientSvc `name:"p2pClientSvc" optional:"true"`
+	SCs grpcclient.ClientSvc `name:"staticClientSvc" optional:"true"`
+}
+
+// CloseClients closes the client connections once the node stops
+func CloseClients(params ClientParams) {
+	params.Lc.Append(fx.Hook{
+		OnStop: func(_ context.Context) error {
+			for _, cs := range []grpcclient.ClientSvc{params.PCs, params.SCs} {
+				if cs != nil {
+					_ = cs.Close()
+				}
+			}
+			return nil
+		},
+	})
+}
+
+type NameResolverParams struct {
+	fx.In
+
+	Lc  fx.Lifecycle
+	Cfg settings.GRPC
+	H   host.Host            `name:"mainHost" optional:"true"`
+	PCs grpcclient.ClientSvc `name:"p2pClientSvc" optional:"true"`
+	SCs grpcclient.ClientSvc `name:"staticClientSvc" optional:"true"`
+}
+
+// RegisterNameResolver resolves the msuite:///<service> targets using the client
+// services of the node while it is running
+func RegisterNameResolver(params NameResolverParams) {
+	var id string
+	if params.H != nil {
+		id = params.H.ID().Pretty()
+	}
+	nr := grpcclient.NewNameResolver(
+		id,
+		params.PCs,
+		params.SCs,
+		params.Cfg.LoadBalancer,
+		params.Cfg.ResolverRefreshDuration(),
 	)
+	params.Lc.Append(fx.Hook{
+		OnStart: func(_ context.Context) error {
+			nr.Register()
+			return nil
+		},
+		OnStop: func(_ context.Context) error {
+			return nr.Close()
+		},
+	})
+}
+
+// NewP2PClientService returns the libp2p client service using the node client
+// interceptors. The libp2p streams are secured by the host, so the connections
+// are insecure unless the caller sets the credentials
+func NewP2PClientService(
+	c config.Config,
+	d discovery.Discovery,
+	localDialer host.Host,
+	mainHost host.Host,
+	copts []grpc.DialOption,
+) (grpcclient.ClientSvc, error) {
+	opts := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, copts...)
+	return grpcclient.NewP2PClientService(c, d, localDialer, mainHost, opts...)
+}
+
+// NewStaticClientService returns the static client service using the node client
+// interceptors. The TCP addresses are dialed using TLS if the certificates are
+// provided, the other connections are insecure unless the caller sets the
+// credentials
+func NewStaticClientService(
+	c config.Config,
+	store *certs.Store,
+	copts []grpc.DialOption,
+) grpcclient.ClientSvc {
+	opts := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, copts...)
+	if store == nil {
+		return grpcclient.NewStaticClientService(c, grpcclient.WithDialOptions(opts...))
+	}
+	return grpcclient.NewStaticClientService(
+		c,
+		grpcclient.WithDialOptions(opts...),
+		grpcclient.WithTLS(store.ClientConfig),
+	)
+}
+
+// WatchStaticAddresses updates the static client addresses on config updates
+func WatchStaticAddresses(lc fx.Lifecycle, r repo.Repo, cs grpcclient.ClientSvc) {
+	scs, ok := cs.(grpcclient.StaticClientSvc)
+	if !ok {
+		return
+	}
+	unsubscribe := repo.Subscribe(r, func(ch repo.ConfigChange) {
+		svcAddrs := make(map[string]string)
+		_ = ch.New(&svcAddrs)
+		log.Info("updating static addresses", svcAddrs)
+		scs.SetAddresses(svcAddrs)
+	}, "StaticAddresses")
+	lc.Append(fx.Hook{
+		OnStop: func(_ context.Context) error {
+			unsubscribe()
+			return nil
+		},
+	})
 }
 
 var Module = func(c config.Config) fx.Option {
@@ -153,5 +388,6 @@ var Module = func(c config.Config) fx.Option {
 		Client(c),
 		fx.Provide(OptsAggregator),
 		fx.Provide(New),
+		utils.MaybeInvoke(RegisterMethodsHTTP, c.IsSet("UseHTTP") && c.IsSet("UseReflection")),
 	)
 }
@@ -0,0 +1,108 @@
+package grpcsvc
+
+import (
+	"time"
+
+	"github.com/plexsysio/go-msuite/modules/diag/status"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthInterval is the interval at which the serving status of the health
+// service is updated from the status reporters
+var healthInterval = 5 * time.Second
+
+// healthUpdater updates the serving status of the services on the standard
+// gRPC health service. A service is serving if the reporters of the node are
+// healthy and ready. If a reporter is added with the name of the service, it is
+// used only for that service. The overall status ("") considers all reporters
+type healthUpdater struct {
+	hs   *health.Server
+	st   status.Manager
+	svcs []string
+	stop chan struct{}
+}
+
+func newHealthUpdater(st status.Manager, svcs []string) *healthUpdater {
+	return &healthUpdater{
+		hs:   health.NewServer(),
+		st:   st,
+		svcs: svcs,
+		stop: make(chan struct{}),
+	}
+}
+
+func servingStatus(h status.Health, ready bool) healthpb.HealthCheckResponse_ServingStatus {
+	if h == status.Failed || !ready {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+func (h *healthUpdater) update() {
+	overallHealth, healths := h.st.Health()
+	overallReady, readiness := h.st.Ready()
+	h.hs.SetServingStatus("", servingStatus(overallHealth, overallReady))
+
+	isSvc := make(map[string]bool, len(h.svcs))
+	for _, svc := range h.svcs {
+		isSvc[svc] = true
+	}
+	nodeHealth, nodeReady := status.OK, true
+	for k, v := range healths {
+		if !isSvc[k] && v.Worse(nodeHealth) {
+			nodeHealth = v
+		}
+	}
+	for k, v := range readiness {
+		if !isSvc[k] {
+			nodeReady = nodeReady && v
+		}
+	}
+
+	for _, svc := range h.svcs {
+		svcHealth, svcReady := nodeHealth, nodeReady
+		if v, found := healths[svc]; found && v.Worse(svcHealth) {
+			svcHealth = v
+		}
+		if v, found := readiness[svc]; found {
+			svcReady = svcReady && v
+		}
+		h.hs.SetServingStatus(svc, servingStatus(svcHealth, svcReady))
+	}
+}
+
+func (h *healthUpdater) start() {
+	if h == nil {
+		return
+	}
+	h.update()
+	go func() {
+		t := time.NewTicker(healthInterval)
+		defer t.Stop()
+
+		draining := h.st.Draining()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-draining:
+				// The node is not ready once it is draining, update
+				// immediately so that the clients move away
+				draining = nil
+				h.update()
+			case <-t.C:
+				h.update()
+			}
+		}
+	}()
+}
+
+// shutdown sets all the services as not serving and stops the updates
+func (h *healthUpdater) shutdown() {
+	if h == nil {
+		return
+	}
+	close(h.stop)
+	h.hs.Shutdown()
+}
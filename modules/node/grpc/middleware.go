@@ -2,6 +2,10 @@ package grpcsvc
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
 	grpc_validator "github.com/grpc-ecosystem/go-grpc-middleware/validator"
@@ -9,15 +13,33 @@ import (
 	gtrace "github.com/moxiaomomo/grpc-jaeger"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/plexsysio/go-msuite/modules/auth"
-	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/ratelimit"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/fx"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
+// UnaryInterceptor adds the interceptor to the gRPC server
+func UnaryInterceptor(i grpc.UnaryServerInterceptor) fx.Option {
+	return fx.Provide(fx.Annotate(
+		func() grpc.UnaryServerInterceptor { return i },
+		fx.ResultTags(`group:"unary_opts"`),
+	))
+}
+
+// StreamInterceptor adds the interceptor to the gRPC server
+func StreamInterceptor(i grpc.StreamServerInterceptor) fx.Option {
+	return fx.Provide(fx.Annotate(
+		func() grpc.StreamServerInterceptor { return i },
+		fx.ResultTags(`group:"stream_opts"`),
+	))
+}
+
 var JwtAuth = fx.Options(
 	fx.Provide(JwtAuthOptions),
 )
@@ -128,8 +150,8 @@ func Metrics() *grpc_prometheus.ServerMetrics {
 	return grpc_prometheus.NewServerMetrics()
 }
 
-func MetricsOpts(c config.Config, grpcMetrics *grpc_prometheus.ServerMetrics) (params PrometheusOpts, err error) {
-	if c.IsSet("UsePrometheusLatency") {
+func MetricsOpts(mCfg settings.Metrics, grpcMetrics *grpc_prometheus.ServerMetrics) (params PrometheusOpts, err error) {
+	if mCfg.Latency {
 		grpcMetrics.EnableHandlingTimeHistogram()
 	}
 	params.SOut = grpcMetrics.StreamServerInterceptor()
@@ -146,6 +168,132 @@ func JaegerTracerOptions(tracer opentracing.Tracer) grpc.UnaryServerInterceptor
 	return gtrace.ServerInterceptor(tracer)
 }
 
+// JaegerClientOptions injects the spans in the outgoing calls
+func JaegerClientOptions(tracer opentracing.Tracer) grpc.UnaryClientInterceptor {
+	return gtrace.ClientInterceptor(tracer)
+}
+
+// ClientMetricsOpts records the client metrics of the outgoing calls in the node
+// registry
+func ClientMetricsOpts(
+	reg *prometheus.Registry,
+	mCfg settings.Metrics,
+) (grpc.UnaryClientInterceptor, grpc.StreamClientInterceptor, error) {
+	metrics := grpc_prometheus.NewClientMetrics()
+	if mCfg.Latency {
+		metrics.EnableClientHandlingTimeHistogram()
+	}
+	if err := reg.Register(metrics); err != nil {
+		return nil, nil, err
+	}
+	return metrics.UnaryClientInterceptor(), metrics.StreamClientInterceptor(), nil
+}
+
+// serviceTokenTimeout is the lifetime of the service tokens. The tokens are
+// minted again once half of it is over
+const serviceTokenTimeout = 10 * time.Minute
+
+type serviceUser struct {
+	id   string
+	role string
+}
+
+func (u serviceUser) ID() string   { return u.id }
+func (u serviceUser) Role() string { return u.role }
+func (u serviceUser) Mtdt() map[string]interface{} {
+	return map[string]interface{}{"service": true}
+}
+
+type serviceToken struct {
+	jm   auth.JWTManager
+	user serviceUser
+
+	mtx     sync.Mutex
+	token   string
+	renewAt time.Time
+}
+
+func (s *serviceToken) get() (string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.token != "" && time.Now().Before(s.renewAt) {
+		return s.token, nil
+	}
+	token, err := s.jm.Generate(s.user, serviceTokenTimeout)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.renewAt = token, time.Now().Add(serviceTokenTimeout/2)
+	return token, nil
+}
+
+// AuthForwarder returns the client interceptors adding the authorization token
+// to the outgoing calls. The token of the incoming call is forwarded. Otherwise,
+// if the ServiceRole is configured, a token with the role is minted for the node
+// using its service names as the ID. Tokens set by the callers are used as is
+func AuthForwarder(
+	jm auth.JWTManager,
+	cfg *settings.Settings,
+) (grpc.UnaryClientInterceptor, grpc.StreamClientInterceptor, error) {
+	var st *serviceToken
+	if cfg.Auth.ServiceRole != "" {
+		if !auth.ValidRole(auth.Role(cfg.Auth.ServiceRole)) {
+			return nil, nil, fmt.Errorf("ServiceRole: invalid role %q", cfg.Auth.ServiceRole)
+		}
+		id := strings.Join(cfg.Services, ",")
+		if id == "" {
+			id = "msuite"
+		}
+		st = &serviceToken{jm: jm, user: serviceUser{id: id, role: cfg.Auth.ServiceRole}}
+	}
+	withToken := func(ctx context.Context) (context.Context, error) {
+		if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md["authorization"]) > 0 {
+			return ctx, nil
+		}
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md["authorization"]) > 0 {
+			return metadata.AppendToOutgoingContext(ctx, "authorization", md["authorization"][0]), nil
+		}
+		if st == nil {
+			return ctx, nil
+		}
+		token, err := st.get()
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "failed minting service token: %v", err)
+		}
+		return metadata.AppendToOutgoingContext(ctx, "authorization", token), nil
+	}
+	unary := func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx, err := withToken(ctx)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	stream := func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, err := withToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	return unary, stream, nil
+}
+
 func Validator() (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
 	return grpc_validator.UnaryServerInterceptor(), grpc_validator.StreamServerInterceptor()
 }
@@ -159,3 +307,47 @@ func Recovery() (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
 	}
 	return grpc_recovery.UnaryServerInterceptor(opts...), grpc_recovery.StreamServerInterceptor(opts...)
 }
+
+// RateLimit returns the interceptors limiting the RPCs. The token and the remote
+// peer are used to identify the callers
+func RateLimit(l *ratelimit.Limiter) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	allow := func(ctx context.Context, method string) error {
+		req := ratelimit.Request{Transport: "grpc", Method: method}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md["authorization"]; len(values) > 0 {
+				req.Token = values[0]
+			}
+		}
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			req.Addr = p.Addr.String()
+		}
+		res, ok := l.Allow(req)
+		if !ok {
+			return status.Errorf(codes.ResourceExhausted, "rate limit %s exceeded, retry after %s", res.Rule, res.RetryAfter)
+		}
+		return nil
+	}
+	unary := func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if err := allow(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	stream := func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if err := allow(stream.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+	return unary, stream
+}
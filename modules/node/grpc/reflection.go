@@ -0,0 +1,73 @@
+package grpcsvc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/plexsysio/go-msuite/modules/auth"
+	"go.uber.org/fx"
+	"google.golang.org/grpc"
+)
+
+// MethodsPath is the HTTP path listing the methods on the gRPC server
+const MethodsPath = "/grpc/methods"
+
+// MethodInfo describes a method registered on the gRPC server
+type MethodInfo struct {
+	Service         string
+	Method          string
+	FullMethod      string
+	ClientStreaming bool
+	ServerStreaming bool
+	// Roles allowed to call the method. This is absent if auth is not enabled
+	Roles []auth.Role `json:",omitempty"`
+}
+
+// Methods returns all the methods registered on the server sorted by name. The
+// ACL is optional and is used to add the roles allowed for each method
+func Methods(ctx context.Context, srv *grpc.Server, am auth.ACL) []MethodInfo {
+	methods := []MethodInfo{}
+	for svc, info := range srv.GetServiceInfo() {
+		for _, m := range info.Methods {
+			mInfo := MethodInfo{
+				Service:         svc,
+				Method:          m.Name,
+				FullMethod:      "/" + svc + "/" + m.Name,
+				ClientStreaming: m.IsClientStream,
+				ServerStreaming: m.IsServerStream,
+			}
+			if am != nil {
+				mInfo.Roles = am.Allowed(ctx, mInfo.FullMethod)
+			}
+			methods = append(methods, mInfo)
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].FullMethod < methods[j].FullMethod
+	})
+	return methods
+}
+
+type MethodsIn struct {
+	fx.In
+
+	Mux *http.ServeMux
+	Srv *grpc.Server
+	Am  auth.ACL `optional:"true"`
+}
+
+// RegisterMethodsHTTP registers the HTTP handler listing the gRPC methods
+func RegisterMethodsHTTP(in MethodsIn) {
+	in.Mux.HandleFunc(MethodsPath, func(w http.ResponseWriter, r *http.Request) {
+		buf, err := json.MarshalIndent(Methods(r.Context(), in.Srv, in.Am), "", "\t")
+		if err != nil {
+			http.Error(w, "Failed to get methods Err:"+err.Error(),
+				http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(buf)
+	})
+}
@@ -2,13 +2,15 @@ package grpcsvc
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 
 	"github.com/libp2p/go-libp2p-core/host"
 	gostream "github.com/libp2p/go-libp2p-gostream"
-	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/certs"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
 	"github.com/plexsysio/go-msuite/modules/diag/status"
 	grpcmux "github.com/plexsysio/go-msuite/modules/grpc/mux"
 	"github.com/plexsysio/go-msuite/modules/grpc/p2pgrpc"
@@ -27,6 +29,7 @@ type MuxIn struct {
 
 	Listeners []grpcmux.MuxListener `group:"listener"`
 	StManager status.Manager        `optional:"true"`
+	Drain     settings.Drain
 }
 
 func NewMuxedListener(
@@ -36,6 +39,7 @@ func NewMuxedListener(
 	tm *taskmanager.TaskManager,
 ) (*grpcmux.Mux, error) {
 	m := grpcmux.New(ctx, in.Listeners, tm)
+	m.SetCloseTimeout(in.Drain.TimeoutDuration())
 	in.StManager.AddReporter("RPC Listeners", m)
 
 	lc.Append(fx.Hook{
@@ -54,18 +58,24 @@ func NewMuxedListener(
 	return m, nil
 }
 
-func NewTCPListener(conf config.Config) (MuxListenerOut, error) {
-	var portVal int
-	ok := conf.Get("TCPPort", &portVal)
-	if !ok {
-		log.Error("TCPPort missing")
-		return MuxListenerOut{}, errors.New("Port absent")
+// NewTCPListener listens on the TCP port. If the certificates are provided, the
+// connections use TLS. The other listeners share the gRPC server, so TLS is done
+// by the listener instead of the server credentials
+func NewTCPListener(grpcCfg settings.GRPC, store *certs.Store) (MuxListenerOut, error) {
+	portVal := grpcCfg.TCPPort
+	tag := fmt.Sprintf("TCP Port %d", portVal)
+	if store != nil {
+		tag += " (TLS)"
 	}
 	return MuxListenerOut{
 		Listener: grpcmux.MuxListener{
-			Tag: fmt.Sprintf("TCP Port %d", portVal),
+			Tag: tag,
 			Start: func() (net.Listener, error) {
-				return net.Listen("tcp", fmt.Sprintf(":%d", portVal))
+				l, err := net.Listen("tcp", fmt.Sprintf(":%d", portVal))
+				if err != nil || store == nil {
+					return l, err
+				}
+				return tls.NewListener(l, store.ServerConfig("h2")), nil
 			},
 		},
 	}, nil
@@ -84,10 +94,9 @@ func NewP2PListener(
 	}, nil
 }
 
-func NewUDSListener(conf config.Config) (MuxListenerOut, error) {
-	var sock string
-	ok := conf.Get("UDSocket", &sock)
-	if !ok {
+func NewUDSListener(grpcCfg settings.GRPC) (MuxListenerOut, error) {
+	sock := grpcCfg.UDSocket
+	if sock == "" {
 		log.Error("Unix socket missing")
 		return MuxListenerOut{}, errors.New("socket absent")
 	}
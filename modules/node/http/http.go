@@ -8,7 +8,9 @@ import (
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	logger "github.com/ipfs/go-log/v2"
+	"github.com/plexsysio/go-msuite/modules/certs"
 	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
 	"github.com/plexsysio/go-msuite/modules/diag/status"
 	"github.com/plexsysio/go-msuite/utils"
 	"go.uber.org/fx"
@@ -24,7 +26,9 @@ var Module = func(c config.Config) fx.Option {
 		fx.Provide(NewGRPCGateway),
 		fx.Provide(Recovery),
 		fx.Provide(Logging),
+		utils.MaybeProvide(CORS, c.Exists("CORS")),
 		utils.MaybeProvide(JWT, c.IsSet("UseAuth")),
+		utils.MaybeProvide(RateLimit, c.IsSet("UseRateLimit")),
 		utils.MaybeProvide(Tracing, c.IsSet("UseTracing")),
 		utils.MaybeOption(Prometheus, c.IsSet("UsePrometheus")),
 		utils.MaybeInvoke(RegisterDebug, c.IsSet("UseDebug")),
@@ -37,6 +41,7 @@ type HTTPIn struct {
 	Mux    *nhttp.ServeMux
 	GRPC   *runtime.ServeMux
 	Mwares []Middleware `group:"httpmiddleware"`
+	Certs  *certs.Store `optional:"true"`
 }
 
 func NewHTTPServerMux() *nhttp.ServeMux {
@@ -75,15 +80,32 @@ func (h *httpReporter) Status() interface{} {
 	return fmt.Sprintf("running on port %d", h.port)
 }
 
+func (h *httpReporter) Health() status.Health {
+	if !h.Ready() {
+		return status.Failed
+	}
+	return status.OK
+}
+
+// Ready reports false once the server has stopped
+func (h *httpReporter) Ready() bool {
+	select {
+	case <-h.stopped:
+		return false
+	default:
+	}
+	return true
+}
+
 func NewHTTPServer(
 	lc fx.Lifecycle,
-	c config.Config,
+	httpCfg settings.HTTP,
+	drainCfg settings.Drain,
 	httpIn HTTPIn,
 	st status.Manager,
 ) error {
-	var httpPort int
-	ok := c.Get("HTTPPort", &httpPort)
-	if !ok {
+	httpPort := httpCfg.Port
+	if httpPort == 0 {
 		return errors.New("HTTP Port not provided")
 	}
 	if httpIn.GRPC != nil {
@@ -94,6 +116,9 @@ func NewHTTPServer(
 		rootHandler = v(rootHandler)
 	}
 	httpServer := &nhttp.Server{Addr: fmt.Sprintf(":%d", httpPort), Handler: rootHandler}
+	if httpIn.Certs != nil {
+		httpServer.TLSConfig = httpIn.Certs.ServerConfig("h2", "http/1.1")
+	}
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
 			started, stopped := make(chan struct{}), make(chan struct{})
@@ -102,7 +127,13 @@ func NewHTTPServer(
 				defer close(stopped)
 
 				log.Info("Starting http server")
-				err := httpServer.ListenAndServe()
+				var err error
+				if httpServer.TLSConfig != nil {
+					// The certificates are provided by the TLS config
+					err = httpServer.ListenAndServeTLS("", "")
+				} else {
+					err = httpServer.ListenAndServe()
+				}
 				if err != nil {
 					log.Error("http server stopped ", err)
 				}
@@ -113,10 +144,27 @@ func NewHTTPServer(
 			case <-started:
 			}
 			st.AddReporter("HTTP Server", &httpReporter{port: httpPort, stopped: stopped})
+			go func() {
+				select {
+				case <-st.Draining():
+					// Clients reconnect on the next request, possibly to
+					// some other node
+					httpServer.SetKeepAlivesEnabled(false)
+				case <-stopped:
+				}
+			}()
 			return nil
 		},
 		OnStop: func(ctx context.Context) error {
-			return httpServer.Shutdown(ctx)
+			sCtx, cancel := context.WithTimeout(ctx, drainCfg.TimeoutDuration())
+			defer cancel()
+
+			err := httpServer.Shutdown(sCtx)
+			if err != nil {
+				log.Warn("Timed out draining http server, closing connections ", err)
+				return httpServer.Close()
+			}
+			return nil
 		},
 	})
 	return nil
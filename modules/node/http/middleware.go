@@ -1,17 +1,24 @@
 package http
 
 import (
+	"context"
 	"expvar"
 	"fmt"
+	"math"
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/gorilla/handlers"
 	"github.com/opentracing-contrib/go-stdlib/nethttp"
 	"github.com/opentracing/opentracing-go"
 	"github.com/plexsysio/go-msuite/modules/auth"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/ratelimit"
+	"github.com/plexsysio/go-msuite/modules/repo"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
@@ -29,9 +36,71 @@ type MiddlewareOut struct {
 
 type Middleware func(h http.Handler) http.Handler
 
-func CORS() MiddlewareOut {
+// HTTPMiddleware adds the middleware to the HTTP server
+func HTTPMiddleware(m Middleware) fx.Option {
+	return fx.Provide(func() MiddlewareOut {
+		return MiddlewareOut{Mware: m}
+	})
+}
+
+func newCors(c settings.CORSConfig) *cors.Cors {
+	return cors.New(cors.Options{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedMethods:   c.AllowedMethods,
+		AllowedHeaders:   c.AllowedHeaders,
+		ExposedHeaders:   c.ExposedHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           c.MaxAge,
+	})
+}
+
+// CORS middleware handles CORS requests using the "CORS" key. It is only added
+// if the key is configured. The settings are updated without restart when the
+// config changes and CORS is disabled if the key is removed
+func CORS(lc fx.Lifecycle, r repo.Repo) MiddlewareOut {
+	var current atomic.Value
+
+	// atomic.Value does not allow storing nil, so the handler is wrapped
+	type corsHandler struct{ c *cors.Cors }
+	update := func(c *cors.Cors) {
+		current.Store(corsHandler{c})
+	}
+
+	var corsCfg settings.CORSConfig
+	if r.Config().Get("CORS", &corsCfg) {
+		update(newCors(corsCfg))
+	} else {
+		update(nil)
+	}
+
+	unsubscribe := repo.Subscribe(r, func(ch repo.ConfigChange) {
+		var corsCfg settings.CORSConfig
+		if !ch.New(&corsCfg) {
+			log.Info("CORS disabled")
+			update(nil)
+			return
+		}
+		log.Info("CORS config updated")
+		update(newCors(corsCfg))
+	}, "CORS")
+	lc.Append(fx.Hook{
+		OnStop: func(_ context.Context) error {
+			unsubscribe()
+			return nil
+		},
+	})
+
 	return MiddlewareOut{
-		Mware: cors.Default().Handler,
+		Mware: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c := current.Load().(corsHandler).c
+				if c == nil {
+					next.ServeHTTP(w, r)
+					return
+				}
+				c.ServeHTTP(w, r, next.ServeHTTP)
+			})
+		},
 	}
 }
 
@@ -73,6 +142,34 @@ func JWT(jm auth.JWTManager, am auth.ACL) MiddlewareOut {
 	}
 }
 
+// RateLimit returns the middleware limiting the requests. The bearer token and
+// the remote address are used to identify the callers
+func RateLimit(l *ratelimit.Limiter) MiddlewareOut {
+	return MiddlewareOut{
+		Mware: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req := ratelimit.Request{
+					Transport: "http",
+					Method:    r.URL.Path,
+					Addr:      r.RemoteAddr,
+				}
+				tokenArr := strings.Split(r.Header.Get("Authorization"), " ")
+				if len(tokenArr) == 2 {
+					req.Token = tokenArr[1]
+				}
+				res, ok := l.Allow(req)
+				if !ok {
+					retry := int(math.Ceil(res.RetryAfter.Seconds()))
+					w.Header().Set("Retry-After", strconv.Itoa(retry))
+					http.Error(w, fmt.Sprintf("rate limit %s exceeded", res.Rule), http.StatusTooManyRequests)
+					return
+				}
+				next.ServeHTTP(w, r)
+			})
+		},
+	}
+}
+
 func Tracing(tracer opentracing.Tracer) MiddlewareOut {
 	return MiddlewareOut{
 		Mware: func(next http.Handler) http.Handler {
@@ -86,9 +183,9 @@ var Prometheus = fx.Options(
 	fx.Invoke(Register),
 )
 
-func PromMware() MiddlewareOut {
+func PromMware(reg *prometheus.Registry) MiddlewareOut {
 	mdlw := middleware.New(middleware.Config{
-		Recorder: metrics.NewRecorder(metrics.Config{}),
+		Recorder: metrics.NewRecorder(metrics.Config{Registry: reg}),
 	})
 	return MiddlewareOut{
 		Mware: std.HandlerProvider("", mdlw),
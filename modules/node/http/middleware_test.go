@@ -0,0 +1,67 @@
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	mhttp "github.com/plexsysio/go-msuite/modules/node/http"
+	"github.com/plexsysio/go-msuite/modules/repo/inmem"
+	"go.uber.org/fx/fxtest"
+)
+
+func TestCORS(t *testing.T) {
+	c := jsonConf.DefaultConfig()
+	r, err := inmem.CreateOrOpen(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lc := fxtest.NewLifecycle(t)
+	h := mhttp.CORS(lc, r).Mware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	lc.RequireStart()
+	defer lc.RequireStop()
+
+	allowed := func(origin string) string {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Header().Get("Access-Control-Allow-Origin")
+	}
+
+	t.Run("no config", func(t *testing.T) {
+		if o := allowed("http://example.com"); o != "" {
+			t.Fatal("expected CORS to be disabled without config found", o)
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		nc := jsonConf.DefaultConfig()
+		nc.Set("CORS", settings.CORSConfig{AllowedOrigins: []string{"http://allowed.com"}})
+		if err := r.SetConfig(nc); err != nil {
+			t.Fatal(err)
+		}
+		if o := allowed("http://allowed.com"); o != "http://allowed.com" {
+			t.Fatal("expected configured origin to be allowed found", o)
+		}
+		if o := allowed("http://example.com"); o != "" {
+			t.Fatal("expected other origins to be disallowed found", o)
+		}
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		if err := r.SetConfig(jsonConf.DefaultConfig()); err != nil {
+			t.Fatal(err)
+		}
+		if o := allowed("http://example.com"); o != "" {
+			t.Fatal("expected CORS to be disabled after removing config found", o)
+		}
+	})
+}
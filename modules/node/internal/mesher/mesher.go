@@ -38,14 +38,23 @@ func New(svc protocols.ProtocolsSvc, h host.Host, tm *taskmanager.TaskManager) e
 	svc.Register(s)
 
 	newPeerChan := make(chan peer.ID)
+	stopped := make(chan struct{})
 
 	notifier := &network.NotifyBundle{
 		ConnectedF: func(_ network.Network, conn network.Conn) {
-			newPeerChan <- conn.RemotePeer()
+			// Notifications should not block the network as the broadcaster
+			// could be waiting on a peer which is connecting to us
+			go func() {
+				select {
+				case newPeerChan <- conn.RemotePeer():
+				case <-stopped:
+				}
+			}()
 		},
 	}
 
 	_, err := tm.GoFunc(fmt.Sprintf("mesher broadcaster worker %s", h.ID()), func(ctx context.Context) error {
+		defer close(stopped)
 		for {
 			select {
 			case <-ctx.Done():
@@ -2,20 +2,68 @@ package ipfs
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	logger "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/discovery"
 	host "github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
 	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/routing"
+	p2pdiscovery "github.com/libp2p/go-libp2p-discovery"
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns_legacy"
+	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/utils"
 )
 
 var log = logger.Logger("mdnsdiscovery")
 
 const Rendezvous string = "/msuite/node"
 
-func NewMDNSDiscovery(ctx context.Context, h host.Host) error {
+// DiscoveryConstructor creates the discovery used to advertise and find the
+// services
+type DiscoveryConstructor func(host.Host, routing.Routing) (discovery.Discovery, error)
+
+var discoveries = utils.NewRegistry("discovery")
+
+// DefaultDiscovery is the discovery used if not configured
+const DefaultDiscovery = "dht"
+
+// RegisterDiscovery adds the discovery backend
+func RegisterDiscovery(name string, ctor DiscoveryConstructor) {
+	discoveries.Register(name, ctor)
+}
+
+// Discoveries returns the names of the registered discovery backends
+func Discoveries() []string {
+	return discoveries.Names()
+}
+
+func init() {
+	RegisterDiscovery(DefaultDiscovery, func(_ host.Host, r routing.Routing) (discovery.Discovery, error) {
+		return p2pdiscovery.NewRoutingDiscovery(r), nil
+	})
+}
+
+// ValidateDiscovery checks that the configured discovery backend is registered
+func ValidateDiscovery(c config.Config) error {
+	var name string
+	if !c.Get("Discovery", &name) {
+		return nil
+	}
+	if _, err := discoveries.Get(name); err != nil {
+		return fmt.Errorf("Discovery: %w", err)
+	}
+	return nil
+}
+
+// NewMDNSDiscovery finds the peers on the local network. It is not used if the
+// transport is supplied, as the hosts are not on the local network
+func NewMDNSDiscovery(ctx context.Context, h host.Host, tr Transport) error {
+	if tr != nil {
+		return nil
+	}
 	ser, err := mdns_legacy.NewMdnsService(ctx, h, time.Minute*5, Rendezvous)
 	if err != nil {
 		log.Errorf("Failed registering MDNS service Err:%s", err.Error())
@@ -5,6 +5,8 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"sync"
 	"time"
 
 	ipfslite "github.com/hsanjuan/ipfs-lite"
@@ -17,28 +19,25 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/libp2p/go-libp2p-core/routing"
-	p2pdiscovery "github.com/libp2p/go-libp2p-discovery"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	libp2ptls "github.com/libp2p/go-libp2p-tls"
 	connmgr "github.com/libp2p/go-libp2p/p2p/net/connmgr"
 	multiaddr "github.com/multiformats/go-multiaddr"
-	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
 	"github.com/plexsysio/go-msuite/modules/diag/status"
+	"github.com/plexsysio/go-msuite/modules/repo"
 	"github.com/plexsysio/taskmanager"
 	"go.uber.org/fx"
 )
 
-func Identity(conf config.Config) (crypto.PrivKey, error) {
-	id := map[string]interface{}{}
-	ok := conf.Get("Identity", &id)
-	if !ok {
+func Identity(p2pCfg settings.P2P) (crypto.PrivKey, error) {
+	if p2pCfg.Identity == nil {
 		return nil, errors.New("Identity info missing")
 	}
-	privKeyStr, ok := id["PrivKey"]
-	if !ok {
+	if p2pCfg.Identity.PrivKey == "" {
 		return nil, errors.New("Private key missing")
 	}
-	pkBytes, err := base64.StdEncoding.DecodeString(privKeyStr.(string))
+	pkBytes, err := base64.StdEncoding.DecodeString(p2pCfg.Identity.PrivKey)
 	if err != nil {
 		return nil, err
 	}
@@ -60,18 +59,41 @@ var Libp2pOptionsExtra = []libp2p.Option{
 	libp2p.Security(libp2ptls.ID, libp2ptls.New),
 }
 
+// Transport creates the libp2p hosts of the node. If it is not supplied, the
+// main host listens on the TCP swarm port and the peers on the local network are
+// found using mDNS. It can be supplied to run the node on a different network,
+// like the mock network used by msuitetest
+type Transport interface {
+	// NewHost creates the main host along with the routing used for discovery
+	NewHost(context.Context, crypto.PrivKey, settings.P2P) (host.Host, routing.Routing, error)
+	// NewDialer creates the host used by the clients to dial the services
+	NewDialer() (host.Host, error)
+}
+
 func Libp2p(
 	ctx context.Context,
 	lc fx.Lifecycle,
-	conf config.Config,
+	p2pCfg settings.P2P,
 	priv crypto.PrivKey,
+	tr Transport,
 ) (host.Host, routing.Routing, error) {
-	var swPort int
-	ok := conf.Get("SwarmPort", &swPort)
-	if !ok {
-		return nil, nil, errors.New("Swarm Port missing")
+	if tr != nil {
+		h, r, err := tr.NewHost(ctx, priv, p2pCfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		lc.Append(fx.Hook{
+			OnStop: func(c context.Context) error {
+				h.Close()
+				if closer, ok := r.(io.Closer); ok {
+					closer.Close()
+				}
+				return nil
+			},
+		})
+		return h, r, nil
 	}
-	tcpAddr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", swPort))
+	tcpAddr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", p2pCfg.SwarmPort))
 	if err != nil {
 		return nil, nil, errors.New("Invalid swarm port Err:" + err.Error())
 	}
@@ -99,11 +121,20 @@ func Libp2p(
 
 func LocalDialer(
 	lc fx.Lifecycle,
+	tr Transport,
 ) (host.Host, error) {
-	h, err := libp2p.New(
-		libp2p.DefaultTransports,
-		libp2p.NoListenAddrs,
+	var (
+		h   host.Host
+		err error
 	)
+	if tr != nil {
+		h, err = tr.NewDialer()
+	} else {
+		h, err = libp2p.New(
+			libp2p.DefaultTransports,
+			libp2p.NoListenAddrs,
+		)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -137,8 +168,16 @@ func Pubsub(ctx context.Context, h host.Host) (*pubsub.PubSub, error) {
 	return pubsub.NewGossipSub(ctx, h, pubsub.WithFloodPublish(true))
 }
 
-func NewSvcDiscovery(r routing.Routing) discovery.Discovery {
-	return p2pdiscovery.NewRoutingDiscovery(r)
+func NewSvcDiscovery(p2pCfg settings.P2P, h host.Host, r routing.Routing) (discovery.Discovery, error) {
+	name := p2pCfg.Discovery
+	if name == "" {
+		name = DefaultDiscovery
+	}
+	ctor, err := discoveries.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return ctor.(DiscoveryConstructor)(h, r)
 }
 
 func NewP2PReporter(h host.Host, st status.Manager) {
@@ -171,51 +210,92 @@ func parseBootstrapPeers(addrs []string) ([]peer.AddrInfo, error) {
 	return peer.AddrInfosFromP2pAddrs(maddrs...)
 }
 
+type bootstrapPeers struct {
+	mtx   sync.Mutex
+	peers []peer.AddrInfo
+}
+
+func (b *bootstrapPeers) update(addrs []string) error {
+	peers, err := parseBootstrapPeers(addrs)
+	if err != nil {
+		return err
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.peers = peers
+	return nil
+}
+
+func (b *bootstrapPeers) get() []peer.AddrInfo {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.peers
+}
+
 func Bootstrapper(
 	lc fx.Lifecycle,
-	cfg config.Config,
+	r repo.Repo,
 	tm *taskmanager.TaskManager,
 	h host.Host,
 ) error {
+	bp := &bootstrapPeers{}
+
 	var addrs []string
-	if cfg.Get("BootstrapAddresses", &addrs) {
-		peers, err := parseBootstrapPeers(addrs)
-		if err != nil {
+	if r.Config().Get("BootstrapAddresses", &addrs) {
+		if err := bp.update(addrs); err != nil {
 			return err
 		}
+	}
 
-		lc.Append(fx.Hook{
-			OnStart: func(ctx context.Context) error {
-				sched, err := tm.GoFunc("Bootstrapper", func(c context.Context) error {
-					t := time.NewTicker(15 * time.Second)
-					for {
-						select {
-						case <-c.Done():
-							return nil
-						case <-t.C:
-							for _, p := range peers {
-								if h.Network().Connectedness(p.ID) != network.Connected {
-									h.Peerstore().AddAddrs(p.ID, p.Addrs, peerstore.PermanentAddrTTL)
-									if err := h.Connect(ctx, p); err != nil {
-										log.Warn("could not connect to bootstrap address", p)
-									}
+	// Bootstrap addresses can be updated without restart
+	unsubscribe := repo.Subscribe(r, func(ch repo.ConfigChange) {
+		var addrs []string
+		_ = ch.New(&addrs)
+		if err := bp.update(addrs); err != nil {
+			log.Warn("invalid bootstrap addresses in config", err)
+			return
+		}
+		log.Info("updated bootstrap addresses", addrs)
+	}, "BootstrapAddresses")
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			sched, err := tm.GoFunc("Bootstrapper", func(c context.Context) error {
+				t := time.NewTicker(15 * time.Second)
+				defer t.Stop()
+				for {
+					select {
+					case <-c.Done():
+						return nil
+					case <-t.C:
+						for _, p := range bp.get() {
+							if h.Network().Connectedness(p.ID) != network.Connected {
+								h.Peerstore().AddAddrs(p.ID, p.Addrs, peerstore.PermanentAddrTTL)
+								if err := h.Connect(c, p); err != nil {
+									log.Warn("could not connect to bootstrap address", p)
 								}
 							}
 						}
 					}
-				})
-				if err != nil {
-					return err
-				}
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				case <-sched:
-					return nil
 				}
-			},
-		})
-	}
+			})
+			if err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-sched:
+				return nil
+			}
+		},
+		OnStop: func(_ context.Context) error {
+			unsubscribe()
+			return nil
+		},
+	})
 
 	return nil
 }
@@ -6,11 +6,19 @@ import (
 
 var P2PModule = fx.Options(
 	fx.Provide(Identity),
-	fx.Provide(fx.Annotate(Libp2p, fx.ResultTags(`name:"mainHost"`, ``, ``))),
-	fx.Provide(fx.Annotate(LocalDialer, fx.ResultTags(`name:"localDialer"`))),
+	fx.Provide(fx.Annotate(
+		Libp2p,
+		fx.ParamTags(``, ``, ``, ``, `optional:"true"`),
+		fx.ResultTags(`name:"mainHost"`, ``, ``),
+	)),
+	fx.Provide(fx.Annotate(
+		LocalDialer,
+		fx.ParamTags(``, `optional:"true"`),
+		fx.ResultTags(`name:"localDialer"`),
+	)),
 	fx.Provide(fx.Annotate(Pubsub, fx.ParamTags(``, `name:"mainHost"`))),
-	fx.Provide(NewSvcDiscovery),
-	fx.Invoke(fx.Annotate(NewMDNSDiscovery, fx.ParamTags(``, `name:"mainHost"`))),
+	fx.Provide(fx.Annotate(NewSvcDiscovery, fx.ParamTags(``, `name:"mainHost"`, ``))),
+	fx.Invoke(fx.Annotate(NewMDNSDiscovery, fx.ParamTags(``, `name:"mainHost"`, `optional:"true"`))),
 	fx.Invoke(fx.Annotate(NewP2PReporter, fx.ParamTags(`name:"mainHost"`, ``))),
 	fx.Invoke(fx.Annotate(Bootstrapper, fx.ParamTags(``, ``, ``, `name:"mainHost"`))),
 )
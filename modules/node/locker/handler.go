@@ -3,12 +3,16 @@ package locker
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	logger "github.com/ipfs/go-log/v2"
 	"github.com/plexsysio/dLocker"
 	inmem "github.com/plexsysio/dLocker/handlers/memlock"
-	rd "github.com/plexsysio/dLocker/handlers/redis"
-	zk "github.com/plexsysio/dLocker/handlers/zookeeper"
 	"github.com/plexsysio/go-msuite/modules/config"
-	logger "github.com/ipfs/go-log/v2"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/utils"
 	"go.uber.org/fx"
 )
 
@@ -18,51 +22,92 @@ var Module = fx.Options(
 	fx.Provide(NewLocker),
 )
 
+// Backend is a locker implementation
+type Backend struct {
+	// Check validates the config used by the backend. It is optional and is
+	// used to report problems before the node is created
+	Check func(settings.Locker) error
+	New   func(settings.Locker) (dLocker.DLocker, error)
+}
+
+// LeaseLocker is implemented by the lockers which can report when a lock held
+// is lost, e.g. once the lease of the lock cannot be extended during a network
+// partition. It is optional, as dLocker.DLocker does not report it
+type LeaseLocker interface {
+	// TryLockLease is the same as TryLock and also returns a channel which is
+	// closed once the lock is lost or released
+	TryLockLease(context.Context, string, time.Duration) (func(), <-chan struct{}, error)
+}
+
+var backends = utils.NewRegistry("locker")
+
+// Register adds the locker backend. Backends other than inmem are in their own
+// packages and register themselves when imported, so apps using redis or
+// zookeeper need to import modules/node/locker/redis or .../zookeeper
+func Register(name string, b Backend) {
+	backends.Register(name, b)
+}
+
+// Backends returns the names of the registered backends
+func Backends() []string {
+	return backends.Names()
+}
+
+func init() {
+	Register("inmem", Backend{
+		New: func(settings.Locker) (dLocker.DLocker, error) {
+			return inmem.NewLocker(), nil
+		},
+	})
+	// The other backends are linked in only if their packages are imported by
+	// the app, either directly or using plugins/all
+	backends.Known("redis", "github.com/plexsysio/go-msuite/modules/node/locker/redis")
+	backends.Known("zookeeper", "github.com/plexsysio/go-msuite/modules/node/locker/zookeeper")
+}
+
+// Validate checks that the configured locker is registered along with the config
+// required by it
+func Validate(c config.Config) error {
+	if !c.Exists("Locker") {
+		return nil
+	}
+	s, err := settings.FromConfig(c)
+	if err != nil {
+		return err
+	}
+	b, err := backends.Get(s.Locker.Type)
+	if err != nil {
+		return fmt.Errorf("Locker: %w", err)
+	}
+	check := b.(Backend).Check
+	if check == nil {
+		return nil
+	}
+	return multierror.Prefix(check(s.Locker), "Locker:")
+}
+
 func NewLocker(
 	lc fx.Lifecycle,
-	c config.Config,
+	lkCfg settings.Locker,
 ) (dLocker.DLocker, error) {
-	var lk string
-	ok := c.Get("Locker", &lk)
-	if !ok {
+	lk := lkCfg.Type
+	if lk == "" {
 		return nil, errors.New("Locker not configured")
 	}
-	var lkr dLocker.DLocker
-	var retErr error
-	switch lk {
-	case "inmem":
-		lkr, retErr = inmem.NewLocker(), nil
-	case "zookeeper":
-		var host string
-		var port int
-		if ok := c.Get("ZookeeperHost", &host); !ok {
-			return nil, errors.New("Zookeeper host absent")
-		}
-		if ok := c.Get("ZookeeperPort", &port); !ok {
-			return nil, errors.New("Zookeeper port absent")
-		}
-		lkr, retErr = zk.NewZkLocker(host, port)
-	case "redis":
-		var host, netw string
-		if ok := c.Get("RedisHost", &host); !ok {
-			return nil, errors.New("Redis host absent")
-		}
-		if ok := c.Get("RedisNetwork", &netw); !ok {
-			return nil, errors.New("Redis host absent")
-		}
-		// TODO: Add config for username/password authentication
-		lkr, retErr = rd.NewRedisLocker(netw, host), nil
-	default:
-		return nil, errors.New("Invalid locker handler")
+	b, err := backends.Get(lk)
+	if err != nil {
+		return nil, err
 	}
-	if retErr == nil {
-		log.Info("Configured DLocker handler %s", lk)
-		lc.Append(fx.Hook{
-			OnStop: func(ctx context.Context) error {
-				defer log.Info("Closed DLocker")
-				return lkr.Close()
-			},
-		})
+	lkr, err := b.(Backend).New(lkCfg)
+	if err != nil {
+		return nil, err
 	}
-	return lkr, retErr
+	log.Infof("Configured DLocker handler %s", lk)
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			defer log.Info("Closed DLocker")
+			return lkr.Close()
+		},
+	})
+	return lkr, nil
 }
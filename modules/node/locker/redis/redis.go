@@ -0,0 +1,133 @@
+// Package redis registers the redis locker backend. Import this package to use
+// "redis" as the Locker
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	goredislib "github.com/go-redis/redis/v8"
+	"github.com/go-redsync/redsync/v4"
+	"github.com/go-redsync/redsync/v4/redis/goredis/v8"
+	"github.com/hashicorp/go-multierror"
+	logger "github.com/ipfs/go-log/v2"
+	"github.com/plexsysio/dLocker"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/node/locker"
+)
+
+var log = logger.Logger("locker/redis")
+
+const (
+	// expiry is the lease of the locks in redis. The locks are extended in the
+	// background till they are released
+	expiry         = 8 * time.Second
+	extendInterval = expiry / 3
+)
+
+func init() {
+	locker.Register("redis", locker.Backend{
+		Check: check,
+		New: func(c settings.Locker) (dLocker.DLocker, error) {
+			if err := check(c); err != nil {
+				return nil, err
+			}
+			// TODO: Add config for username/password authentication
+			return newLocker(c.RedisNetwork, c.RedisHost), nil
+		},
+	})
+}
+
+func check(c settings.Locker) error {
+	var errs *multierror.Error
+	if c.RedisHost == "" {
+		errs = multierror.Append(errs, errors.New("RedisHost required for redis"))
+	}
+	if c.RedisNetwork == "" {
+		errs = multierror.Append(errs, errors.New("RedisNetwork required for redis"))
+	}
+	return errs.ErrorOrNil()
+}
+
+// redisLocker holds the locks using redsync. Unlike the dLocker redis handler, it
+// reports the locks which could not be extended, so that the users can stop
+// acting as the owner of the lock
+type redisLocker struct {
+	client *goredislib.Client
+	rs     *redsync.Redsync
+}
+
+func newLocker(netw, addr string) *redisLocker {
+	client := goredislib.NewClient(&goredislib.Options{
+		Network: netw,
+		Addr:    addr,
+	})
+	return &redisLocker{
+		client: client,
+		rs:     redsync.New(goredis.NewPool(client)),
+	}
+}
+
+func (l *redisLocker) Close() error {
+	return l.client.Close()
+}
+
+func (l *redisLocker) TryLock(ctx context.Context, key string, timeout time.Duration) (func(), error) {
+	unlock, _, err := l.TryLockLease(ctx, key, timeout)
+	return unlock, err
+}
+
+// TryLockLease waits till the timeout for the lock. The lock is extended till it
+// is released or the context is done. The channel returned is closed once the
+// lock is no longer extended
+func (l *redisLocker) TryLockLease(
+	ctx context.Context,
+	key string,
+	timeout time.Duration,
+) (func(), <-chan struct{}, error) {
+	mtx := l.rs.NewMutex(key, redsync.WithExpiry(expiry))
+
+	lockCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := mtx.LockContext(lockCtx); err != nil {
+		return nil, nil, err
+	}
+	log.Debugf("lock acquired %s", key)
+
+	stop, lost := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(lost)
+
+		t := time.NewTicker(extendInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				log.Warnf("lock %s no longer extended: %v", key, ctx.Err())
+				return
+			case <-t.C:
+				extended, err := mtx.ExtendContext(ctx)
+				if err != nil || !extended {
+					log.Errorf("lock %s lost, failed extending: %v", key, err)
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-lost
+			// The context of the lock could be done already
+			if _, err := mtx.UnlockContext(context.Background()); err != nil {
+				log.Debugf("failed unlocking %s: %v", key, err)
+			}
+		})
+	}, lost, nil
+}
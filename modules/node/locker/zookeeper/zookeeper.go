@@ -0,0 +1,36 @@
+// Package zookeeper registers the zookeeper locker backend. Import this package
+// to use "zookeeper" as the Locker
+package zookeeper
+
+import (
+	"errors"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/plexsysio/dLocker"
+	zk "github.com/plexsysio/dLocker/handlers/zookeeper"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/node/locker"
+)
+
+func init() {
+	locker.Register("zookeeper", locker.Backend{
+		Check: check,
+		New: func(c settings.Locker) (dLocker.DLocker, error) {
+			if err := check(c); err != nil {
+				return nil, err
+			}
+			return zk.NewZkLocker(c.ZookeeperHost, c.ZookeeperPort)
+		},
+	})
+}
+
+func check(c settings.Locker) error {
+	var errs *multierror.Error
+	if c.ZookeeperHost == "" {
+		errs = multierror.Append(errs, errors.New("ZookeeperHost required for zookeeper"))
+	}
+	if c.ZookeeperPort == 0 {
+		errs = multierror.Append(errs, errors.New("ZookeeperPort required for zookeeper"))
+	}
+	return errs.ErrorOrNil()
+}
@@ -3,6 +3,7 @@ package node
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -19,9 +20,13 @@ import (
 	store "github.com/plexsysio/gkvstore"
 	"github.com/plexsysio/go-msuite/core"
 	"github.com/plexsysio/go-msuite/modules/auth"
+	"github.com/plexsysio/go-msuite/modules/certs"
 	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/diag/admin"
 	"github.com/plexsysio/go-msuite/modules/diag/metrics"
 	"github.com/plexsysio/go-msuite/modules/diag/status"
+	"github.com/plexsysio/go-msuite/modules/election"
 	"github.com/plexsysio/go-msuite/modules/events"
 	grpcclient "github.com/plexsysio/go-msuite/modules/grpc/client"
 	grpcsvc "github.com/plexsysio/go-msuite/modules/node/grpc"
@@ -29,10 +34,14 @@ import (
 	"github.com/plexsysio/go-msuite/modules/node/internal/mesher"
 	"github.com/plexsysio/go-msuite/modules/node/ipfs"
 	"github.com/plexsysio/go-msuite/modules/node/locker"
+	"github.com/plexsysio/go-msuite/modules/node/validate"
 	"github.com/plexsysio/go-msuite/modules/protocols"
+	"github.com/plexsysio/go-msuite/modules/queue"
+	"github.com/plexsysio/go-msuite/modules/ratelimit"
 	"github.com/plexsysio/go-msuite/modules/repo"
 	"github.com/plexsysio/go-msuite/modules/repo/fsrepo"
 	"github.com/plexsysio/go-msuite/modules/repo/inmem"
+	"github.com/plexsysio/go-msuite/modules/scheduler"
 	"github.com/plexsysio/go-msuite/modules/sharedStorage"
 	"github.com/plexsysio/go-msuite/utils"
 	"github.com/plexsysio/taskmanager"
@@ -49,11 +58,21 @@ func (*FxLog) Printf(msg string, args ...interface{}) {
 	log.Infof(msg, args...)
 }
 
-func New(bCfg config.Config) (core.Service, error) {
+// New creates the node using the config. The fx options are added to the node
+// so that apps can provide their own constructors and invoke functions using the
+// dependencies of the node. The values provided in the "unary_opts" and
+// "stream_opts" groups are added as gRPC server interceptors and the ones in the
+// "httpmiddleware" group are added as HTTP middlewares
+func New(bCfg config.Config, opts ...fx.Option) (core.Service, error) {
 	var (
 		r   repo.Repo
 		err error
 	)
+	// Validate the config passed before creating the repo so that invalid config
+	// is not saved
+	if err := validate.Config(bCfg); err != nil {
+		return nil, err
+	}
 	if bCfg.Get("RootPath", new(string)) {
 		r, err = fsrepo.CreateOrOpen(bCfg)
 		if err != nil {
@@ -72,6 +91,12 @@ func New(bCfg config.Config) (core.Service, error) {
 	// root path
 	bCfg = r.Config()
 
+	// The repo could have been initialized earlier with a different config
+	if err := validate.Config(bCfg); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
 	svc := &impl{}
 	dp := deps{}
 
@@ -90,10 +115,22 @@ func New(bCfg config.Config) (core.Service, error) {
 			})
 			return r, r.Config(), r.Datastore()
 		}),
+		fx.Provide(settings.Provide),
 		fx.Provide(NewTaskManager),
 		fx.Provide(status.New),
+		fx.Provide(fx.Annotate(scheduler.New, fx.ParamTags(``, ``, ``, `optional:"true"`))),
+		fx.Provide(fx.Annotate(
+			queue.New,
+			fx.ParamTags(``, ``, ``, ``, ``, ``, `optional:"true"`, `optional:"true"`),
+		)),
 		utils.MaybeProvide(metrics.New, bCfg.IsSet("UsePrometheus")),
 		utils.MaybeProvide(metrics.NewTracer, bCfg.IsSet("UseTracing")),
+		utils.MaybeProvide(certs.New, bCfg.IsSet("UseTLS")),
+		utils.MaybeProvide(NewIdentityCerts, bCfg.IsSet("UseTLSIdentity")),
+		utils.MaybeProvide(
+			fx.Annotate(ratelimit.New, fx.ParamTags(``, ``, ``, `optional:"true"`, `optional:"true"`)),
+			bCfg.IsSet("UseRateLimit"),
+		),
 		utils.MaybeOption(locker.Module, bCfg.IsSet("UseLocker")),
 		utils.MaybeOption(auth.Module, bCfg.IsSet("UseAuth")),
 		utils.MaybeOption(ipfs.P2PModule, bCfg.IsSet("UseP2P")),
@@ -113,7 +150,12 @@ func New(bCfg config.Config) (core.Service, error) {
 			fx.Annotate(sharedStorage.NewSharedStoreProvider, fx.ParamTags(``, ``, `name:"mainHost"`, ``)),
 			bCfg.IsSet("UseP2P"),
 		),
+		utils.MaybeProvide(
+			fx.Annotate(election.New, fx.ParamTags(``, ``, ``, ``, ``, ``, `optional:"true"`)),
+			bCfg.IsSet("UseLocker"),
+		),
 		utils.MaybeInvoke(status.RegisterHTTP, bCfg.IsSet("UseHTTP")),
+		utils.MaybeInvoke(admin.RegisterHTTP, bCfg.IsSet("UseHTTP") && bCfg.IsSet("UseAdmin")),
 		fx.Invoke(func(lc fx.Lifecycle, cancel context.CancelFunc) {
 			lc.Append(fx.Hook{
 				OnStop: func(c context.Context) error {
@@ -122,41 +164,138 @@ func New(bCfg config.Config) (core.Service, error) {
 				},
 			})
 		}),
+		fx.Invoke(LogLevels),
 		fx.Invoke(func(c config.Config, tm *taskmanager.TaskManager, st status.Manager) {
 			st.AddReporter("Repository", r)
 			st.AddReporter("TaskManager", &tmReporter{tm})
 			st.AddReporter("Services", &svcsReporter{c})
 		}),
+		fx.Options(opts...),
 		fx.Populate(&dp),
+		// Drain is invoked last so that it is the first to run on stop
+		fx.Invoke(Drain),
+		fx.StopTimeout(stopTimeout(bCfg)),
 	)
+	if err := app.Err(); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
 
 	svc.App = app
 	svc.dp = dp
 	return svc, nil
 }
 
-func NewTaskManager(lc fx.Lifecycle, cfg config.Config) (*taskmanager.TaskManager, error) {
-	tmCfg := map[string]int{}
-	found := cfg.Get("TMWorkers", &tmCfg)
-	if !found {
-		tmCfg["Min"] = 0
-		tmCfg["Max"] = 20
+// NewIdentityCerts derives the TLS certificate from the libp2p identity of the
+// node
+func NewIdentityCerts(p2pCfg settings.P2P) (*certs.Store, error) {
+	priv, err := ipfs.Identity(p2pCfg)
+	if err != nil {
+		return nil, err
 	}
-	if tmCfg["Max"] <= 0 {
+	return certs.NewIdentity(priv)
+}
+
+func NewTaskManager(
+	lc fx.Lifecycle,
+	tmCfg settings.TaskManager,
+	drainCfg settings.Drain,
+) (*taskmanager.TaskManager, error) {
+	if tmCfg == (settings.TaskManager{}) {
+		tmCfg.Max = 20
+	}
+	if tmCfg.Max <= 0 {
 		return nil, errors.New("invalid config for taskmanager workers")
 	}
-	tm := taskmanager.New(tmCfg["Min"], tmCfg["Max"], time.Second*15)
+	tm := taskmanager.New(tmCfg.Min, tmCfg.Max, time.Second*15)
 	lc.Append(fx.Hook{
 		OnStop: func(c context.Context) error {
 			log.Debugf("stopping taskmanager")
-			defer log.Debugf("stopped taskmanager")
-			tm.Stop()
+			// Tasks can watch the status manager to finish their work once the
+			// node starts draining. Stop cancels the remaining tasks and waits
+			// for them until the drain timeout
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				tm.Stop()
+			}()
+			select {
+			case <-stopped:
+				log.Debugf("stopped taskmanager")
+			case <-time.After(drainCfg.TimeoutDuration()):
+				log.Warn("timed out waiting for tasks to stop")
+			case <-c.Done():
+				log.Warn("timed out waiting for tasks to stop")
+			}
 			return nil
 		},
 	})
 	return tm, nil
 }
 
+// Drain marks the node as not ready at the start of the shutdown. This fails the
+// readiness checks, sets the gRPC health to not serving and stops advertising the
+// services. The servers are stopped after the drain delay, so that the clients
+// and load balancers have time to move away
+func Drain(lc fx.Lifecycle, st status.Manager, drainCfg settings.Drain) {
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			log.Info("draining node")
+			st.Drain()
+			select {
+			case <-time.After(drainCfg.DelayDuration()):
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	})
+}
+
+// stopTimeout allows the drain delay along with the drain timeout for each of
+// the gRPC server, HTTP server, job queues and taskmanager
+func stopTimeout(c config.Config) time.Duration {
+	s, err := settings.FromConfig(c)
+	if err != nil {
+		return fx.DefaultTimeout
+	}
+	return fx.DefaultTimeout + s.Drain.DelayDuration() + 4*s.Drain.TimeoutDuration()
+}
+
+// LogLevels applies the log levels configured for the subsystems. These are
+// updated if the config changes
+func LogLevels(lc fx.Lifecycle, r repo.Repo) error {
+	setLevels := func(levels map[string]string) error {
+		for k, v := range levels {
+			if err := logger.SetLogLevel(k, v); err != nil {
+				return fmt.Errorf("failed setting log level for %s: %w", k, err)
+			}
+		}
+		return nil
+	}
+
+	levels := map[string]string{}
+	if r.Config().Get("LogLevels", &levels) {
+		if err := setLevels(levels); err != nil {
+			return err
+		}
+	}
+
+	unsubscribe := repo.Subscribe(r, func(ch repo.ConfigChange) {
+		levels := map[string]string{}
+		_ = ch.New(&levels)
+		if err := setLevels(levels); err != nil {
+			log.Warn("invalid log levels in config", err)
+		}
+	}, "LogLevels")
+	lc.Append(fx.Hook{
+		OnStop: func(_ context.Context) error {
+			unsubscribe()
+			return nil
+		},
+	})
+	return nil
+}
+
 type tmReporter struct {
 	tm *taskmanager.TaskManager
 }
@@ -184,7 +323,10 @@ type deps struct {
 	R      repo.Repo
 	Am     auth.ACL                 `optional:"true"`
 	Tm     *taskmanager.TaskManager `optional:"true"`
+	Sch    scheduler.Scheduler      `optional:"true"`
+	Qm     *queue.Manager           `optional:"true"`
 	Lk     dLocker.DLocker          `optional:"true"`
+	El     *election.Manager        `optional:"true"`
 	Rsrv   *grpc.Server             `optional:"true"`
 	Mx     *http.ServeMux           `optional:"true"`
 	Gmx    *runtime.ServeMux        `optional:"true"`
@@ -216,6 +358,17 @@ func (s *impl) TM() *taskmanager.TaskManager {
 	return s.dp.Tm
 }
 
+func (s *impl) Scheduler() scheduler.Scheduler {
+	return s.dp.Sch
+}
+
+func (s *impl) Queue(name string, opts ...queue.Option) (queue.Queue, error) {
+	if s.dp.Qm == nil {
+		return nil, errors.New("Queues not configured")
+	}
+	return s.dp.Qm.Queue(name, opts...)
+}
+
 func (s *impl) P2P() (core.P2P, error) {
 	if s.dp.H == nil || s.dp.Dht == nil || s.dp.Disc == nil || s.dp.Ps == nil {
 		return nil, errors.New("P2P not configured")
@@ -274,12 +427,12 @@ func (s *impl) Server() *grpc.Server {
 	return s.dp.Rsrv
 }
 
-func (s *impl) Client(ctx context.Context, name string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+func (s *impl) Client(ctx context.Context, name string, opts ...grpc.DialOption) (*grpcclient.Conn, error) {
 	if s.dp.PCs == nil && s.dp.SCs == nil {
 		return nil, errors.New("Service discovery not configured")
 	}
 	var (
-		conn *grpc.ClientConn
+		conn *grpcclient.Conn
 		err  error
 	)
 	if s.dp.SCs != nil {
@@ -319,6 +472,13 @@ func (s *impl) Locker() (dLocker.DLocker, error) {
 	return s.dp.Lk, nil
 }
 
+func (s *impl) Election(name string) (election.Election, error) {
+	if s.dp.El == nil {
+		return nil, errors.New("Election not configured")
+	}
+	return s.dp.El.Election(name)
+}
+
 func (s *impl) Protocols() (protocols.ProtocolsSvc, error) {
 	if s.dp.Pr == nil {
 		return nil, errors.New("Protocols svc not configured")
@@ -0,0 +1,27 @@
+// Package validate checks the config used to create the node. Apart from the
+// schema, it checks that the implementations configured are registered.
+package validate
+
+import (
+	"github.com/plexsysio/go-msuite/modules/auth"
+	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/schema"
+	"github.com/plexsysio/go-msuite/modules/diag/metrics"
+	"github.com/plexsysio/go-msuite/modules/node/ipfs"
+	"github.com/plexsysio/go-msuite/modules/node/locker"
+	"github.com/plexsysio/go-msuite/modules/repo/fsrepo"
+)
+
+// Rules are the checks done apart from the schema
+var Rules = []schema.Rule{
+	auth.ValidateACL,
+	locker.Validate,
+	fsrepo.ValidateMounts,
+	metrics.ValidateTracer,
+	ipfs.ValidateDiscovery,
+}
+
+// Config validates the config using the schema and the Rules
+func Config(c config.Config) error {
+	return schema.Validate(c, Rules...)
+}
@@ -0,0 +1,94 @@
+package validate_test
+
+import (
+	"strings"
+	"testing"
+
+	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	_ "github.com/plexsysio/go-msuite/modules/node/locker/zookeeper"
+	"github.com/plexsysio/go-msuite/modules/node/validate"
+)
+
+func TestConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		vals   map[string]interface{}
+		errors []string
+	}{
+		{
+			name: "valid",
+			vals: map[string]interface{}{
+				"UseLocker": true,
+				"Locker":    "inmem",
+				"Mounts": map[string]interface{}{
+					"level": map[string]interface{}{"path": "kv", "prefix": "/"},
+				},
+				"Discovery": "dht",
+			},
+		},
+		{
+			name: "unknown implementations",
+			vals: map[string]interface{}{
+				"UseLocker": true,
+				"Locker":    "etcd",
+				"Mounts": map[string]interface{}{
+					"badger": map[string]interface{}{"prefix": "/"},
+				},
+				"UseTracing": true,
+				"Tracer":     "zipkin",
+				"Discovery":  "consul",
+				"ACL":        map[string]string{"rsc": "superuser"},
+			},
+			errors: []string{
+				`Locker: unknown locker "etcd", available [inmem zookeeper]`,
+				`Mounts: unknown datastore "badger", available [flatfs level]`,
+				`Tracer: unknown tracer "zipkin", available [jaeger]`,
+				`Discovery: unknown discovery "consul", available [dht]`,
+				`ACL: invalid role "superuser" for rsc`,
+			},
+		},
+		{
+			name: "backend not imported",
+			vals: map[string]interface{}{
+				"UseLocker": true,
+				"Locker":    "redis",
+			},
+			errors: []string{
+				`Locker: locker "redis" not registered, import _ "github.com/plexsysio/go-msuite/modules/node/locker/redis" to use it`,
+			},
+		},
+		{
+			name: "backend config",
+			vals: map[string]interface{}{
+				"UseLocker": true,
+				"Locker":    "zookeeper",
+			},
+			errors: []string{
+				"Locker: ZookeeperHost required for zookeeper",
+				"Locker: ZookeeperPort required for zookeeper",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := jsonConf.DefaultConfig()
+			for k, v := range tc.vals {
+				c.Set(k, v)
+			}
+			err := validate.Config(c)
+			if len(tc.errors) == 0 {
+				if err != nil {
+					t.Fatal("unexpected error", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected errors", tc.errors)
+			}
+			for _, exp := range tc.errors {
+				if !strings.Contains(err.Error(), exp) {
+					t.Fatalf("expected error %q in %v", exp, err)
+				}
+			}
+		})
+	}
+}
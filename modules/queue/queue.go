@@ -0,0 +1,870 @@
+// Package queue provides durable job queues. The jobs are saved in the store
+// before they are processed by the taskmanager workers, so they survive restarts
+// and crashes. A job is claimed with a lease before it is handled and it is
+// delivered again if the lease expires without the job being completed, so the
+// handlers should be idempotent. Failed jobs are retried with a backoff and are
+// moved to the dead letters once all the attempts fail.
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	logger "github.com/ipfs/go-log/v2"
+	"github.com/plexsysio/dLocker"
+	store "github.com/plexsysio/gkvstore"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/diag/status"
+	"github.com/plexsysio/go-msuite/modules/repo"
+	"github.com/plexsysio/go-msuite/modules/sharedStorage"
+	"github.com/plexsysio/taskmanager"
+	"go.uber.org/fx"
+)
+
+var log = logger.Logger("queue")
+
+var (
+	// ErrClosed is returned once the queues are closed
+	ErrClosed = errors.New("queues closed")
+	// ErrInvalidName is returned for an empty queue name
+	ErrInvalidName = errors.New("queue name cannot be empty")
+	// ErrHandlerExists is returned if the queue already has a handler
+	ErrHandlerExists = errors.New("queue already has a handler")
+	// ErrJobExists is returned if a job with the same ID is already queued
+	ErrJobExists = errors.New("job with same ID already exists")
+	// ErrJobNotFound is returned if the job is not present
+	ErrJobNotFound = errors.New("job not found")
+	// ErrSharedNotSupported is returned for shared queues if P2P is not enabled
+	ErrSharedNotSupported = errors.New("shared queues need the shared storage")
+)
+
+const (
+	defaultWorkers      = 1
+	defaultMaxAttempts  = 5
+	defaultMinBackoff   = time.Second
+	defaultMaxBackoff   = 5 * time.Minute
+	defaultLease        = time.Minute
+	defaultPollInterval = time.Second
+	// claimWait is the time a node waits for the lock of a job in the shared
+	// queues
+	claimWait = 100 * time.Millisecond
+	// releaseTimeout bounds the update of the jobs interrupted by the shutdown
+	releaseTimeout = time.Second
+)
+
+// State of a job
+type State string
+
+const (
+	// Pending jobs are waiting to be run at RunAt
+	Pending State = "pending"
+	// Running jobs are claimed by a node till LeaseUntil
+	Running State = "running"
+	// Dead jobs have failed all the attempts
+	Dead State = "dead"
+)
+
+// Job is a unit of work saved in the queue
+type Job struct {
+	ID          string
+	Queue       string
+	Payload     []byte
+	State       State
+	Attempts    int
+	MaxAttempts int
+	Created     time.Time
+	RunAt       time.Time
+	LeaseUntil  time.Time `json:",omitempty"`
+	Owner       string    `json:",omitempty"`
+	LastError   string    `json:",omitempty"`
+}
+
+func (j *Job) GetNamespace() string { return "queue/" + j.Queue }
+
+func (j *Job) GetID() string { return j.ID }
+
+func (j *Job) Marshal() ([]byte, error) { return json.Marshal(j) }
+
+func (j *Job) Unmarshal(buf []byte) error { return json.Unmarshal(buf, j) }
+
+// due returns true if the job can be claimed. Running jobs whose lease expired
+// were interrupted by a crash and are delivered again
+func (j *Job) due(now time.Time) bool {
+	switch j.State {
+	case Pending:
+		return !j.RunAt.After(now)
+	case Running:
+		return j.LeaseUntil.Before(now)
+	}
+	return false
+}
+
+// deadJob is saved in a separate namespace, so that the dead letters are not
+// listed with the jobs to be processed
+type deadJob struct {
+	*Job
+}
+
+func (d deadJob) GetNamespace() string { return "queue-dead/" + d.Queue }
+
+// Handler processes the job. Returning an error retries the job after a backoff.
+// The context is cancelled once the lease of the job expires or the node stops
+type Handler func(context.Context, *Job) error
+
+// Queue is a named durable job queue
+type Queue interface {
+	Name() string
+	// Enqueue saves the job with the payload and returns the ID of the job
+	Enqueue(context.Context, []byte, ...JobOption) (string, error)
+	// Handle registers the handler and starts processing the jobs. Only one
+	// handler can be registered on the node
+	Handle(Handler) error
+	// Get returns the job if it is queued or dead
+	Get(context.Context, string) (*Job, error)
+	// DeadLetters returns the jobs which failed all the attempts
+	DeadLetters(context.Context) ([]*Job, error)
+	// Retry moves the dead job back to the queue with the attempts reset
+	Retry(context.Context, string) error
+	// Discard removes the dead job
+	Discard(context.Context, string) error
+}
+
+// Option configures the queue. Options are used only when the queue is created
+// the first time on the node
+type Option func(*queue)
+
+// WithWorkers sets the no of jobs processed concurrently by the node
+func WithWorkers(n int) Option {
+	return func(q *queue) {
+		q.workers = n
+	}
+}
+
+// WithMaxAttempts sets the default no of attempts for the jobs
+func WithMaxAttempts(n int) Option {
+	return func(q *queue) {
+		q.maxAttempts = n
+	}
+}
+
+// WithBackoff sets the exponential backoff between the attempts
+func WithBackoff(min, max time.Duration) Option {
+	return func(q *queue) {
+		q.minBackoff, q.maxBackoff = min, max
+	}
+}
+
+// WithLease sets the time a job can run before it is delivered again
+func WithLease(d time.Duration) Option {
+	return func(q *queue) {
+		q.lease = d
+	}
+}
+
+// WithPollInterval sets the interval for checking the jobs which are due. Jobs
+// enqueued on the same node are picked up immediately
+func WithPollInterval(d time.Duration) Option {
+	return func(q *queue) {
+		q.pollInterval = d
+	}
+}
+
+// Shared saves the jobs in the shared storage, so that the queue is processed by
+// all the nodes. Jobs are claimed using the locker if it is configured,
+// otherwise the same job could be run on multiple nodes
+func Shared() Option {
+	return func(q *queue) {
+		q.shared = true
+	}
+}
+
+// JobOption configures the job being enqueued
+type JobOption func(*Job)
+
+// Delay runs the job after the duration
+func Delay(d time.Duration) JobOption {
+	return func(j *Job) {
+		j.RunAt = time.Now().Add(d)
+	}
+}
+
+// At runs the job at the time
+func At(t time.Time) JobOption {
+	return func(j *Job) {
+		j.RunAt = t
+	}
+}
+
+// WithJobID uses the ID for the job instead of a random one. Enqueueing a job
+// with the same ID fails with ErrJobExists, which can be used to deduplicate
+func WithJobID(id string) JobOption {
+	return func(j *Job) {
+		j.ID = id
+	}
+}
+
+// Attempts overrides the max attempts of the queue for the job
+func Attempts(n int) JobOption {
+	return func(j *Job) {
+		j.MaxAttempts = n
+	}
+}
+
+// QueueStatus is the status of a queue reported on the diagnostic endpoint
+type QueueStatus struct {
+	Shared    bool `json:",omitempty"`
+	Handler   bool
+	Pending   int
+	Running   int
+	Dead      int
+	Processed int
+	Failed    int
+	Error     string `json:",omitempty"`
+}
+
+// Manager creates the queues. Each queue is created once and shared by all its
+// users on the node
+type Manager struct {
+	tm    *taskmanager.TaskManager
+	local store.Store
+	lk    dLocker.DLocker
+	id    string
+	// openShared opens the shared store on the first shared queue, so that the
+	// nodes without shared queues do not join the shared storage
+	openShared func() (store.Store, error)
+	shared     store.Store
+
+	mtx    sync.Mutex
+	queues map[string]*queue
+	stop   chan struct{}
+	// jobs are run with this context, so that they are cancelled if they do not
+	// finish while stopping
+	jobsCtx    context.Context
+	cancelJobs context.CancelFunc
+	running    sync.WaitGroup
+}
+
+// NewManager returns the queues saving the jobs in the local store. The shared
+// store and the locker are optional and are used for the shared queues. The ID
+// is used to report the owner of the running jobs
+func NewManager(
+	tm *taskmanager.TaskManager,
+	local, shared store.Store,
+	lk dLocker.DLocker,
+	id string,
+) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		tm:         tm,
+		local:      local,
+		shared:     shared,
+		lk:         lk,
+		id:         id,
+		queues:     make(map[string]*queue),
+		stop:       make(chan struct{}),
+		jobsCtx:    ctx,
+		cancelJobs: cancel,
+	}
+}
+
+// New is the constructor used by the node. The jobs are saved in the repository
+// store and the shared queues use the shared storage if P2P is enabled. The
+// shared storage is opened only once a shared queue is created. While stopping,
+// the running jobs get the drain timeout to finish
+func New(
+	lc fx.Lifecycle,
+	tm *taskmanager.TaskManager,
+	r repo.Repo,
+	p2pCfg settings.P2P,
+	drainCfg settings.Drain,
+	st status.Manager,
+	lk dLocker.DLocker,
+	shStore sharedStorage.Provider,
+) (*Manager, error) {
+	var id string
+	if p2pCfg.Identity != nil {
+		id = p2pCfg.Identity.ID
+	}
+	m := NewManager(tm, r.Store(), nil, lk, id)
+	if shStore != nil {
+		m.openShared = func() (store.Store, error) {
+			return shStore.SharedStorage("/queue", nil)
+		}
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			log.Debugf("stopping queues")
+			defer log.Debugf("stopped queues")
+			ctx, cancel := context.WithTimeout(ctx, drainCfg.TimeoutDuration())
+			defer cancel()
+			return m.Close(ctx)
+		},
+	})
+	st.AddReporter("Queues", m)
+	return m, nil
+}
+
+// Queue returns the queue with the name
+func (m *Manager) Queue(name string, opts ...Option) (Queue, error) {
+	if name == "" {
+		return nil, ErrInvalidName
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	select {
+	case <-m.stop:
+		return nil, ErrClosed
+	default:
+	}
+	if q, found := m.queues[name]; found {
+		return q, nil
+	}
+	q := &queue{
+		name:         name,
+		m:            m,
+		workers:      defaultWorkers,
+		maxAttempts:  defaultMaxAttempts,
+		minBackoff:   defaultMinBackoff,
+		maxBackoff:   defaultMaxBackoff,
+		lease:        defaultLease,
+		pollInterval: defaultPollInterval,
+		wake:         make(chan struct{}, 1),
+		stopped:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	q.st = m.local
+	if q.shared {
+		st, err := m.sharedStore()
+		if err != nil {
+			return nil, err
+		}
+		q.st = st
+	}
+	if q.workers <= 0 || q.maxAttempts <= 0 || q.lease <= 0 || q.pollInterval <= 0 ||
+		q.minBackoff <= 0 || q.maxBackoff < q.minBackoff {
+		return nil, fmt.Errorf("invalid options for queue %s", name)
+	}
+	q.slots = make(chan struct{}, q.workers)
+	m.queues[name] = q
+	return q, nil
+}
+
+// sharedStore returns the store of the shared queues, opening it if needed. It
+// should be called with the lock held
+func (m *Manager) sharedStore() (store.Store, error) {
+	if m.shared == nil && m.openShared != nil {
+		st, err := m.openShared()
+		if err != nil {
+			return nil, err
+		}
+		m.shared = st
+	}
+	if m.shared == nil {
+		return nil, ErrSharedNotSupported
+	}
+	return m.shared, nil
+}
+
+// Close stops processing the jobs. The running jobs are waited on till the
+// context is done, after which they are cancelled and put back in the queue
+func (m *Manager) Close(ctx context.Context) error {
+	m.mtx.Lock()
+	select {
+	case <-m.stop:
+		m.mtx.Unlock()
+		return nil
+	default:
+	}
+	close(m.stop)
+	queues := m.list()
+	m.mtx.Unlock()
+
+	for _, q := range queues {
+		q.waitStopped(ctx)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.running.Wait()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	log.Warn("cancelling running jobs")
+	m.cancelJobs()
+	select {
+	case <-done:
+	case <-time.After(releaseTimeout):
+		log.Warn("timed out waiting for jobs to be cancelled")
+	}
+	return nil
+}
+
+func (m *Manager) list() []*queue {
+	queues := make([]*queue, 0, len(m.queues))
+	for _, q := range m.queues {
+		queues = append(queues, q)
+	}
+	return queues
+}
+
+func (m *Manager) Status() interface{} {
+	m.mtx.Lock()
+	queues := m.list()
+	m.mtx.Unlock()
+
+	if len(queues) == 0 {
+		return "no queues"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	sts := map[string]QueueStatus{}
+	for _, q := range queues {
+		sts[q.name] = q.status(ctx)
+	}
+	return sts
+}
+
+type queue struct {
+	name         string
+	m            *Manager
+	st           store.Store
+	shared       bool
+	workers      int
+	maxAttempts  int
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	lease        time.Duration
+	pollInterval time.Duration
+
+	// slots limits the jobs running concurrently
+	slots chan struct{}
+	wake  chan struct{}
+	// stopped is closed once the polling ends. It is not used if there is no
+	// handler
+	stopped chan struct{}
+
+	mtx       sync.Mutex
+	handler   Handler
+	processed int
+	failed    int
+}
+
+func (q *queue) Name() string { return q.name }
+
+func (q *queue) Enqueue(ctx context.Context, payload []byte, opts ...JobOption) (string, error) {
+	now := time.Now()
+	j := &Job{
+		ID:          uuid.New().String(),
+		Queue:       q.name,
+		Payload:     payload,
+		State:       Pending,
+		MaxAttempts: q.maxAttempts,
+		Created:     now,
+		RunAt:       now,
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	if j.ID == "" {
+		return "", errors.New("job ID cannot be empty")
+	}
+	// Not all the stores fail the creation of existing records
+	q.mtx.Lock()
+	err := q.st.Read(ctx, &Job{ID: j.ID, Queue: q.name})
+	if err == nil {
+		q.mtx.Unlock()
+		return "", ErrJobExists
+	}
+	if errors.Is(err, store.ErrRecordNotFound) {
+		err = q.st.Create(ctx, j)
+	}
+	q.mtx.Unlock()
+	if err != nil {
+		if errors.Is(err, store.ErrRecordAlreadyExists) {
+			return "", ErrJobExists
+		}
+		return "", err
+	}
+	q.notify()
+	return j.ID, nil
+}
+
+// notify wakes up the polling to pick up the new jobs
+func (q *queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *queue) Handle(h Handler) error {
+	q.m.mtx.Lock()
+	defer q.m.mtx.Unlock()
+
+	select {
+	case <-q.m.stop:
+		return ErrClosed
+	default:
+	}
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.handler != nil {
+		return ErrHandlerExists
+	}
+	_, err := q.m.tm.GoFunc("queue/"+q.name, q.poll)
+	if err != nil {
+		return err
+	}
+	q.handler = h
+	return nil
+}
+
+func (q *queue) waitStopped(ctx context.Context) {
+	q.mtx.Lock()
+	started := q.handler != nil
+	q.mtx.Unlock()
+
+	if !started {
+		return
+	}
+	select {
+	case <-q.stopped:
+	case <-ctx.Done():
+	}
+}
+
+func (q *queue) poll(ctx context.Context) error {
+	defer close(q.stopped)
+
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := q.dispatch(ctx); err != nil {
+			log.Warnf("failed dispatching jobs of %s: %v", q.name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-q.m.stop:
+			return nil
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch claims the jobs which are due and runs them on the taskmanager, as
+// long as there are free slots
+func (q *queue) dispatch(ctx context.Context) error {
+	free := cap(q.slots) - len(q.slots)
+	if free == 0 {
+		return nil
+	}
+	now := time.Now()
+	jobs, err := q.listJobs(ctx, func(j *Job) bool { return j.due(now) })
+	if err != nil {
+		return err
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		if jobs[i].RunAt.Equal(jobs[k].RunAt) {
+			return jobs[i].Created.Before(jobs[k].Created)
+		}
+		return jobs[i].RunAt.Before(jobs[k].RunAt)
+	})
+	for _, j := range jobs {
+		select {
+		case q.slots <- struct{}{}:
+		default:
+			return nil
+		}
+		claimed, err := q.claim(ctx, j)
+		if err != nil || !claimed {
+			<-q.slots
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		q.m.running.Add(1)
+		_, err = q.m.tm.GoFunc("queue/"+q.name+"/"+j.ID, func(ctx context.Context) error {
+			defer func() {
+				<-q.slots
+				q.m.running.Done()
+			}()
+			q.run(ctx, j)
+			return nil
+		})
+		if err != nil {
+			<-q.slots
+			q.m.running.Done()
+			// The job is delivered again once the lease expires
+			log.Warnf("failed running job %s of %s: %v", j.ID, q.name, err)
+		}
+	}
+	return nil
+}
+
+// claim marks the job as running with a lease. The job is read again, so that
+// it is not claimed if some other worker got it
+func (q *queue) claim(ctx context.Context, j *Job) (bool, error) {
+	if q.shared && q.m.lk != nil {
+		unlock, err := q.m.lk.TryLock(ctx, "queue/"+q.name+"/"+j.ID, claimWait)
+		if err != nil {
+			return false, nil
+		}
+		defer unlock()
+	}
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	cur := &Job{ID: j.ID, Queue: q.name}
+	if err := q.st.Read(ctx, cur); err != nil {
+		if errors.Is(err, store.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	now := time.Now()
+	if !cur.due(now) {
+		return false, nil
+	}
+	cur.State = Running
+	cur.Attempts++
+	cur.LeaseUntil = now.Add(q.lease)
+	cur.Owner = q.m.id
+	if err := q.st.Update(ctx, cur); err != nil {
+		return false, err
+	}
+	*j = *cur
+	return true, nil
+}
+
+func (q *queue) run(ctx context.Context, j *Job) {
+	q.mtx.Lock()
+	h := q.handler
+	q.mtx.Unlock()
+
+	ctx, cancel := context.WithDeadline(ctx, j.LeaseUntil)
+	defer cancel()
+	go func() {
+		select {
+		case <-q.m.jobsCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := h(ctx, j)
+
+	// The job is updated even if the node is stopping, so that it is not
+	// delivered again after completion
+	uctx, ucancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer ucancel()
+
+	switch {
+	case err == nil:
+		err = q.complete(uctx, j)
+	case q.m.jobsCtx.Err() != nil:
+		// Interrupted by the shutdown, this is not counted as an attempt
+		j.Attempts--
+		err = q.retry(uctx, j, time.Now(), "interrupted")
+	case j.Attempts >= j.MaxAttempts:
+		log.Warnf("job %s of %s failed all attempts: %v", j.ID, q.name, err)
+		q.count(false)
+		err = q.bury(uctx, j, err.Error())
+	default:
+		log.Debugf("job %s of %s failed: %v", j.ID, q.name, err)
+		q.count(false)
+		err = q.retry(uctx, j, time.Now().Add(q.backoff(j.Attempts)), err.Error())
+	}
+	if err != nil {
+		// The job is delivered again once the lease expires
+		log.Warnf("failed updating job %s of %s: %v", j.ID, q.name, err)
+	}
+}
+
+func (q *queue) count(processed bool) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if processed {
+		q.processed++
+		return
+	}
+	q.failed++
+}
+
+func (q *queue) complete(ctx context.Context, j *Job) error {
+	q.count(true)
+	return q.st.Delete(ctx, j)
+}
+
+func (q *queue) retry(ctx context.Context, j *Job, at time.Time, reason string) error {
+	j.State = Pending
+	j.RunAt = at
+	j.LeaseUntil = time.Time{}
+	j.Owner = ""
+	j.LastError = reason
+	return q.st.Update(ctx, j)
+}
+
+// bury moves the job to the dead letters
+func (q *queue) bury(ctx context.Context, j *Job, reason string) error {
+	j.State = Dead
+	j.LeaseUntil = time.Time{}
+	j.Owner = ""
+	j.LastError = reason
+	if err := q.st.Update(ctx, deadJob{j}); err != nil {
+		return err
+	}
+	return q.st.Delete(ctx, j)
+}
+
+// backoff doubles the delay with each attempt till the max backoff
+func (q *queue) backoff(attempts int) time.Duration {
+	d := q.minBackoff
+	for i := 1; i < attempts && d < q.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > q.maxBackoff {
+		d = q.maxBackoff
+	}
+	return d
+}
+
+func (q *queue) Get(ctx context.Context, id string) (*Job, error) {
+	j := &Job{ID: id, Queue: q.name}
+	err := q.st.Read(ctx, j)
+	if errors.Is(err, store.ErrRecordNotFound) {
+		err = q.st.Read(ctx, deadJob{j})
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrRecordNotFound) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	return j, nil
+}
+
+func (q *queue) DeadLetters(ctx context.Context) ([]*Job, error) {
+	res, err := q.st.List(ctx, func() store.Item {
+		return deadJob{&Job{Queue: q.name}}
+	}, store.ListOpt{})
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	for r := range res {
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		j := r.Val.(deadJob).Job
+		if j.Queue == q.name {
+			jobs = append(jobs, j)
+		}
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Created.Before(jobs[k].Created) })
+	return jobs, nil
+}
+
+func (q *queue) Retry(ctx context.Context, id string) error {
+	j, err := q.readDead(ctx, id)
+	if err != nil {
+		return err
+	}
+	j.Attempts = 0
+	if err := q.retry(ctx, j, time.Now(), j.LastError); err != nil {
+		return err
+	}
+	q.notify()
+	return q.st.Delete(ctx, deadJob{j})
+}
+
+func (q *queue) Discard(ctx context.Context, id string) error {
+	j, err := q.readDead(ctx, id)
+	if err != nil {
+		return err
+	}
+	return q.st.Delete(ctx, deadJob{j})
+}
+
+func (q *queue) readDead(ctx context.Context, id string) (*Job, error) {
+	j := &Job{ID: id, Queue: q.name}
+	if err := q.st.Read(ctx, deadJob{j}); err != nil {
+		if errors.Is(err, store.ErrRecordNotFound) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	return j, nil
+}
+
+// listJobs returns the jobs of the queue matching the filter. The namespaces are
+// matched by prefix in some stores, so the queue is checked again
+func (q *queue) listJobs(ctx context.Context, match func(*Job) bool) ([]*Job, error) {
+	res, err := q.st.List(ctx, func() store.Item {
+		return &Job{Queue: q.name}
+	}, store.ListOpt{})
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	for r := range res {
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		j := r.Val.(*Job)
+		if j.Queue == q.name && match(j) {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs, nil
+}
+
+func (q *queue) status(ctx context.Context) QueueStatus {
+	q.mtx.Lock()
+	st := QueueStatus{
+		Shared:    q.shared,
+		Handler:   q.handler != nil,
+		Processed: q.processed,
+		Failed:    q.failed,
+	}
+	q.mtx.Unlock()
+
+	jobs, err := q.listJobs(ctx, func(*Job) bool { return true })
+	if err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	for _, j := range jobs {
+		switch j.State {
+		case Pending:
+			st.Pending++
+		case Running:
+			st.Running++
+		}
+	}
+	dead, err := q.DeadLetters(ctx)
+	if err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	st.Dead = len(dead)
+	return st
+}
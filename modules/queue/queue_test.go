@@ -0,0 +1,313 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	store "github.com/plexsysio/gkvstore"
+	"github.com/plexsysio/gkvstore/inmem"
+	syncstore "github.com/plexsysio/gkvstore/sync"
+	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/diag/status"
+	"github.com/plexsysio/go-msuite/modules/queue"
+	repoInmem "github.com/plexsysio/go-msuite/modules/repo/inmem"
+	"github.com/plexsysio/go-msuite/modules/sharedStorage"
+	"github.com/plexsysio/taskmanager"
+	"go.uber.org/fx/fxtest"
+)
+
+func newManager(t *testing.T, st store.Store) *queue.Manager {
+	t.Helper()
+
+	tm := taskmanager.New(5, 10, 15*time.Second)
+	m := queue.NewManager(tm, st, nil, nil, "node")
+	t.Cleanup(func() {
+		_ = m.Close(context.Background())
+		tm.Stop()
+	})
+	return m
+}
+
+func TestQueue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	m := newManager(t, syncstore.New(inmem.New()))
+	if _, err := m.Queue(""); err != queue.ErrInvalidName {
+		t.Fatal("expected invalid name", err)
+	}
+	if _, err := m.Queue("shared", queue.Shared()); err != queue.ErrSharedNotSupported {
+		t.Fatal("expected shared not supported", err)
+	}
+	q, err := m.Queue("emails", queue.WithPollInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := q.Enqueue(ctx, []byte("first"), queue.WithJobID("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue(ctx, []byte("first"), queue.WithJobID(id)); err != queue.ErrJobExists {
+		t.Fatal("expected job exists", err)
+	}
+	delayed, err := q.Enqueue(ctx, []byte("delayed"), queue.Delay(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := q.Get(ctx, delayed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.State != queue.Pending || string(j.Payload) != "delayed" {
+		t.Fatal("incorrect job", j)
+	}
+
+	recvd := make(chan string, 2)
+	err = q.Handle(func(_ context.Context, j *queue.Job) error {
+		recvd <- string(j.Payload)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Handle(nil); err != queue.ErrHandlerExists {
+		t.Fatal("expected handler exists", err)
+	}
+
+	start := time.Now()
+	if p := <-recvd; p != "first" {
+		t.Fatal("incorrect job received", p)
+	}
+	if p := <-recvd; p != "delayed" || time.Since(start) < 150*time.Millisecond {
+		t.Fatal("incorrect delayed job", p, time.Since(start))
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := q.Get(ctx, delayed); err != queue.ErrJobNotFound {
+		t.Fatal("expected completed job to be removed", err)
+	}
+	sts := m.Status().(map[string]queue.QueueStatus)
+	if sts["emails"].Processed != 2 || sts["emails"].Pending != 0 {
+		t.Fatal("incorrect status", sts)
+	}
+}
+
+func TestRetryAndDeadLetters(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	m := newManager(t, syncstore.New(inmem.New()))
+	q, err := m.Queue(
+		"webhooks",
+		queue.WithMaxAttempts(3),
+		queue.WithBackoff(10*time.Millisecond, 20*time.Millisecond),
+		queue.WithPollInterval(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := make(chan int, 10)
+	err = q.Handle(func(_ context.Context, j *queue.Job) error {
+		attempts <- j.Attempts
+		if string(j.Payload) == "ok" && j.Attempts == 2 {
+			return nil
+		}
+		return errors.New("dummy error")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := q.Enqueue(ctx, []byte("fail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if a := <-attempts; a != i {
+			t.Fatal("incorrect attempt", a, i)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	dead, err := q.DeadLetters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].ID != id || dead[0].State != queue.Dead || dead[0].LastError != "dummy error" {
+		t.Fatal("incorrect dead letters", dead)
+	}
+	j, err := q.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.State != queue.Dead {
+		t.Fatal("expected dead job", j)
+	}
+
+	if err := q.Retry(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if a := <-attempts; a != 1 {
+		t.Fatal("expected attempts to be reset", a)
+	}
+	for i := 2; i <= 3; i++ {
+		<-attempts
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := q.Discard(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Discard(ctx, id); err != queue.ErrJobNotFound {
+		t.Fatal("expected job not found", err)
+	}
+
+	_, err = q.Enqueue(ctx, []byte("ok"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-attempts
+	<-attempts
+	time.Sleep(50 * time.Millisecond)
+	dead, err = q.DeadLetters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 0 {
+		t.Fatal("expected no dead letters", dead)
+	}
+}
+
+func TestRedelivery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	st := syncstore.New(inmem.New())
+
+	// A job left running by a crashed node is delivered again once its lease
+	// expires
+	err := st.Create(ctx, &queue.Job{
+		ID:          "crashed",
+		Queue:       "emails",
+		State:       queue.Running,
+		Attempts:    1,
+		MaxAttempts: 5,
+		Created:     time.Now(),
+		RunAt:       time.Now(),
+		LeaseUntil:  time.Now().Add(100 * time.Millisecond),
+		Owner:       "crashed-node",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newManager(t, st)
+	q, err := m.Queue("emails", queue.WithPollInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvd := make(chan *queue.Job, 1)
+	err = q.Handle(func(_ context.Context, j *queue.Job) error {
+		recvd <- j
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	j := <-recvd
+	if j.ID != "crashed" || j.Attempts != 2 || j.Owner != "node" || time.Since(start) < 50*time.Millisecond {
+		t.Fatal("incorrect redelivery", j, time.Since(start))
+	}
+}
+
+func TestCloseInterrupts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	st := syncstore.New(inmem.New())
+	m := newManager(t, st)
+	q, err := m.Queue("emails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	err = q.Handle(func(ctx context.Context, _ *queue.Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := q.Enqueue(ctx, []byte("slow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	cctx, ccancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer ccancel()
+	if err := m.Close(cctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Queue("emails"); err != queue.ErrClosed {
+		t.Fatal("expected closed", err)
+	}
+
+	// The interrupted job is put back without counting the attempt
+	j := &queue.Job{ID: id, Queue: "emails"}
+	if err := st.Read(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+	if j.State != queue.Pending || j.Attempts != 0 || j.LastError != "interrupted" {
+		t.Fatal("incorrect job after close", j)
+	}
+}
+
+// countingProvider returns the same in-memory store as the shared storage
+type countingProvider struct {
+	st    store.Store
+	opens int
+}
+
+func (p *countingProvider) SharedStorage(string, sharedStorage.Callback) (store.Store, error) {
+	p.opens++
+	return p.st, nil
+}
+
+func TestSharedStorageOpenedLazily(t *testing.T) {
+	r, err := repoInmem.CreateOrOpen(jsonConf.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := taskmanager.New(5, 10, 15*time.Second)
+	defer tm.Stop()
+
+	lc := fxtest.NewLifecycle(t)
+	p := &countingProvider{st: syncstore.New(inmem.New())}
+	m, err := queue.New(lc, tm, r, settings.P2P{}, settings.Drain{}, status.New(), nil, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc.RequireStart()
+	defer lc.RequireStop()
+
+	if _, err := m.Queue("local"); err != nil {
+		t.Fatal(err)
+	}
+	if p.opens != 0 {
+		t.Fatal("expected shared storage not to be opened without shared queues")
+	}
+	for _, name := range []string{"shared1", "shared2"} {
+		if _, err := m.Queue(name, queue.Shared()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.opens != 1 {
+		t.Fatal("expected shared storage to be opened once", p.opens)
+	}
+}
@@ -0,0 +1,285 @@
+// Package ratelimit provides token bucket rate limits for the gRPC and HTTP
+// servers. The limits are configured using the "RateLimits" key and are updated
+// without restart when the config changes. Requests are identified by the gRPC
+// method or HTTP path along with the caller, which could be the ID or role in
+// the JWT claims or the remote peer.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/ipfs/go-log/v2"
+	"github.com/plexsysio/go-msuite/modules/auth"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/repo"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/fx"
+	"golang.org/x/time/rate"
+)
+
+var log = logger.Logger("ratelimit")
+
+const (
+	// idleTimeout is the time after which unused buckets are removed
+	idleTimeout = 10 * time.Minute
+	// sweepInterval is the interval for removing the unused buckets
+	sweepInterval = time.Minute
+)
+
+// Request is the request being limited
+type Request struct {
+	// Transport is used as the metrics label, grpc or http
+	Transport string
+	// Method is the gRPC method or HTTP path
+	Method string
+	// Token is the JWT token sent by the caller, if any
+	Token string
+	// Addr is the remote address. For libp2p connections this is the peer ID
+	Addr string
+}
+
+// Result is returned for the requests which are limited
+type Result struct {
+	// Rule is the name of the rate limit exceeded
+	Rule string
+	// RetryAfter is the time after which the request could be allowed
+	RetryAfter time.Duration
+}
+
+type rule struct {
+	name string
+	settings.RateLimit
+}
+
+func (r rule) matches(method string) bool {
+	if strings.HasSuffix(r.Match, "*") {
+		return strings.HasPrefix(method, strings.TrimSuffix(r.Match, "*"))
+	}
+	return r.Match == method
+}
+
+type bucket struct {
+	*rate.Limiter
+	lastUsed time.Time
+}
+
+// Limiter applies the rate limits on the requests
+type Limiter struct {
+	jm auth.JWTManager
+
+	mtx       sync.Mutex
+	rules     []rule
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	requests  *prometheus.CounterVec
+}
+
+// NewLimiter returns the limiter with the limits. The JWT manager is optional
+// and is used to identify the callers by the claims
+func NewLimiter(limits settings.RateLimits, jm auth.JWTManager) *Limiter {
+	l := &Limiter{
+		jm: jm,
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "msuite_ratelimit_requests_total",
+				Help: "Requests checked by the rate limits",
+			},
+			[]string{"transport", "rule", "result"},
+		),
+	}
+	l.SetLimits(limits)
+	return l
+}
+
+// New is the constructor used by the node. The auth and metrics are optional
+func New(
+	lc fx.Lifecycle,
+	r repo.Repo,
+	limits settings.RateLimits,
+	jm auth.JWTManager,
+	reg *prometheus.Registry,
+) (*Limiter, error) {
+	l := NewLimiter(limits, jm)
+	if reg != nil {
+		if err := reg.Register(l.requests); err != nil {
+			return nil, err
+		}
+	}
+	unsubscribe := repo.Subscribe(r, func(ch repo.ConfigChange) {
+		limits := settings.RateLimits{}
+		_ = ch.New(&limits)
+		log.Info("rate limits updated")
+		l.SetLimits(limits)
+	}, "RateLimits")
+	lc.Append(fx.Hook{
+		OnStop: func(_ context.Context) error {
+			unsubscribe()
+			return nil
+		},
+	})
+	return l, nil
+}
+
+// SetLimits replaces the limits. The existing buckets are reset
+func (l *Limiter) SetLimits(limits settings.RateLimits) {
+	rules := make([]rule, 0, len(limits))
+	for name, lim := range limits {
+		if err := lim.Validate(); err != nil {
+			log.Warnf("ignoring invalid rate limit %s: %v", name, err)
+			continue
+		}
+		rules = append(rules, rule{name: name, RateLimit: lim})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].name < rules[j].name })
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.rules = rules
+	l.buckets = make(map[string]*bucket)
+}
+
+// Allow checks the request against all the matching limits. The request is
+// allowed only if all of them allow it, tokens are taken from the buckets only
+// in that case
+func (l *Limiter) Allow(req Request) (Result, bool) {
+	l.mtx.Lock()
+	rules := l.rules
+	l.mtx.Unlock()
+
+	var (
+		claims *auth.UserClaims
+		// claims are verified only if some rule needs them
+		verified bool
+		matched  []rule
+		keys     []string
+	)
+	for _, r := range rules {
+		if !r.matches(req.Method) {
+			continue
+		}
+		if !verified && (r.By == settings.ByCaller || r.By == settings.ByRole) {
+			claims, verified = l.verify(req.Token), true
+		}
+		matched = append(matched, r)
+		keys = append(keys, r.name+"|"+callerKey(r.By, req, claims))
+	}
+	if len(matched) == 0 {
+		return Result{}, true
+	}
+
+	limited, allowed := l.take(keys, matched)
+	if !allowed {
+		l.requests.WithLabelValues(req.Transport, limited.Rule, "limited").Inc()
+		return limited, false
+	}
+	for _, r := range matched {
+		l.requests.WithLabelValues(req.Transport, r.name, "allowed").Inc()
+	}
+	return Result{}, true
+}
+
+func (l *Limiter) verify(token string) *auth.UserClaims {
+	if l.jm == nil || token == "" {
+		return nil
+	}
+	claims, err := l.jm.Verify(token)
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+// take reserves a token from the bucket of each key. If some bucket is empty, the
+// reservations are cancelled and the first limit exceeded is returned
+func (l *Limiter) take(keys []string, rules []rule) (Result, bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+	reserved := make([]*rate.Reservation, 0, len(keys))
+	for i, key := range keys {
+		r := rules[i]
+		b, found := l.buckets[key]
+		if !found {
+			burst := r.Burst
+			if burst == 0 {
+				burst = int(math.Ceil(r.Rate))
+			}
+			b = &bucket{Limiter: rate.NewLimiter(rate.Limit(r.Rate), burst)}
+			l.buckets[key] = b
+		}
+		b.lastUsed = now
+		res := b.ReserveN(now, 1)
+		if delay := res.DelayFrom(now); !res.OK() || delay > 0 {
+			res.CancelAt(now)
+			for _, prev := range reserved {
+				prev.CancelAt(now)
+			}
+			return Result{Rule: r.name, RetryAfter: delay}, false
+		}
+		reserved = append(reserved, res)
+	}
+	return Result{}, true
+}
+
+// sweep removes the unused buckets. It should be called with the lock held
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if now.Sub(b.lastUsed) > idleTimeout {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// callerKey identifies the bucket of the request within the rule. The requests
+// matching a prefix share the buckets, only ByMethod uses the method
+func callerKey(by string, req Request, claims *auth.UserClaims) string {
+	switch by {
+	case settings.ByMethod:
+		return req.Method
+	case settings.ByCaller:
+		if claims != nil {
+			return "id:" + claims.ID
+		}
+		return "peer:" + peerKey(req.Addr)
+	case settings.ByRole:
+		if claims != nil {
+			return "role:" + claims.Role
+		}
+		return "role:"
+	case settings.ByPeer:
+		return peerKey(req.Addr)
+	case settings.ByIP:
+		return ipKey(req.Addr)
+	}
+	return ""
+}
+
+// peerKey is the IP for network addresses and the peer ID for libp2p addresses
+func peerKey(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// ipKey is the IP for network addresses. All the callers without an IP address
+// share the same bucket
+func ipKey(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/plexsysio/go-msuite/modules/auth"
+	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/ratelimit"
+)
+
+type user struct {
+	id   string
+	role string
+}
+
+func (u user) ID() string                   { return u.id }
+func (u user) Role() string                 { return u.role }
+func (u user) Mtdt() map[string]interface{} { return nil }
+
+func allowed(l *ratelimit.Limiter, req ratelimit.Request, count int) int {
+	n := 0
+	for i := 0; i < count; i++ {
+		if _, ok := l.Allow(req); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLimiter(t *testing.T) {
+	cfg := jsonConf.DefaultConfig()
+	cfg.Set("JWTSecret", "dummysecret")
+	jm, err := auth.NewJWTManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok1, err := jm.Generate(user{id: "user1", role: "admin"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok2, err := jm.Generate(user{id: "user2", role: "admin"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := ratelimit.NewLimiter(settings.RateLimits{
+		"callers": {Match: "/svc.Users/*", By: settings.ByCaller, Rate: 0.1, Burst: 2},
+		"roles":   {Match: "/svc.Users/Get", By: settings.ByRole, Rate: 0.1, Burst: 3},
+		"ips":     {Match: "/api/*", By: settings.ByIP, Rate: 0.1},
+	}, jm)
+
+	t.Run("caller", func(t *testing.T) {
+		req := ratelimit.Request{Method: "/svc.Users/List", Token: tok1, Addr: "peer1"}
+		if n := allowed(l, req, 5); n != 2 {
+			t.Fatal("expected burst of 2 allowed", n)
+		}
+		res, ok := l.Allow(req)
+		if ok || res.Rule != "callers" || res.RetryAfter <= 9*time.Second || res.RetryAfter > 10*time.Second {
+			t.Fatal("incorrect result", res, ok)
+		}
+		// Each caller gets its own bucket, shared by the methods matched
+		req.Token = tok2
+		if n := allowed(l, req, 1); n != 1 {
+			t.Fatal("expected another caller to be allowed", n)
+		}
+		req.Method = "/svc.Users/Create"
+		if n := allowed(l, req, 5); n != 1 {
+			t.Fatal("expected methods matched to share the bucket", n)
+		}
+		// Callers without valid tokens are identified by the peer
+		req.Token = "invalid"
+		if n := allowed(l, req, 5); n != 2 {
+			t.Fatal("expected burst of 2 allowed for the peer", n)
+		}
+		req.Token = ""
+		if n := allowed(l, req, 5); n != 0 {
+			t.Fatal("expected peer to share the bucket", n)
+		}
+	})
+
+	t.Run("all matching limits", func(t *testing.T) {
+		// Callers are limited to 2 each and the role to 3 in all
+		tok3, err := jm.Generate(user{id: "user3", role: "admin"}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tok4, err := jm.Generate(user{id: "user4", role: "admin"}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowedCount := 0
+		for _, tok := range []string{tok3, tok4} {
+			req := ratelimit.Request{Method: "/svc.Users/Get", Token: tok}
+			allowedCount += allowed(l, req, 5)
+		}
+		if allowedCount != 3 {
+			t.Fatal("expected role limit of 3", allowedCount)
+		}
+	})
+
+	t.Run("ip", func(t *testing.T) {
+		req := ratelimit.Request{Method: "/api/v1", Addr: "10.0.0.1:4000"}
+		if n := allowed(l, req, 3); n != 1 {
+			t.Fatal("expected burst to default to the rate rounded up", n)
+		}
+		req.Addr = "10.0.0.1:5000"
+		if n := allowed(l, req, 3); n != 0 {
+			t.Fatal("expected same IP to share the bucket", n)
+		}
+		// The limit applies to all the paths matched, so varying the path does
+		// not get around it
+		req.Method = "/api/v2"
+		if n := allowed(l, req, 3); n != 0 {
+			t.Fatal("expected paths matched to share the bucket", n)
+		}
+		req.Addr = "10.0.0.2:4000"
+		if n := allowed(l, req, 3); n != 1 {
+			t.Fatal("expected another IP to get its own bucket", n)
+		}
+	})
+
+	t.Run("unmatched", func(t *testing.T) {
+		req := ratelimit.Request{Method: "/svc.Other/Get"}
+		if n := allowed(l, req, 10); n != 10 {
+			t.Fatal("expected unmatched requests to be allowed", n)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		l.SetLimits(settings.RateLimits{
+			"all":     {Match: "*", By: settings.ByMethod, Rate: 0.1, Burst: 1},
+			"invalid": {Match: "/svc.Other/Get", By: "unknown", Rate: 1},
+		})
+		req := ratelimit.Request{Method: "/svc.Other/Get"}
+		if n := allowed(l, req, 3); n != 1 {
+			t.Fatal("expected updated limits", n)
+		}
+		req.Method = "/svc.Users/List"
+		req.Token = tok1
+		if n := allowed(l, req, 3); n != 1 {
+			t.Fatal("expected previous limits to be removed", n)
+		}
+	})
+}
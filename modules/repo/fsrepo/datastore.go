@@ -3,6 +3,9 @@ package fsrepo
 import (
 	"context"
 	"errors"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
 
 	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/mount"
@@ -40,6 +43,56 @@ var DefaultMountInfo = map[string]interface{}{
 	},
 }
 
+// DatastoreConstructor creates the datastore at the path. The params are the
+// mount config of the datastore
+type DatastoreConstructor func(path string, params map[string]interface{}) (ds.Batching, error)
+
+var datastores = utils.NewRegistry("datastore")
+
+// RegisterDatastore adds the datastore type which can be used in Mounts
+func RegisterDatastore(name string, ctor DatastoreConstructor) {
+	datastores.Register(name, ctor)
+}
+
+// Datastores returns the names of the registered datastore types
+func Datastores() []string {
+	return datastores.Names()
+}
+
+func init() {
+	RegisterDatastore("level", func(path string, _ map[string]interface{}) (ds.Batching, error) {
+		return leveldb.NewDatastore(path, &leveldb.Options{})
+	})
+	RegisterDatastore("flatfs", func(path string, params map[string]interface{}) (ds.Batching, error) {
+		sFn, ok := params["shardFunc"].(string)
+		if !ok {
+			sFn = "/repo/flatfs/shard/v1/next-to-last/2"
+		}
+		sn, ok := params["sync"].(bool)
+		if !ok {
+			sn = true
+		}
+		sf, err := flatfs.ParseShardFunc(sFn)
+		if err != nil {
+			return nil, err
+		}
+		return flatfs.CreateOrOpen(path, sf, sn)
+	})
+}
+
+// ValidateMounts checks that the datastore types used in Mounts are registered
+func ValidateMounts(c config.Config) error {
+	mntInfo := map[string]interface{}{}
+	_ = c.Get("Mounts", &mntInfo)
+	var errs *multierror.Error
+	for k := range mntInfo {
+		if _, err := datastores.Get(k); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("Mounts: %w", err))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
 func openDatastoreFromCfg(root string, c config.Config) (mDS ds.Batching, retErr error) {
 	mntInfo := map[string]interface{}{}
 	if ok := c.Get("Mounts", &mntInfo); !ok {
@@ -65,6 +118,11 @@ func openDatastoreFromCfg(root string, c config.Config) (mDS ds.Batching, retErr
 			retErr = errors.New("Prefix missing for datastore")
 			return
 		}
+		ctor, err := datastores.Get(k)
+		if err != nil {
+			retErr = err
+			return
+		}
 		path, ok := dCfg["path"].(string)
 		if !ok {
 			path = root
@@ -76,32 +134,7 @@ func openDatastoreFromCfg(root string, c config.Config) (mDS ds.Batching, retErr
 				return
 			}
 		}
-		var newDs ds.Batching
-		var err error
-		switch k {
-		case "level":
-			newDs, err = leveldb.NewDatastore(path, &leveldb.Options{})
-		// case "badger":
-		// 	newDs, err = badger.NewDatastore(path, &badger.DefaultOptions)
-		case "flatfs":
-			sFn, ok := dCfg["shardFunc"].(string)
-			if !ok {
-				sFn = "/repo/flatfs/shard/v1/next-to-last/2"
-			}
-			sn, ok := dCfg["sync"].(bool)
-			if !ok {
-				sn = true
-			}
-			sf, e := flatfs.ParseShardFunc(sFn)
-			if e != nil {
-				retErr = e
-				return
-			}
-			newDs, err = flatfs.CreateOrOpen(path, sf, sn)
-		default:
-			retErr = errors.New("Invalid datastore type")
-			return
-		}
+		newDs, err := ctor.(DatastoreConstructor)(path, dCfg)
 		if err != nil {
 			retErr = err
 			return
@@ -1,3 +1,9 @@
 package fsrepo
 
+import "time"
+
 var Opener = opener
+
+func SetWatchInterval(d time.Duration) {
+	watchInterval = d
+}
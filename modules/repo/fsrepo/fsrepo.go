@@ -4,18 +4,24 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
+	logger "github.com/ipfs/go-log/v2"
 	ci "github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/plexsysio/gkvstore"
 	ipfsdsStore "github.com/plexsysio/gkvstore-ipfsds"
 	"github.com/plexsysio/go-msuite/modules/config"
-	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	"github.com/plexsysio/go-msuite/modules/config/loader"
+	"github.com/plexsysio/go-msuite/modules/config/schema"
+	tomlConf "github.com/plexsysio/go-msuite/modules/config/toml"
+	yamlConf "github.com/plexsysio/go-msuite/modules/config/yaml"
 	"github.com/plexsysio/go-msuite/modules/repo"
 	"github.com/plexsysio/go-msuite/utils"
 )
@@ -68,21 +74,53 @@ var (
 	pkgLock     sync.Mutex
 	opener      = &repoOpener{ActiveMap: make(map[string]*ActiveRepo)}
 	storePrefix = ds.NewKey("s")
+	// watchInterval is the interval used to check the config file for updates
+	watchInterval = 5 * time.Second
 )
 
+var log = logger.Logger("fsrepo")
+
 type fsRepo struct {
-	path    string
-	cfg     config.Config
-	rootDS  ds.Batching
-	kvStore gkvstore.Store
+	path     string
+	cfgPath  string
+	cfgMod   time.Time
+	cfg      config.Config
+	notifier *repo.Notifier
+	stopWtch chan struct{}
+	rootDS   ds.Batching
+	kvStore  gkvstore.Store
 }
 
 func datastorePath(root string) string {
 	return filepath.Join(root, "datastore")
 }
 
-func configPath(root string) string {
-	return filepath.Join(root, "config.json")
+// configFiles are the config file names supported at the root of the repo. The
+// first one found is used
+var configFiles = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// findConfig returns the path of the config file present in the repo
+func findConfig(root string) (string, bool) {
+	for _, f := range configFiles {
+		p := filepath.Join(root, f)
+		if utils.Exists(p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// configPath returns the path used to store the config. The file format is
+// decided by the config implementation used
+func configPath(root string, c config.Config) string {
+	switch c.(type) {
+	case *yamlConf.YamlConfig:
+		return filepath.Join(root, "config.yaml")
+	case *tomlConf.TomlConfig:
+		return filepath.Join(root, "config.toml")
+	default:
+		return filepath.Join(root, "config.json")
+	}
 }
 
 func initRepo(path string) error {
@@ -125,7 +163,8 @@ func IsInitialized(path string) bool {
 }
 
 func isInitialized(path string) bool {
-	return utils.Exists(configPath(path))
+	_, found := findConfig(path)
+	return found
 }
 
 func Init(path string, c config.Config) error {
@@ -150,7 +189,7 @@ func Init(path string, c config.Config) error {
 	if err != nil {
 		return fmt.Errorf("failed reading config %w", err)
 	}
-	err = utils.WriteToFile(confRdr, configPath(path))
+	err = utils.WriteToFile(confRdr, configPath(path, c))
 	if err != nil {
 		return fmt.Errorf("failed creating config %w", err)
 	}
@@ -158,17 +197,75 @@ func Init(path string, c config.Config) error {
 }
 
 func (f *fsRepo) openConfig() error {
-	if !utils.Exists(configPath(f.path)) {
+	cfgPath, found := findConfig(f.path)
+	if !found {
 		return errors.New("config is absent")
 	}
-	cfg, err := jsonConf.FromFile(configPath(f.path))
+	fi, err := os.Stat(cfgPath)
+	if err != nil {
+		return err
+	}
+	cfg, err := loader.FromFile(cfgPath)
 	if err != nil {
 		return err
 	}
+	f.cfgPath = cfgPath
+	f.cfgMod = fi.ModTime()
 	f.cfg = cfg
+	f.notifier = repo.NewNotifier(cfg)
 	return nil
 }
 
+// watchConfig polls the config file for modifications. If the file was updated
+// outside of SetConfig, the config is reloaded and the changes are published
+func (f *fsRepo) watchConfig() {
+	t := time.NewTicker(watchInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-f.stopWtch:
+			return
+		case <-t.C:
+		}
+		cfg, updated := f.reloadConfig()
+		if updated {
+			f.notifier.Update(cfg)
+		}
+	}
+}
+
+func (f *fsRepo) reloadConfig() (config.Config, bool) {
+	pkgLock.Lock()
+	defer pkgLock.Unlock()
+
+	select {
+	case <-f.stopWtch:
+		return nil, false
+	default:
+	}
+
+	fi, err := os.Stat(f.cfgPath)
+	if err != nil || fi.ModTime().Equal(f.cfgMod) {
+		return nil, false
+	}
+	f.cfgMod = fi.ModTime()
+	cfg, err := loader.FromFile(f.cfgPath)
+	if err != nil {
+		log.Warn("failed reloading config, using older config", err)
+		return nil, false
+	}
+	// Invalid config is not applied, so that the running node and the next
+	// restart are not affected by a bad edit
+	if err := schema.Validate(cfg, ValidateMounts); err != nil {
+		log.Warn("invalid config on reload, using older config", err)
+		return nil, false
+	}
+	log.Info("reloaded config", f.cfgPath)
+	f.cfg = cfg
+	return cfg, true
+}
+
 func (f *fsRepo) openDatastore() error {
 	if !utils.Exists(datastorePath(f.path)) {
 		return utils.MkdirIfNotExists(datastorePath(f.path))
@@ -210,6 +307,8 @@ func open(path string) (repo.Repo, error) {
 	if err := r.openStore(); err != nil {
 		return nil, fmt.Errorf("failed opening KV store %w", err)
 	}
+	r.stopWtch = make(chan struct{})
+	go r.watchConfig()
 	return r, nil
 }
 
@@ -235,14 +334,43 @@ func (f *fsRepo) Config() config.Config {
 }
 
 func (f *fsRepo) SetConfig(c config.Config) error {
+	err := f.setConfig(c)
+	if err != nil {
+		return err
+	}
+	f.notifier.Update(c)
+	return nil
+}
+
+func (f *fsRepo) setConfig(c config.Config) error {
 	pkgLock.Lock()
 	defer pkgLock.Unlock()
-	f.cfg = c
+
 	confRdr, err := c.Reader()
 	if err != nil {
 		return err
 	}
-	return utils.WriteToFile(confRdr, configPath(f.path))
+	newPath := configPath(f.path, c)
+	err = utils.WriteToFile(confRdr, newPath)
+	if err != nil {
+		return err
+	}
+	// If the format of the config changed, remove the older file
+	if newPath != f.cfgPath {
+		if err := os.Remove(f.cfgPath); err != nil {
+			return err
+		}
+	}
+	if fi, err := os.Stat(newPath); err == nil {
+		f.cfgMod = fi.ModTime()
+	}
+	f.cfgPath = newPath
+	f.cfg = c
+	return nil
+}
+
+func (f *fsRepo) Subscribe(handler repo.ConfigHandler, keys ...string) func() {
+	return f.notifier.Subscribe(handler, keys...)
 }
 
 func (f *fsRepo) Store() gkvstore.Store {
@@ -265,6 +393,8 @@ func (f *fsRepo) Close() error {
 }
 
 func (f *fsRepo) close() error {
+	close(f.stopWtch)
+
 	var err *multierror.Error
 	if f.kvStore != nil {
 		e := f.kvStore.Close()
@@ -2,11 +2,18 @@ package fsrepo_test
 
 import (
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/plexsysio/go-msuite/modules/config"
 	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	tomlConf "github.com/plexsysio/go-msuite/modules/config/toml"
+	yamlConf "github.com/plexsysio/go-msuite/modules/config/yaml"
+	"github.com/plexsysio/go-msuite/modules/repo"
 	"github.com/plexsysio/go-msuite/modules/repo/fsrepo"
+	"github.com/plexsysio/go-msuite/utils"
 )
 
 func TestInit(t *testing.T) {
@@ -231,3 +238,204 @@ func TestInvalidDatastoreConfig(t *testing.T) {
 		t.Fatal("able to create repo with incorrect config", cfg.String())
 	}
 }
+
+func TestConfigFormats(t *testing.T) {
+	defer func() {
+		os.RemoveAll(".testrepo")
+	}()
+
+	for _, tc := range []struct {
+		file string
+		cfg  config.Config
+	}{
+		{file: "config.yaml", cfg: yamlConf.DefaultConfig()},
+		{file: "config.toml", cfg: tomlConf.DefaultConfig()},
+	} {
+		tc.cfg.Set("RootPath", ".testrepo")
+		tc.cfg.Set("TestAdd", "some value")
+
+		r, err := fsrepo.CreateOrOpen(tc.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(filepath.Join(".testrepo", tc.file)); err != nil {
+			t.Fatal("expected config file", tc.file, err)
+		}
+
+		// Switch to JSON config, the older file should be replaced
+		jc := jsonConf.DefaultConfig()
+		jc.Set("TestAdd", "new value")
+		err = r.SetConfig(jc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(filepath.Join(".testrepo", tc.file)); err == nil {
+			t.Fatal("expected older config file to be removed", tc.file)
+		}
+
+		err = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err = fsrepo.Open(".testrepo")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var testAdd string
+		found := r.Config().Get("TestAdd", &testAdd)
+		if !found || testAdd != "new value" {
+			t.Fatal("unexpected config on reopen", found, testAdd)
+		}
+
+		err = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.RemoveAll(".testrepo")
+	}
+}
+
+func TestConfigWatch(t *testing.T) {
+	defer func() {
+		os.RemoveAll(".testrepo")
+	}()
+
+	fsrepo.SetWatchInterval(50 * time.Millisecond)
+
+	cfg := jsonConf.DefaultConfig()
+	cfg.Set("RootPath", ".testrepo")
+	cfg.Set("StaticAddresses", map[string]string{"svc1": "localhost:10000"})
+
+	r, err := fsrepo.CreateOrOpen(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	changes := make(chan repo.ConfigChange, 10)
+	unsub := repo.Subscribe(r, func(ch repo.ConfigChange) {
+		changes <- ch
+	}, "StaticAddresses", "TestAdd")
+
+	getChange := func() repo.ConfigChange {
+		t.Helper()
+		select {
+		case ch := <-changes:
+			return ch
+		case <-time.After(time.Second):
+			t.Fatal("expected config change")
+		}
+		return repo.ConfigChange{}
+	}
+
+	// Updates using SetConfig
+	newCfg := jsonConf.DefaultConfig()
+	newCfg.Set("RootPath", ".testrepo")
+	newCfg.Set("StaticAddresses", map[string]string{"svc1": "localhost:10001"})
+	newCfg.Set("Unwatched", true)
+	err = r.SetConfig(newCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := getChange()
+	oldAddrs, newAddrs := map[string]string{}, map[string]string{}
+	if ch.Key != "StaticAddresses" || !ch.Old(&oldAddrs) || !ch.New(&newAddrs) {
+		t.Fatal("unexpected change", ch)
+	}
+	if oldAddrs["svc1"] != "localhost:10000" || newAddrs["svc1"] != "localhost:10001" {
+		t.Fatal("unexpected values in change", oldAddrs, newAddrs)
+	}
+
+	// Updates to the file directly
+	time.Sleep(10 * time.Millisecond)
+	fileCfg := jsonConf.DefaultConfig()
+	fileCfg.Set("RootPath", ".testrepo")
+	fileCfg.Set("TestAdd", "some value")
+	rdr, err := fileCfg.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = utils.WriteToFile(rdr, filepath.Join(".testrepo", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]repo.ConfigChange{}
+	for i := 0; i < 2; i++ {
+		ch := getChange()
+		seen[ch.Key] = ch
+	}
+	if !seen["StaticAddresses"].Removed() {
+		t.Fatal("expected static addresses to be removed")
+	}
+	var testAdd string
+	if !seen["TestAdd"].New(&testAdd) || testAdd != "some value" {
+		t.Fatal("unexpected value for new key", testAdd)
+	}
+	if !r.Config().Get("TestAdd", &testAdd) {
+		t.Fatal("expected config to be reloaded")
+	}
+
+	unsub()
+	err = r.SetConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ch := <-changes:
+		t.Fatal("unexpected change after unsubscribe", ch)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestConfigWatchInvalid(t *testing.T) {
+	defer func() {
+		os.RemoveAll(".testrepo")
+	}()
+
+	fsrepo.SetWatchInterval(50 * time.Millisecond)
+
+	cfg := jsonConf.DefaultConfig()
+	cfg.Set("RootPath", ".testrepo")
+	cfg.Set("StaticAddresses", map[string]string{"svc1": "localhost:10000"})
+
+	r, err := fsrepo.CreateOrOpen(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	changes := make(chan repo.ConfigChange, 10)
+	defer repo.Subscribe(r, func(ch repo.ConfigChange) {
+		changes <- ch
+	})()
+
+	time.Sleep(10 * time.Millisecond)
+	fileCfg := jsonConf.DefaultConfig()
+	fileCfg.Set("RootPath", ".testrepo")
+	fileCfg.Set("StaticAddresses", []string{"localhost:10001"})
+	rdr, err := fileCfg.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = utils.WriteToFile(rdr, filepath.Join(".testrepo", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ch := <-changes:
+		t.Fatal("unexpected change for invalid config", ch)
+	case <-time.After(200 * time.Millisecond):
+	}
+	addrs := map[string]string{}
+	if !r.Config().Get("StaticAddresses", &addrs) || addrs["svc1"] != "localhost:10000" {
+		t.Fatal("expected older config to be used", addrs)
+	}
+}
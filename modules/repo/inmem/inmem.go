@@ -16,12 +16,14 @@ import (
 
 type inmemRepo struct {
 	c  config.Config
+	n  *repo.Notifier
 	ds datastore.Batching
 	st gkvstore.Store
 }
 
 func initIdentity(c config.Config) error {
-	if c.Get("Identity", make(map[string]interface{})) {
+	existing := map[string]interface{}{}
+	if c.Get("Identity", &existing) {
 		return nil
 	}
 	sk, pk, err := crypto.GenerateKeyPair(crypto.Ed25519, 2048)
@@ -53,6 +55,7 @@ func CreateOrOpen(c config.Config) (repo.Repo, error) {
 	st := ipfsdsStore.New(namespace.Wrap(ds, datastore.NewKey("/kv")))
 	return &inmemRepo{
 		c:  c,
+		n:  repo.NewNotifier(c),
 		ds: ds,
 		st: st,
 	}, nil
@@ -64,9 +67,14 @@ func (i *inmemRepo) Config() config.Config {
 
 func (i *inmemRepo) SetConfig(c config.Config) error {
 	i.c = c
+	i.n.Update(c)
 	return nil
 }
 
+func (i *inmemRepo) Subscribe(handler repo.ConfigHandler, keys ...string) func() {
+	return i.n.Subscribe(handler, keys...)
+}
+
 func (i *inmemRepo) Datastore() datastore.Batching {
 	return i.ds
 }
@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+)
+
+// ConfigChange is published when the value of a config key is updated
+type ConfigChange struct {
+	Key string
+
+	old, new interface{}
+	removed  bool
+}
+
+// Old reads the previous value of the key into val. It returns false if the key
+// was absent earlier
+func (c ConfigChange) Old(val interface{}) bool {
+	return decode(c.old, val)
+}
+
+// New reads the updated value of the key into val. It returns false if the key
+// was removed
+func (c ConfigChange) New(val interface{}) bool {
+	if c.removed {
+		return false
+	}
+	return decode(c.new, val)
+}
+
+// Removed returns true if the key is no longer present in the config
+func (c ConfigChange) Removed() bool {
+	return c.removed
+}
+
+func decode(from, to interface{}) bool {
+	if from == nil {
+		return false
+	}
+	buf, err := json.Marshal(from)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(buf, to) == nil
+}
+
+// ConfigHandler is called with the changes for the subscribed keys. Handlers are
+// called synchronously, so they should not block
+type ConfigHandler func(ConfigChange)
+
+type subscription struct {
+	keys    map[string]struct{}
+	handler ConfigHandler
+}
+
+// Notifier can be used by repo implementations to publish config changes. It
+// keeps a snapshot of the last config and publishes the keys which differ on
+// each update
+type Notifier struct {
+	mtx    sync.Mutex
+	last   map[string]interface{}
+	subs   map[int]*subscription
+	nextID int
+}
+
+func NewNotifier(c config.Config) *Notifier {
+	return &Notifier{
+		last: snapshot(c),
+		subs: make(map[int]*subscription),
+	}
+}
+
+// Subscribe registers the handler for the keys. If no keys are provided, the
+// handler is called for all the changes. The returned func can be used to
+// unsubscribe
+func (n *Notifier) Subscribe(handler ConfigHandler, keys ...string) func() {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	sub := &subscription{
+		keys:    make(map[string]struct{}),
+		handler: handler,
+	}
+	for _, k := range keys {
+		sub.keys[k] = struct{}{}
+	}
+	id := n.nextID
+	n.nextID++
+	n.subs[id] = sub
+
+	return func() {
+		n.mtx.Lock()
+		defer n.mtx.Unlock()
+
+		delete(n.subs, id)
+	}
+}
+
+// Update compares the config with the last snapshot and notifies the subscribers
+// of all the keys changed
+func (n *Notifier) Update(c config.Config) {
+	n.mtx.Lock()
+	next := snapshot(c)
+	changes := diff(n.last, next)
+	n.last = next
+	subs := make([]*subscription, 0, len(n.subs))
+	for _, s := range n.subs {
+		subs = append(subs, s)
+	}
+	n.mtx.Unlock()
+
+	for _, ch := range changes {
+		for _, s := range subs {
+			if _, ok := s.keys[ch.Key]; ok || len(s.keys) == 0 {
+				s.handler(ch)
+			}
+		}
+	}
+}
+
+// snapshot copies all the values in the config in a generic form, so that
+// later updates to the config object do not modify it
+func snapshot(c config.Config) map[string]interface{} {
+	vals := make(map[string]interface{})
+	for _, k := range settings.ConfigKeys(c) {
+		var v interface{}
+		if c.Get(k, &v) {
+			vals[k] = v
+		}
+	}
+	return vals
+}
+
+func diff(old, new map[string]interface{}) []ConfigChange {
+	changes := []ConfigChange{}
+	for k, v := range new {
+		ov, found := old[k]
+		if !found || !reflect.DeepEqual(ov, v) {
+			changes = append(changes, ConfigChange{Key: k, old: ov, new: v})
+		}
+	}
+	for k, v := range old {
+		if _, found := new[k]; !found {
+			changes = append(changes, ConfigChange{Key: k, old: v, removed: true})
+		}
+	}
+	return changes
+}
@@ -19,3 +19,21 @@ type Repo interface {
 	Status() interface{}
 	io.Closer
 }
+
+// Subscriber is implemented by the repos publishing the config changes. It is
+// kept out of Repo, so that the existing implementations do not break
+type Subscriber interface {
+	// Subscribe registers a handler for updates on the config keys. If no keys
+	// are provided, all updates are notified. The returned func unsubscribes
+	Subscribe(ConfigHandler, ...string) func()
+}
+
+// Subscribe registers the handler if the repo publishes the config changes. For
+// other repos, the handler is never called
+func Subscribe(r Repo, handler ConfigHandler, keys ...string) func() {
+	s, ok := r.(Subscriber)
+	if !ok {
+		return func() {}
+	}
+	return s.Subscribe(handler, keys...)
+}
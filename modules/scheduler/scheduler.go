@@ -0,0 +1,401 @@
+// Package scheduler runs jobs periodically on the taskmanager. Jobs are scheduled
+// using cron expressions or fixed intervals. Singleton jobs are guarded by the
+// distributed locker, so that each firing runs on only one of the nodes sharing
+// the locker.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	logger "github.com/ipfs/go-log/v2"
+	"github.com/plexsysio/dLocker"
+	"github.com/plexsysio/go-msuite/modules/diag/status"
+	"github.com/plexsysio/taskmanager"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/fx"
+)
+
+var log = logger.Logger("scheduler")
+
+var (
+	// ErrAlreadyExists is returned if a job with the same name is scheduled
+	ErrAlreadyExists = errors.New("job with same name already exists")
+	// ErrNotFound is returned if the job is not scheduled
+	ErrNotFound = errors.New("job not found")
+	// ErrLockerRequired is returned for singleton jobs if the locker is not
+	// configured
+	ErrLockerRequired = errors.New("singleton jobs need the locker")
+)
+
+// maxLockWait is the longest a node waits for the lock of a singleton job
+const maxLockWait = time.Second
+
+// Job is the function run on every firing. The context is cancelled if the job
+// times out or the node is stopped
+type Job func(context.Context) error
+
+type Option func(*job)
+
+// Singleton runs each firing of the job on only one of the nodes. The node which
+// gets the lock holds it till just before the next firing, so the clocks of the
+// nodes should be in sync
+func Singleton() Option {
+	return func(j *job) {
+		j.singleton = true
+	}
+}
+
+// WithTimeout cancels the job if it runs longer than the timeout
+func WithTimeout(d time.Duration) Option {
+	return func(j *job) {
+		j.timeout = d
+	}
+}
+
+// Scheduler schedules the jobs. Firings are skipped if the previous run of the
+// job is still in progress
+type Scheduler interface {
+	// Cron schedules the job using a standard cron expression with 5 fields. The
+	// descriptors like @hourly or @every 1m are also supported
+	Cron(name, spec string, j Job, opts ...Option) error
+	// Every schedules the job at a fixed interval
+	Every(name string, interval time.Duration, j Job, opts ...Option) error
+	// Remove unschedules the job. A run in progress is not stopped
+	Remove(name string) error
+}
+
+// JobStatus is the status of a job reported on the diagnostic endpoint. Skipped
+// counts the firings which were not run as the previous run was in progress or
+// another node ran it
+type JobStatus struct {
+	Schedule     string
+	Singleton    bool `json:",omitempty"`
+	NextRun      time.Time
+	LastRun      *time.Time `json:",omitempty"`
+	LastDuration string     `json:",omitempty"`
+	LastError    string     `json:",omitempty"`
+	Runs         int
+	Failures     int
+	Skipped      int
+}
+
+type job struct {
+	name      string
+	spec      string
+	sched     cron.Schedule
+	fn        Job
+	singleton bool
+	timeout   time.Duration
+
+	// Following are protected by the Runner lock
+	next time.Time
+	st   JobStatus
+}
+
+// Runner is the Scheduler running the jobs on the taskmanager
+type Runner struct {
+	tm *taskmanager.TaskManager
+	lk dLocker.DLocker
+
+	mtx     sync.Mutex
+	jobs    map[string]*job
+	changed chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+	started bool
+	// draining stops the firings once the node starts draining
+	draining <-chan struct{}
+}
+
+// NewRunner returns the scheduler. The locker is optional and is required only
+// for the singleton jobs. Jobs can be scheduled before the runner is started
+func NewRunner(tm *taskmanager.TaskManager, lk dLocker.DLocker) *Runner {
+	return &Runner{
+		tm:      tm,
+		lk:      lk,
+		jobs:    make(map[string]*job),
+		changed: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// New is the constructor used by the node. The runner is started along with the
+// node and no jobs are fired once the node starts draining
+func New(
+	lc fx.Lifecycle,
+	tm *taskmanager.TaskManager,
+	st status.Manager,
+	lk dLocker.DLocker,
+) Scheduler {
+	r := NewRunner(tm, lk)
+	r.draining = st.Draining()
+	lc.Append(fx.Hook{
+		OnStart: func(_ context.Context) error {
+			return r.Start()
+		},
+		OnStop: func(ctx context.Context) error {
+			log.Debugf("stopping scheduler")
+			defer log.Debugf("stopped scheduler")
+			return r.Stop(ctx)
+		},
+	})
+	st.AddReporter("Scheduler", r)
+	return r
+}
+
+func (r *Runner) Cron(name, spec string, j Job, opts ...Option) error {
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+	return r.add(name, spec, sched, j, opts...)
+}
+
+func (r *Runner) Every(name string, interval time.Duration, j Job, opts ...Option) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid interval %s", interval)
+	}
+	return r.add(name, "@every "+interval.String(), every(interval), j, opts...)
+}
+
+// every is the fixed interval schedule. Unlike the cron @every, the interval is
+// not rounded to seconds
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+func (r *Runner) add(name, spec string, sched cron.Schedule, fn Job, opts ...Option) error {
+	j := &job{name: name, spec: spec, sched: sched, fn: fn}
+	for _, opt := range opts {
+		opt(j)
+	}
+	if j.singleton && r.lk == nil {
+		return ErrLockerRequired
+	}
+	j.next = sched.Next(time.Now())
+	j.st = JobStatus{Schedule: spec, Singleton: j.singleton}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, found := r.jobs[name]; found {
+		return ErrAlreadyExists
+	}
+	r.jobs[name] = j
+	r.notify()
+	return nil
+}
+
+func (r *Runner) Remove(name string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, found := r.jobs[name]; !found {
+		return ErrNotFound
+	}
+	delete(r.jobs, name)
+	r.notify()
+	return nil
+}
+
+// notify wakes up the scheduling loop. It should be called with the lock held
+func (r *Runner) notify() {
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the scheduling loop on the taskmanager
+func (r *Runner) Start() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.started {
+		return nil
+	}
+	_, err := r.tm.GoFunc("scheduler", r.run)
+	if err != nil {
+		return err
+	}
+	r.started = true
+	return nil
+}
+
+// Stop ends the scheduling loop. The jobs already running are stopped along with
+// the taskmanager
+func (r *Runner) Stop(ctx context.Context) error {
+	r.mtx.Lock()
+	started := r.started
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	r.mtx.Unlock()
+
+	if !started {
+		return nil
+	}
+	select {
+	case <-r.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Runner) run(ctx context.Context) error {
+	defer close(r.stopped)
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		now := time.Now()
+		next := r.fireDue(now)
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if next.IsZero() {
+			// No jobs, wait for the jobs to be added
+			timer.Reset(time.Hour)
+		} else {
+			timer.Reset(next.Sub(now))
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.stop:
+			return nil
+		case <-r.draining:
+			return nil
+		case <-r.changed:
+		case <-timer.C:
+		}
+	}
+}
+
+// fireDue dispatches the jobs which are due and returns the time of the next
+// firing. It is zero if there are no jobs
+func (r *Runner) fireDue(now time.Time) time.Time {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	var earliest time.Time
+	for _, j := range r.jobs {
+		if !j.next.After(now) {
+			next := j.sched.Next(now)
+			r.fire(j, next)
+			j.next = next
+		}
+		if earliest.IsZero() || j.next.Before(earliest) {
+			earliest = j.next
+		}
+	}
+	return earliest
+}
+
+// fire runs the job on the taskmanager. It should be called with the lock held
+func (r *Runner) fire(j *job, next time.Time) {
+	_, err := r.tm.GoFunc("scheduler/"+j.name, func(ctx context.Context) error {
+		return r.exec(ctx, j, next)
+	})
+	if err != nil {
+		log.Warnf("skipped firing of %s: %v", j.name, err)
+		j.st.Skipped++
+	}
+}
+
+func (r *Runner) exec(ctx context.Context, j *job, next time.Time) error {
+	if j.singleton {
+		// Nodes wait only a part of the time till the next firing, so that the
+		// lock is not taken for the next firing
+		wait := time.Until(next) / 10
+		if wait > maxLockWait {
+			wait = maxLockWait
+		}
+		unlock, err := r.lk.TryLock(ctx, "scheduler/"+j.name, wait)
+		if err != nil {
+			log.Debugf("firing of %s taken by another node: %v", j.name, err)
+			r.mtx.Lock()
+			j.st.Skipped++
+			r.mtx.Unlock()
+			return nil
+		}
+		// The lock is held till just before the next firing, so that the nodes
+		// which are late do not run the same firing again
+		defer func() {
+			if hold := time.Until(next) - wait; hold > 0 {
+				time.AfterFunc(hold, unlock)
+				return
+			}
+			unlock()
+		}()
+	}
+	if j.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := j.fn(ctx)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	j.st.Runs++
+	j.st.LastRun = &start
+	j.st.LastDuration = time.Since(start).String()
+	j.st.LastError = ""
+	if err != nil {
+		j.st.Failures++
+		j.st.LastError = err.Error()
+	}
+	return err
+}
+
+// Jobs returns the status of the jobs scheduled
+func (r *Runner) Jobs() map[string]JobStatus {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	sts := make(map[string]JobStatus, len(r.jobs))
+	for name, j := range r.jobs {
+		st := j.st
+		st.NextRun = j.next
+		sts[name] = st
+	}
+	return sts
+}
+
+func (r *Runner) Status() interface{} {
+	sts := r.Jobs()
+	if len(sts) == 0 {
+		return "no jobs scheduled"
+	}
+	return sts
+}
+
+// Health is degraded if the last run of some job failed
+func (r *Runner) Health() status.Health {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, j := range r.jobs {
+		if j.st.LastError != "" {
+			return status.Degraded
+		}
+	}
+	return status.OK
+}
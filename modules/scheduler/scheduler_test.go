@@ -0,0 +1,170 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	inmemlock "github.com/plexsysio/dLocker/handlers/memlock"
+	"github.com/plexsysio/go-msuite/modules/scheduler"
+	"github.com/plexsysio/taskmanager"
+)
+
+type counter struct {
+	mtx sync.Mutex
+	n   int
+}
+
+func (c *counter) inc(context.Context) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.n++
+	return nil
+}
+
+func (c *counter) count() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.n
+}
+
+func newRunner(t *testing.T) *scheduler.Runner {
+	t.Helper()
+
+	tm := taskmanager.New(5, 10, 15*time.Second)
+	r := scheduler.NewRunner(tm, inmemlock.NewLocker())
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := r.Stop(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		tm.Stop()
+	})
+	return r
+}
+
+func TestInterval(t *testing.T) {
+	r := newRunner(t)
+
+	c := &counter{}
+	if err := r.Every("count", 50*time.Millisecond, c.inc); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Every("count", time.Second, c.inc); !errors.Is(err, scheduler.ErrAlreadyExists) {
+		t.Fatal("expected already exists", err)
+	}
+	if err := r.Every("fail", 50*time.Millisecond, func(context.Context) error {
+		return errors.New("dummy error")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Cron("invalid", "* *", c.inc); err == nil {
+		t.Fatal("expected invalid cron expression to fail")
+	}
+	if err := r.Cron("hourly", "@hourly", c.inc); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	if c.count() < 3 {
+		t.Fatal("expected job to run periodically", c.count())
+	}
+	sts := r.Jobs()
+	if sts["count"].Runs < 3 || sts["count"].LastRun == nil || sts["count"].LastError != "" {
+		t.Fatal("incorrect status", sts["count"])
+	}
+	if sts["fail"].Failures == 0 || sts["fail"].LastError != "dummy error" {
+		t.Fatal("incorrect status of failed job", sts["fail"])
+	}
+	if sts["hourly"].Runs != 0 || time.Until(sts["hourly"].NextRun) > time.Hour {
+		t.Fatal("incorrect status of hourly job", sts["hourly"])
+	}
+	if r.Health() != "degraded" {
+		t.Fatal("expected degraded health", r.Health())
+	}
+
+	if err := r.Remove("count"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Remove("count"); !errors.Is(err, scheduler.ErrNotFound) {
+		t.Fatal("expected not found", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	n := c.count()
+	time.Sleep(200 * time.Millisecond)
+	if c.count() != n {
+		t.Fatal("removed job still running")
+	}
+}
+
+func TestSkipOverlapping(t *testing.T) {
+	r := newRunner(t)
+
+	c := &counter{}
+	err := r.Every("slow", 20*time.Millisecond, func(ctx context.Context) error {
+		_ = c.inc(ctx)
+		<-ctx.Done()
+		return ctx.Err()
+	}, scheduler.WithTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	if c.count() != 1 {
+		t.Fatal("expected only one run in progress", c.count())
+	}
+	if r.Jobs()["slow"].Skipped == 0 {
+		t.Fatal("expected firings to be skipped", r.Jobs()["slow"])
+	}
+}
+
+func TestSingleton(t *testing.T) {
+	// The runners share the locker like nodes of a cluster
+	lk := inmemlock.NewLocker()
+	c := &counter{}
+
+	var runners []*scheduler.Runner
+	for i := 0; i < 3; i++ {
+		tm := taskmanager.New(5, 10, 15*time.Second)
+		r := scheduler.NewRunner(tm, lk)
+		err := r.Every("singleton", 200*time.Millisecond, c.inc, scheduler.Singleton())
+		if err != nil {
+			t.Fatal(err)
+		}
+		runners = append(runners, r)
+		t.Cleanup(func() {
+			_ = r.Stop(context.Background())
+			tm.Stop()
+		})
+	}
+	for _, r := range runners {
+		if err := r.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	runs, skipped := 0, 0
+	for _, r := range runners {
+		runs += r.Jobs()["singleton"].Runs
+		skipped += r.Jobs()["singleton"].Skipped
+	}
+	if runs == 0 || runs != c.count() || skipped != 2*runs {
+		t.Fatal("expected each firing to run once", runs, skipped, c.count())
+	}
+
+	r := scheduler.NewRunner(taskmanager.New(0, 1, time.Second), nil)
+	err := r.Every("singleton", time.Second, c.inc, scheduler.Singleton())
+	if !errors.Is(err, scheduler.ErrLockerRequired) {
+		t.Fatal("expected locker required", err)
+	}
+}
@@ -10,7 +10,7 @@ import (
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	antsdb "github.com/plexsysio/ants-db"
 	store "github.com/plexsysio/gkvstore"
-	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
 )
 
 const defaultRootNs = "msuite"
@@ -25,18 +25,15 @@ type Provider interface {
 }
 
 func NewSharedStoreProvider(
-	cfg config.Config,
+	p2pCfg settings.P2P,
 	ds datastore.Batching,
 	h host.Host,
 	dht routing.Routing,
 	ps *pubsub.PubSub,
 ) (Provider, error) {
 
-	var (
-		rootNs string
-		err    error
-	)
-	_ = cfg.Get("SharedStoreNs", &rootNs)
+	var err error
+	rootNs := p2pCfg.SharedStoreNs
 	if rootNs == "" {
 		rootNs = defaultRootNs
 	}
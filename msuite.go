@@ -2,17 +2,24 @@ package msuite
 
 import (
 	"encoding/base64"
+	"time"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/plexsysio/go-msuite/core"
 	"github.com/plexsysio/go-msuite/modules/config"
 	jsonConf "github.com/plexsysio/go-msuite/modules/config/json"
+	"github.com/plexsysio/go-msuite/modules/config/loader"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
 	"github.com/plexsysio/go-msuite/modules/node"
+	"go.uber.org/fx"
 )
 
 type BuildCfg struct {
 	startupCfg config.Config
+	errs       *multierror.Error
+	fxOpts     []fx.Option
 }
 
 type Option func(c *BuildCfg)
@@ -40,6 +47,39 @@ func WithAuth(secret string) Option {
 	}
 }
 
+// WithServiceRole mints tokens with the role for the outgoing gRPC calls of the
+// node which do not forward an incoming token
+func WithServiceRole(role string) Option {
+	return func(c *BuildCfg) {
+		c.startupCfg.Set("ServiceRole", role)
+	}
+}
+
+// WithTLS serves the TCP gRPC listener and the HTTP server using TLS. If the
+// clientCA is provided, the clients are required to present certificates signed
+// by it and the static clients verify the servers using it. The files are
+// reloaded once they change
+func WithTLS(cert, key, clientCA string) Option {
+	return func(c *BuildCfg) {
+		c.startupCfg.Set("UseTLS", true)
+		c.startupCfg.Set("TLSCert", cert)
+		c.startupCfg.Set("TLSKey", key)
+		if clientCA != "" {
+			c.startupCfg.Set("TLSClientCA", clientCA)
+		}
+	}
+}
+
+// WithTLSIdentity serves the TCP gRPC listener and the HTTP server using TLS with
+// a certificate derived from the libp2p identity of the node. The static clients
+// verify the servers using the peer ID in the addresses given as
+// host:port/p2p/<peer ID>
+func WithTLSIdentity() Option {
+	return func(c *BuildCfg) {
+		c.startupCfg.Set("UseTLSIdentity", true)
+	}
+}
+
 func WithTracing(name, host string) Option {
 	return func(c *BuildCfg) {
 		c.startupCfg.Set("UseTracing", true)
@@ -125,6 +165,22 @@ func WithTaskManager(min, max int) Option {
 	}
 }
 
+// WithDrain configures the graceful shutdown. On stop, the node is marked as not
+// ready and stops advertising its services. The servers are stopped after the
+// delay, and the pending requests and tasks get the timeout to finish before
+// they are stopped forcefully. Durations are rounded up to seconds
+func WithDrain(delay, timeout time.Duration) Option {
+	seconds := func(d time.Duration) int {
+		return int((d + time.Second - 1) / time.Second)
+	}
+	return func(c *BuildCfg) {
+		c.startupCfg.Set("Drain", map[string]int{
+			"Delay":   seconds(delay),
+			"Timeout": seconds(timeout),
+		})
+	}
+}
+
 func WithPrometheus(useLatency bool) Option {
 	return func(c *BuildCfg) {
 		c.startupCfg.Set("UsePrometheus", true)
@@ -134,6 +190,20 @@ func WithPrometheus(useLatency bool) Option {
 	}
 }
 
+// WithRateLimit adds a named rate limit on the gRPC and HTTP servers. Match is the
+// gRPC method or HTTP path, ending with "*" to match the prefix. By is one of the
+// settings.By* keys identifying the callers. The limits can be updated later
+// using the "RateLimits" config key
+func WithRateLimit(name, match, by string, rate float64, burst int) Option {
+	return func(c *BuildCfg) {
+		c.startupCfg.Set("UseRateLimit", true)
+		limits := settings.RateLimits{}
+		_ = c.startupCfg.Get("RateLimits", &limits)
+		limits[name] = settings.RateLimit{Match: match, By: by, Rate: rate, Burst: burst}
+		c.startupCfg.Set("RateLimits", limits)
+	}
+}
+
 func WithStaticDiscovery(svcAddrs map[string]string) Option {
 	return func(c *BuildCfg) {
 		c.startupCfg.Set("UseStaticDiscovery", true)
@@ -141,12 +211,76 @@ func WithStaticDiscovery(svcAddrs map[string]string) Option {
 	}
 }
 
+// WithClientPolicy sets the timeout, retry and circuit breaker policy of the gRPC
+// clients of the service. The policy of "*" is used for the services without
+// their own policy
+func WithClientPolicy(svc string, p settings.ClientPolicy) Option {
+	return func(c *BuildCfg) {
+		policies := settings.ClientPolicies{}
+		_ = c.startupCfg.Get("ClientPolicies", &policies)
+		policies[svc] = p
+		c.startupCfg.Set("ClientPolicies", policies)
+	}
+}
+
+// WithLoadBalancer balances the libp2p gRPC clients across all the peers of the
+// service using settings.RoundRobin or settings.LeastRequest. The peers are
+// discovered again every refresh interval
+func WithLoadBalancer(lb string, refresh time.Duration) Option {
+	return func(c *BuildCfg) {
+		c.startupCfg.Set("LoadBalancer", lb)
+		if refresh > 0 {
+			c.startupCfg.Set("ResolverRefresh", int((refresh+time.Second-1)/time.Second))
+		}
+	}
+}
+
+// WithClientCache reuses the gRPC client connections by service and peer for the
+// calls without dial options. The callers still close the connections, which
+// only releases the cached ones. The unused connections are closed after the idle
+// timeout
+func WithClientCache(idle time.Duration) Option {
+	return func(c *BuildCfg) {
+		c.startupCfg.Set("UseClientCache", true)
+		if idle > 0 {
+			c.startupCfg.Set("ClientIdleTimeout", int((idle+time.Second-1)/time.Second))
+		}
+	}
+}
+
 func WithDebug() Option {
 	return func(c *BuildCfg) {
 		c.startupCfg.Set("UseDebug", true)
 	}
 }
 
+// WithoutGRPCHealth does not register the standard gRPC health service, which is
+// registered by default. Apps registering their own health service should use
+// this
+func WithoutGRPCHealth() Option {
+	return func(c *BuildCfg) {
+		c.startupCfg.Set("DisableGRPCHealth", true)
+	}
+}
+
+// WithGRPCReflection registers gRPC server reflection. If HTTP is enabled, the
+// methods on the gRPC server along with the roles allowed are listed on
+// /grpc/methods
+func WithGRPCReflection() Option {
+	return func(c *BuildCfg) {
+		c.startupCfg.Set("UseReflection", true)
+	}
+}
+
+// WithAdmin enables the admin handlers on the HTTP server. These are used by the
+// msuite CLI to manage a running node. With auth enabled, the handlers require
+// the Admin role
+func WithAdmin() Option {
+	return func(c *BuildCfg) {
+		c.startupCfg.Set("UseAdmin", true)
+	}
+}
+
 func WithFiles() Option {
 	return func(c *BuildCfg) {
 		c.startupCfg.Set("UseFiles", true)
@@ -161,6 +295,47 @@ func WithBootstrapNodes(addrs ...string) Option {
 	}
 }
 
+// WithLogLevels sets the log levels of the subsystems. "*" can be used to set
+// level for all subsystems
+func WithLogLevels(levels map[string]string) Option {
+	return func(c *BuildCfg) {
+		c.startupCfg.Set("LogLevels", levels)
+	}
+}
+
+// WithConfigSources loads the config from the sources in order. Options provided
+// after this will override the values loaded from the sources
+func WithConfigSources(sources ...loader.Source) Option {
+	return func(c *BuildCfg) {
+		err := loader.Apply(c.startupCfg, sources...)
+		if err != nil {
+			c.errs = multierror.Append(c.errs, err)
+		}
+	}
+}
+
+// Settings is the typed configuration of the node. Settings can be read back
+// from a config using settings.FromConfig
+type Settings = settings.Settings
+
+// WithSettings applies the non-empty fields of the settings on the config.
+// Options provided after this will override these values
+func WithSettings(s Settings) Option {
+	return func(c *BuildCfg) {
+		s.Apply(c.startupCfg)
+	}
+}
+
+// WithFxOptions adds the fx options to the node. Apps can use this to provide
+// their own constructors and use the dependencies provided by the node. gRPC
+// interceptors and HTTP middlewares can be added using the helpers in the
+// grpcsvc and http packages of the node
+func WithFxOptions(opts ...fx.Option) Option {
+	return func(c *BuildCfg) {
+		c.fxOpts = append(c.fxOpts, opts...)
+	}
+}
+
 func defaultOpts(c *BuildCfg) {
 	if !c.startupCfg.Exists("Services") {
 		c.startupCfg.Set("Services", []string{"msuite"})
@@ -175,9 +350,13 @@ func New(opts ...Option) (core.Service, error) {
 		opt(bCfg)
 	}
 
+	if err := bCfg.errs.ErrorOrNil(); err != nil {
+		return nil, err
+	}
+
 	defaultOpts(bCfg)
 
-	svc, err := node.New(bCfg.startupCfg)
+	svc, err := node.New(bCfg.startupCfg, bCfg.fxOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -2,9 +2,22 @@ package msuite_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,8 +26,25 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/plexsysio/go-msuite"
 	"github.com/plexsysio/go-msuite/core"
+	"github.com/plexsysio/go-msuite/modules/auth"
+	"github.com/plexsysio/go-msuite/modules/config"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/diag/admin"
+	"github.com/plexsysio/go-msuite/modules/diag/status"
+	grpcsvc "github.com/plexsysio/go-msuite/modules/node/grpc"
+	mhttp "github.com/plexsysio/go-msuite/modules/node/http"
+	"github.com/plexsysio/go-msuite/modules/queue"
+	"github.com/plexsysio/go-msuite/modules/repo"
+	"github.com/plexsysio/go-msuite/modules/scheduler"
+	"go.uber.org/fx"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
 func TestMain(m *testing.M) {
@@ -58,6 +88,15 @@ func MustLocker(t *testing.T, m core.Service, exists bool) {
 	}
 }
 
+func MustElection(t *testing.T, m core.Service, exists bool) {
+	t.Helper()
+
+	_, err := m.Election("test")
+	if err == nil && !exists {
+		t.Fatal("Expected error accessing Election")
+	}
+}
+
 func MustEvents(t *testing.T, m core.Service, exists bool) {
 	t.Helper()
 
@@ -148,6 +187,7 @@ func TestBasicNew(t *testing.T) {
 	MustGRPC(t, app, false)
 	MustHTTP(t, app, false)
 	MustLocker(t, app, false)
+	MustElection(t, app, false)
 	MustEvents(t, app, false)
 	MustProtocols(t, app, false)
 	MustAuth(t, app, false)
@@ -167,6 +207,103 @@ func TestBasicNew(t *testing.T) {
 	}
 }
 
+func TestScheduler(t *testing.T) {
+	app, err := msuite.New()
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+
+	ran := make(chan struct{}, 1)
+	err = app.Scheduler().Every("job", 10*time.Millisecond, func(context.Context) error {
+		select {
+		case ran <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Failed scheduling job", err)
+	}
+	err = app.Scheduler().Every("singleton", time.Second, nil, scheduler.Singleton())
+	if !errors.Is(err, scheduler.ErrLockerRequired) {
+		t.Fatal("Expected singleton job to need locker", err)
+	}
+
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Scheduled job did not run")
+	}
+
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+}
+
+func TestQueuePersistence(t *testing.T) {
+	defer os.RemoveAll("tmpqueue")
+
+	app, err := msuite.New(msuite.WithRepositoryRoot("tmpqueue"))
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	q, err := app.Queue("emails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = q.Enqueue(context.Background(), []byte("hello"))
+	if err != nil {
+		t.Fatal("Failed enqueueing job", err)
+	}
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+
+	// The job is processed once the node is started again
+	app, err = msuite.New(msuite.WithRepositoryRoot("tmpqueue"))
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	q, err = app.Queue("emails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvd := make(chan string, 1)
+	err = q.Handle(func(_ context.Context, j *queue.Job) error {
+		recvd <- string(j.Payload)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-recvd:
+		if p != "hello" {
+			t.Fatal("incorrect job", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Job not processed after restart")
+	}
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+}
+
 func TestAuth(t *testing.T) {
 	app, err := msuite.New(
 		msuite.WithTaskManager(5, 100),
@@ -182,6 +319,7 @@ func TestAuth(t *testing.T) {
 	MustGRPC(t, app, false)
 	MustHTTP(t, app, false)
 	MustLocker(t, app, false)
+	MustElection(t, app, false)
 	MustEvents(t, app, false)
 	MustProtocols(t, app, false)
 	MustSharedStorage(t, app, false)
@@ -214,6 +352,7 @@ func TestP2P(t *testing.T) {
 	MustGRPC(t, app, false)
 	MustHTTP(t, app, false)
 	MustLocker(t, app, false)
+	MustElection(t, app, false)
 	MustAuth(t, app, false)
 	MustFiles(t, app, false)
 	MustTracing(t, app, false)
@@ -244,6 +383,7 @@ func TestHTTP(t *testing.T) {
 	MustP2P(t, app, false)
 	MustGRPC(t, app, false)
 	MustLocker(t, app, false)
+	MustElection(t, app, false)
 	MustEvents(t, app, false)
 	MustProtocols(t, app, false)
 	MustAuth(t, app, false)
@@ -258,6 +398,8 @@ func TestHTTP(t *testing.T) {
 	time.Sleep(time.Millisecond * 100)
 
 	checkHTMLOK(t, "http://localhost:10000/status")
+	checkHTMLOK(t, "http://localhost:10000/healthz")
+	checkHTMLOK(t, "http://localhost:10000/readyz")
 
 	err = app.Stop(context.Background())
 	if err != nil {
@@ -284,6 +426,7 @@ func TestGRPCLockerAuth(t *testing.T) {
 	MustP2P(t, app, true)
 	MustGRPC(t, app, true)
 	MustLocker(t, app, true)
+	MustElection(t, app, true)
 	MustEvents(t, app, true)
 	MustProtocols(t, app, true)
 	MustAuth(t, app, true)
@@ -297,6 +440,25 @@ func TestGRPCLockerAuth(t *testing.T) {
 	if err != nil {
 		t.Fatal("Failed starting app", err.Error())
 	}
+	el, err := app.Election("leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = el.Campaign(context.Background())
+	if err != nil {
+		t.Fatal("Failed campaigning", err)
+	}
+	l, err := el.Leader(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2p, err := app.P2P()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !el.IsLeader() || l.ID != p2p.Host().ID().Pretty() {
+		t.Fatal("expected node to be the leader", l)
+	}
 	time.Sleep(time.Millisecond * 100)
 	err = app.Stop(context.Background())
 	if err != nil {
@@ -340,6 +502,7 @@ func TestPrivateKey(t *testing.T) {
 	MustSharedStorage(t, app, true)
 	MustGRPC(t, app, false)
 	MustLocker(t, app, false)
+	MustElection(t, app, false)
 	MustAuth(t, app, false)
 	MustHTTP(t, app, false)
 	MustTracing(t, app, false)
@@ -393,6 +556,7 @@ func TestDiag(t *testing.T) {
 	MustP2P(t, app, false)
 	MustGRPC(t, app, false)
 	MustLocker(t, app, false)
+	MustElection(t, app, false)
 	MustEvents(t, app, false)
 	MustProtocols(t, app, false)
 	MustAuth(t, app, false)
@@ -440,6 +604,7 @@ func TestMultiClient(t *testing.T) {
 	MustAuth(t, app, false)
 	MustHTTP(t, app, false)
 	MustLocker(t, app, false)
+	MustElection(t, app, false)
 	MustTracing(t, app, false)
 	MustMetrics(t, app, false)
 
@@ -468,3 +633,827 @@ func TestMultiClient(t *testing.T) {
 		t.Fatal("Failed stopping app", err.Error())
 	}
 }
+
+func TestInvalidConfig(t *testing.T) {
+	_, err := msuite.New(
+		msuite.WithGRPC("p2p", nil),
+		msuite.WithHTTP(10000),
+		msuite.WithDebug(),
+		msuite.WithServiceACL(map[string]string{
+			"dummyresource": "invalid",
+		}),
+		msuite.WithClientPolicy("svc1", settings.ClientPolicy{Timeout: "1x"}),
+	)
+	if err == nil {
+		t.Fatal("expected error for invalid config")
+	}
+
+	for _, exp := range []string{
+		"UseP2PGRPC requires UseP2P to be enabled",
+		"ACL: invalid role",
+		"ClientPolicies: svc1: invalid Timeout",
+	} {
+		if !strings.Contains(err.Error(), exp) {
+			t.Fatalf("expected error %q in %v", exp, err)
+		}
+	}
+}
+
+func TestSettings(t *testing.T) {
+	app, err := msuite.New(
+		msuite.WithSettings(msuite.Settings{
+			Services: []string{"settingsSvc"},
+			HTTP:     settings.HTTP{Enabled: true, Port: 10001},
+			Locker:   settings.Locker{Enabled: true, Type: "inmem"},
+		}),
+	)
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+
+	MustHTTP(t, app, true)
+	MustLocker(t, app, true)
+	MustElection(t, app, true)
+	MustGRPC(t, app, false)
+
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	app, err := msuite.New(
+		msuite.WithHTTP(10002),
+		msuite.WithP2P(10003),
+		msuite.WithAdmin(),
+	)
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	p2p, err := app.P2P()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := admin.NewClient("localhost:10002", "")
+	info, err := a.ID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != p2p.Host().ID().Pretty() || len(info.Addresses) == 0 {
+		t.Fatal("incorrect ID info", info)
+	}
+	_, err = a.Peers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.SetConfig(context.Background(), "LogLevels", "*=info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !app.Repo().Config().Exists("LogLevels") {
+		t.Fatal("config not updated")
+	}
+
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+}
+
+type appDep struct {
+	svcs []string
+}
+
+func TestFxOptions(t *testing.T) {
+	var (
+		dep     *appDep
+		invoked bool
+	)
+	app, err := msuite.New(
+		msuite.WithHTTP(10004),
+		msuite.WithServices("fxSvc"),
+		msuite.WithFxOptions(
+			fx.Provide(func(c config.Config) *appDep {
+				var svcs []string
+				c.Get("Services", &svcs)
+				return &appDep{svcs: svcs}
+			}),
+			fx.Invoke(func(d *appDep, r repo.Repo) {
+				invoked = r != nil
+			}),
+			fx.Populate(&dep),
+			mhttp.HTTPMiddleware(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("X-App", "fxSvc")
+					next.ServeHTTP(w, r)
+				})
+			}),
+		),
+	)
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+	if !invoked || dep == nil || len(dep.svcs) != 1 || dep.svcs[0] != "fxSvc" {
+		t.Fatal("app options not used", dep)
+	}
+
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	resp, err := http.Get("http://localhost:10004/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-App") != "fxSvc" {
+		t.Fatal("middleware not added")
+	}
+
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+
+	// Missing dependencies should fail during creation
+	_, err = msuite.New(
+		msuite.WithFxOptions(fx.Invoke(func(*appDep) {})),
+	)
+	if err == nil {
+		t.Fatal("expected error for missing dependency")
+	}
+}
+
+type svcReporter struct {
+	ready bool
+}
+
+func (s *svcReporter) Status() interface{} { return s.ready }
+
+func (s *svcReporter) Ready() bool { return s.ready }
+
+func TestGRPCHealth(t *testing.T) {
+	app, err := msuite.New(
+		msuite.WithServices("healthySvc", "drainingSvc"),
+		msuite.WithGRPC("tcp", 10005),
+		msuite.WithFxOptions(
+			fx.Invoke(func(st status.Manager) {
+				st.AddReporter("drainingSvc", &svcReporter{ready: false})
+			}),
+		),
+	)
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	conn, err := grpc.Dial("localhost:10005", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	hc := healthpb.NewHealthClient(conn)
+	for svc, exp := range map[string]healthpb.HealthCheckResponse_ServingStatus{
+		"healthySvc":  healthpb.HealthCheckResponse_SERVING,
+		"drainingSvc": healthpb.HealthCheckResponse_NOT_SERVING,
+		"":            healthpb.HealthCheckResponse_NOT_SERVING,
+	} {
+		resp, err := hc.Check(context.Background(), &healthpb.HealthCheckRequest{Service: svc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != exp {
+			t.Fatalf("service %q expected %s found %s", svc, exp, resp.Status)
+		}
+	}
+
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+}
+
+func TestAppHealthService(t *testing.T) {
+	app, err := msuite.New(
+		msuite.WithoutGRPCHealth(),
+		msuite.WithServices("appSvc"),
+		msuite.WithGRPC("tcp", 10018),
+	)
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+
+	// The default health service is disabled, so the app can register its own
+	gsvc, err := app.GRPC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := health.NewServer()
+	hs.SetServingStatus("appSvc", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(gsvc.Server(), hs)
+
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	conn, err := grpc.Dial("localhost:10018", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "appSvc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatal("expected app health service to be used", resp.Status)
+	}
+
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+}
+
+func TestGRPCReflection(t *testing.T) {
+	app, err := msuite.New(
+		msuite.WithGRPC("tcp", 10006),
+		msuite.WithHTTP(10007),
+		msuite.WithGRPCReflection(),
+		msuite.WithAuth("dummysecret"),
+		msuite.WithServiceACL(map[string]string{
+			"/grpc.health.v1.Health/Watch": "admin",
+		}),
+	)
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	resp, err := http.Get("http://localhost:10007/grpc/methods")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var methods []grpcsvc.MethodInfo
+	err = json.NewDecoder(resp.Body).Decode(&methods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := map[string][]auth.Role{}
+	for _, m := range methods {
+		roles[m.FullMethod] = m.Roles
+	}
+	if r := roles["/grpc.health.v1.Health/Watch"]; len(r) != 1 || r[0] != auth.Admin {
+		t.Fatal("incorrect roles for protected method", r)
+	}
+	if r := roles["/grpc.health.v1.Health/Check"]; len(r) == 0 || r[0] != auth.None {
+		t.Fatal("incorrect roles for public method", r)
+	}
+
+	conn, err := grpc.Dial("localhost:10006", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refResp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, svc := range refResp.GetListServicesResponse().GetService() {
+		if svc.Name == "grpc.health.v1.Health" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("health service not listed by reflection", refResp)
+	}
+	_ = stream.CloseSend()
+
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+}
+
+func TestDrain(t *testing.T) {
+	app, err := msuite.New(
+		msuite.WithGRPC("tcp", 10008),
+		msuite.WithHTTP(10009),
+		msuite.WithDrain(time.Second, time.Second),
+	)
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	conn, err := grpc.Dial("localhost:10008", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The watch stream is pending till the drain timeout
+	watch, err := healthpb.NewHealthClient(conn).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := watch.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatal("expected serving before drain", resp.Status)
+	}
+
+	start := time.Now()
+	stopErr := make(chan error, 1)
+	go func() {
+		stopErr <- app.Stop(context.Background())
+	}()
+
+	resp, err = watch.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatal("expected not serving on drain", resp.Status)
+	}
+
+	// HTTP server is serving during the drain delay
+	httpResp, err := http.Get("http://localhost:10009/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatal("expected not ready while draining", httpResp.StatusCode)
+	}
+
+	err = <-stopErr
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Fatal("expected stop to wait for drain delay and timeout", elapsed)
+	}
+	_, err = watch.Recv()
+	if err == nil {
+		t.Fatal("expected watch stream to be closed")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	app, err := msuite.New(
+		msuite.WithGRPC("tcp", 10010),
+		msuite.WithHTTP(10011),
+		msuite.WithPrometheus(false),
+		msuite.WithRateLimit("health", "/grpc.health.v1.Health/*", settings.ByPeer, 0.1, 2),
+		msuite.WithRateLimit("status", "/status", settings.ByIP, 0.1, 1),
+	)
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	conn, err := grpc.Dial("localhost:10010", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	hc := healthpb.NewHealthClient(conn)
+	for i := 0; i < 2; i++ {
+		_, err := hc.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = hc.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if grpcstatus.Code(err) != codes.ResourceExhausted {
+		t.Fatal("expected resource exhausted", err)
+	}
+
+	for i, exp := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		resp, err := http.Get("http://localhost:10011/status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != exp {
+			t.Fatalf("request %d expected %d found %d", i, exp, resp.StatusCode)
+		}
+		if exp == http.StatusTooManyRequests && resp.Header.Get("Retry-After") != "10" {
+			t.Fatal("incorrect retry after", resp.Header.Get("Retry-After"))
+		}
+	}
+
+	resp, err := http.Get("http://localhost:10011/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	buf := new(strings.Builder)
+	if _, err := io.Copy(buf, resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `msuite_ratelimit_requests_total{result="limited",rule="health",transport="grpc"} 1`) {
+		t.Fatal("expected rate limit metrics", buf.String())
+	}
+
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+}
+
+func TestNameResolver(t *testing.T) {
+	app, err := msuite.New(
+		msuite.WithGRPC("tcp", 10012),
+		msuite.WithStaticDiscovery(map[string]string{"self": "127.0.0.1:10012"}),
+	)
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, "msuite:///self", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatal("incorrect status", resp.Status)
+	}
+	conn.Close()
+
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+
+	// The node is not used once stopped
+	_, err = grpc.Dial("msuite:///self", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err == nil {
+		t.Fatal("expected dial to fail after stop")
+	}
+}
+
+type testUser struct {
+	role string
+}
+
+func (u testUser) ID() string                   { return "test" }
+func (u testUser) Role() string                 { return u.role }
+func (u testUser) Mtdt() map[string]interface{} { return nil }
+
+func TestClientInterceptors(t *testing.T) {
+	app, err := msuite.New(
+		msuite.WithGRPC("unix", "/tmp/interceptors.sock"),
+		msuite.WithHTTP(10013),
+		msuite.WithPrometheus(false),
+		msuite.WithAuth("dummysecret"),
+		msuite.WithServiceRole("admin"),
+		msuite.WithServiceACL(map[string]string{
+			"/grpc.health.v1.Health/Check": "admin",
+		}),
+		msuite.WithStaticDiscovery(map[string]string{"self": "/tmp/interceptors.sock"}),
+	)
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	gsvc, err := app.GRPC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := gsvc.Client(context.Background(), "self", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hc := healthpb.NewHealthClient(conn)
+
+	// Calls without an incoming token use the service token
+	if _, err := hc.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The token of the incoming call is forwarded instead
+	ath, err := app.Auth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := ath.JWT().Generate(testUser{role: "public_read"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", token))
+	_, err = hc.Check(ctx, &healthpb.HealthCheckRequest{})
+	if grpcstatus.Code(err) != codes.PermissionDenied {
+		t.Fatal("expected incoming token to be forwarded", err)
+	}
+
+	resp, err := http.Get("http://localhost:10013/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	buf := new(strings.Builder)
+	if _, err := io.Copy(buf, resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `grpc_client_handled_total{grpc_code="OK",grpc_method="Check",grpc_service="grpc.health.v1.Health",grpc_type="unary"} 1`) {
+		t.Fatal("expected client metrics", buf.String())
+	}
+
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+}
+
+// writeSelfSigned writes a self-signed certificate for the localhost which is
+// also used as the CA
+func writeSelfSigned(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLS(t *testing.T) {
+	certFile, keyFile := writeSelfSigned(t, t.TempDir())
+
+	app, err := msuite.New(
+		msuite.WithGRPC("tcp", 10014),
+		msuite.WithHTTP(10015),
+		msuite.WithTLS(certFile, keyFile, certFile),
+		msuite.WithStaticDiscovery(map[string]string{"self": "127.0.0.1:10014"}),
+	)
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	// The static client dials using the node certificate
+	gsvc, err := app.GRPC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := gsvc.Client(context.Background(), "self", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Plaintext clients cannot connect
+	pconn, err := grpc.Dial("127.0.0.1:10014", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pconn.Close()
+	pctx, pcancel := context.WithTimeout(context.Background(), time.Second)
+	defer pcancel()
+	if _, err := healthpb.NewHealthClient(pconn).Check(pctx, &healthpb.HealthCheckRequest{}); err == nil {
+		t.Fatal("expected plaintext client to fail")
+	}
+
+	caPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		certs []tls.Certificate
+		fails bool
+	}{
+		{name: "without client certificate", fails: true},
+		{name: "with client certificate", certs: []tls.Certificate{cert}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: tc.certs},
+			}}
+			resp, err := client.Get("https://127.0.0.1:10015/status")
+			if tc.fails {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("expected request to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatal("incorrect status", resp.StatusCode)
+			}
+		})
+	}
+
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+}
+
+func TestTLSIdentity(t *testing.T) {
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPub, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := peer.IDFromPublicKey(otherPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := msuite.New(
+		msuite.WithP2PPrivateKey(sk),
+		msuite.WithGRPC("tcp", 10016),
+		msuite.WithHTTP(10017),
+		msuite.WithTLSIdentity(),
+		msuite.WithStaticDiscovery(map[string]string{
+			"self":    "127.0.0.1:10016/p2p/" + id.Pretty(),
+			"other":   "127.0.0.1:10016/p2p/" + other.Pretty(),
+			"unknown": "127.0.0.1:10016",
+		}),
+	)
+	if err != nil {
+		t.Fatal("Failed creating new msuite instance", err)
+	}
+
+	err = app.Start(context.Background())
+	if err != nil {
+		t.Fatal("Failed starting app", err.Error())
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	gsvc, err := app.GRPC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(svc string) error {
+		conn, err := gsvc.Client(context.Background(), svc, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+	if err := check("self"); err != nil {
+		t.Fatal(err)
+	}
+	if err := check("other"); err == nil {
+		t.Fatal("expected server with other peer ID to fail")
+	}
+	if err := check("unknown"); err == nil {
+		t.Fatal("expected address without peer ID to fail")
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err := client.Get("https://127.0.0.1:10017/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("incorrect status", resp.StatusCode)
+	}
+
+	err = app.Stop(context.Background())
+	if err != nil {
+		t.Fatal("Failed stopping app", err.Error())
+	}
+}
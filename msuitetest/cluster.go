@@ -0,0 +1,454 @@
+// Package msuitetest runs multiple msuite nodes in the same process for tests.
+// The nodes are connected on a libp2p mock network, so no sockets are used for
+// the P2P services, and the tests can partition and heal the links between the
+// nodes. The helpers wait for the connections and discovery to converge, which
+// keeps the tests fast and deterministic.
+package msuitetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/discovery"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/plexsysio/go-msuite"
+	"github.com/plexsysio/go-msuite/core"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	"github.com/plexsysio/go-msuite/modules/node/ipfs"
+	"go.uber.org/fx"
+)
+
+// pollInterval is the interval at which the wait helpers check for convergence
+var pollInterval = 100 * time.Millisecond
+
+// ErrNodeNotFound is returned if the node index is not part of the cluster
+var ErrNodeNotFound = errors.New("node not found")
+
+// transport creates the hosts of a node on the mock network
+type transport struct {
+	mn   mocknet.Mocknet
+	addr multiaddr.Multiaddr
+
+	mtx    sync.Mutex
+	main   host.Host
+	dialer host.Host
+}
+
+func (t *transport) NewHost(
+	ctx context.Context,
+	priv crypto.PrivKey,
+	_ settings.P2P,
+) (host.Host, routing.Routing, error) {
+	h, err := t.mn.AddPeer(priv, t.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := dht.New(ctx, h, dht.Mode(dht.ModeServer))
+	if err != nil {
+		return nil, nil, err
+	}
+	t.mtx.Lock()
+	t.main = h
+	t.mtx.Unlock()
+	return h, r, nil
+}
+
+func (t *transport) NewDialer() (host.Host, error) {
+	h, err := t.mn.GenPeer()
+	if err != nil {
+		return nil, err
+	}
+	t.mtx.Lock()
+	t.dialer = h
+	t.mtx.Unlock()
+	return h, nil
+}
+
+// hosts returns the hosts created for the node. The dialer is created only if
+// the node uses the P2P gRPC client
+func (t *transport) hosts() (host.Host, host.Host) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return t.main, t.dialer
+}
+
+type node struct {
+	svc     core.Service
+	tr      *transport
+	group   int
+	started bool
+}
+
+// Cluster is a set of msuite nodes on a libp2p mock network. All the nodes are
+// linked to each other unless the cluster is partitioned
+type Cluster struct {
+	mtx   sync.Mutex
+	mn    mocknet.Mocknet
+	nodes []*node
+}
+
+// New creates a cluster with n nodes using the same options. P2P is enabled on
+// all the nodes. The nodes are started using Start
+func New(n int, opts ...msuite.Option) (*Cluster, error) {
+	c := &Cluster{mn: mocknet.New()}
+	for i := 0; i < n; i++ {
+		if _, err := c.Add(opts...); err != nil {
+			_ = c.mn.Close()
+			return nil, fmt.Errorf("failed creating node %d: %w", i, err)
+		}
+	}
+	return c, nil
+}
+
+// Add creates a new node in the cluster. It is linked to the nodes in the first
+// group if the cluster is partitioned. The node is started on the next Start
+func (c *Cluster) Add(opts ...msuite.Option) (core.Service, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	idx := len(c.nodes)
+	addr, err := multiaddr.NewMultiaddr(
+		fmt.Sprintf("/ip4/127.0.%d.%d/tcp/4001", (idx+1)/256, (idx+1)%256),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tr := &transport{mn: c.mn, addr: addr}
+
+	// The swarm port is not used on the mock network
+	nodeOpts := []msuite.Option{msuite.WithP2P(4001)}
+	nodeOpts = append(nodeOpts, opts...)
+	nodeOpts = append(nodeOpts, msuite.WithFxOptions(
+		fx.Provide(func() ipfs.Transport { return tr }),
+	))
+
+	svc, err := msuite.New(nodeOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	nd := &node{svc: svc, tr: tr}
+	c.nodes = append(c.nodes, nd)
+	if err := c.link(); err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+// Nodes returns the nodes in the order they were added
+func (c *Cluster) Nodes() []core.Service {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	svcs := make([]core.Service, len(c.nodes))
+	for i, nd := range c.nodes {
+		svcs[i] = nd.svc
+	}
+	return svcs
+}
+
+// Node returns the node at the index
+func (c *Cluster) Node(i int) (core.Service, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if i < 0 || i >= len(c.nodes) {
+		return nil, ErrNodeNotFound
+	}
+	return c.nodes[i].svc, nil
+}
+
+// Host returns the main libp2p host of the node at the index
+func (c *Cluster) Host(i int) (host.Host, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if i < 0 || i >= len(c.nodes) {
+		return nil, ErrNodeNotFound
+	}
+	h, _ := c.nodes[i].tr.hosts()
+	return h, nil
+}
+
+// Start starts the nodes which are not running and connects all the linked nodes
+func (c *Cluster) Start(ctx context.Context) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for i, nd := range c.nodes {
+		if nd.started {
+			continue
+		}
+		if err := nd.svc.Start(ctx); err != nil {
+			return fmt.Errorf("failed starting node %d: %w", i, err)
+		}
+		nd.started = true
+	}
+	return c.connect()
+}
+
+// Stop stops all the running nodes and closes the mock network
+func (c *Cluster) Stop(ctx context.Context) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var err *multierror.Error
+	for i, nd := range c.nodes {
+		if !nd.started {
+			continue
+		}
+		if e := nd.svc.Stop(ctx); e != nil {
+			err = multierror.Append(err, fmt.Errorf("failed stopping node %d: %w", i, e))
+		}
+		nd.started = false
+	}
+	if e := c.mn.Close(); e != nil {
+		err = multierror.Append(err, e)
+	}
+	return err.ErrorOrNil()
+}
+
+// Partition splits the cluster into the groups of node indexes. The links and
+// connections between the nodes in different groups are removed. Nodes which are
+// not part of any group are isolated from all the other nodes
+func (c *Cluster) Partition(groups ...[]int) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	assigned := make(map[int]int)
+	for g, idxs := range groups {
+		for _, i := range idxs {
+			if i < 0 || i >= len(c.nodes) {
+				return ErrNodeNotFound
+			}
+			if _, found := assigned[i]; found {
+				return fmt.Errorf("node %d is part of multiple groups", i)
+			}
+			assigned[i] = g
+		}
+	}
+	next := len(groups)
+	for i, nd := range c.nodes {
+		if g, found := assigned[i]; found {
+			nd.group = g
+			continue
+		}
+		nd.group = next
+		next++
+	}
+	return c.link()
+}
+
+// Heal links all the nodes again and connects them
+func (c *Cluster) Heal() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, nd := range c.nodes {
+		nd.group = 0
+	}
+	if err := c.link(); err != nil {
+		return err
+	}
+	return c.connect()
+}
+
+// link updates the links on the mock network based on the groups. The dialer of
+// a node is linked to its own host and the hosts of the nodes in the same group
+func (c *Cluster) link() error {
+	for i, n1 := range c.nodes {
+		h1, d1 := n1.tr.hosts()
+		if h1 != nil && d1 != nil {
+			if err := c.setLink(d1.ID(), h1.ID(), true); err != nil {
+				return err
+			}
+		}
+		for _, n2 := range c.nodes[i+1:] {
+			h2, d2 := n2.tr.hosts()
+			linked := n1.group == n2.group
+			for _, pair := range [][2]host.Host{{h1, h2}, {d1, h2}, {h1, d2}} {
+				if pair[0] == nil || pair[1] == nil {
+					continue
+				}
+				if err := c.setLink(pair[0].ID(), pair[1].ID(), linked); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) setLink(p1, p2 peer.ID, linked bool) error {
+	exists := len(c.mn.LinksBetweenPeers(p1, p2)) > 0
+	switch {
+	case linked && !exists:
+		_, err := c.mn.LinkPeers(p1, p2)
+		return err
+	case !linked && exists:
+		if err := c.mn.UnlinkPeers(p1, p2); err != nil {
+			return err
+		}
+		return c.mn.DisconnectPeers(p1, p2)
+	}
+	return nil
+}
+
+// connect connects the hosts of the running nodes in the same group
+func (c *Cluster) connect() error {
+	for i, n1 := range c.nodes {
+		for _, n2 := range c.nodes[i+1:] {
+			if !n1.started || !n2.started || n1.group != n2.group {
+				continue
+			}
+			h1, _ := n1.tr.hosts()
+			h2, _ := n2.tr.hosts()
+			if h1.Network().Connectedness(h2.ID()) == network.Connected {
+				continue
+			}
+			if _, err := c.mn.ConnectPeers(h1.ID(), h2.ID()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// converged returns true if each running node is connected to all the running
+// nodes in its group and none of the others
+func (c *Cluster) converged() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for i, n1 := range c.nodes {
+		for _, n2 := range c.nodes[i+1:] {
+			if !n1.started || !n2.started {
+				continue
+			}
+			h1, _ := n1.tr.hosts()
+			h2, _ := n2.tr.hosts()
+			connected := h1.Network().Connectedness(h2.ID()) == network.Connected
+			if connected != (n1.group == n2.group) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// WaitConnected waits until each running node is connected to all the nodes in
+// its group and disconnected from the nodes in the other groups
+func (c *Cluster) WaitConnected(ctx context.Context) error {
+	return wait(ctx, c.converged)
+}
+
+// WaitDiscovery waits until each running node finds all the other nodes in its
+// group which provide the service. The service is advertised again from these
+// nodes till then, as the advertisements could have failed before the nodes
+// were connected
+func (c *Cluster) WaitDiscovery(ctx context.Context, svc string) error {
+	return wait(ctx, func() bool {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+
+		providers := make(map[int]bool)
+		for i, nd := range c.nodes {
+			if nd.started && provides(nd.svc, svc) {
+				providers[i] = true
+				if d, err := p2pDiscovery(nd.svc); err == nil {
+					_, _ = d.Advertise(ctx, svc)
+				}
+			}
+		}
+		for i, nd := range c.nodes {
+			if !nd.started {
+				continue
+			}
+			d, err := p2pDiscovery(nd.svc)
+			if err != nil {
+				return false
+			}
+			found, err := findPeers(ctx, d, svc)
+			if err != nil {
+				return false
+			}
+			for j := range providers {
+				if j == i || c.nodes[j].group != nd.group {
+					continue
+				}
+				h, _ := c.nodes[j].tr.hosts()
+				if !found[h.ID()] {
+					return false
+				}
+			}
+		}
+		return true
+	})
+}
+
+// provides returns true if the service is served on P2P gRPC by the node
+func provides(svc core.Service, name string) bool {
+	c := svc.Repo().Config()
+	if !c.IsSet("UseP2PGRPC") {
+		return false
+	}
+	var services []string
+	_ = c.Get("Services", &services)
+	for _, s := range services {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func p2pDiscovery(svc core.Service) (discovery.Discovery, error) {
+	p2p, err := svc.P2P()
+	if err != nil {
+		return nil, err
+	}
+	return p2p.Discovery(), nil
+}
+
+func findPeers(ctx context.Context, d discovery.Discovery, svc string) (map[peer.ID]bool, error) {
+	fCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	peers, err := d.FindPeers(fCtx, svc)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[peer.ID]bool)
+	for p := range peers {
+		found[p.ID] = true
+	}
+	return found, nil
+}
+
+func wait(ctx context.Context, done func() bool) error {
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+
+	for {
+		if done() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
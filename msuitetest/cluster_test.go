@@ -0,0 +1,165 @@
+package msuitetest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/plexsysio/go-msuite"
+	"github.com/plexsysio/go-msuite/modules/config/settings"
+	grpcclient "github.com/plexsysio/go-msuite/modules/grpc/client"
+	"github.com/plexsysio/go-msuite/msuitetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestCluster(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	c, err := msuitetest.New(
+		3,
+		msuite.WithServices("svc"),
+		msuite.WithGRPC("p2p", nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Add(
+		msuite.WithServices("client"),
+		msuite.WithGRPC("p2p", nil),
+		msuite.WithLoadBalancer(settings.RoundRobin, 0),
+		msuite.WithClientCache(0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := c.Stop(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if len(c.Nodes()) != 4 {
+		t.Fatal("incorrect no of nodes", len(c.Nodes()))
+	}
+	if _, err := c.Node(4); err != msuitetest.ErrNodeNotFound {
+		t.Fatal("expected node not found", err)
+	}
+
+	err = c.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.WaitConnected(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.WaitDiscovery(ctx, "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := c.Node(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gsvc, err := client.GRPC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := gsvc.Client(ctx, "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The connection is cached, closing it only releases it
+	cached, err := gsvc.Client(ctx, "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached.Close()
+	if cached.ClientConn != conn.ClientConn {
+		t.Fatal("expected cached connection")
+	}
+	// The connections with dial options are not shared
+	oconn, err := gsvc.Client(ctx, "svc", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oconn.Close()
+	if oconn.ClientConn == conn.ClientConn {
+		t.Fatal("expected new connection with dial options")
+	}
+
+	// The calls are balanced across the nodes providing the service
+	for i := 0; i < 3; i++ {
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: "svc"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Fatal("incorrect status", resp.Status)
+		}
+	}
+
+	// Plain gRPC dials resolve the services using the node
+	h3, err := c.Host(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nconn, err := grpc.DialContext(
+		ctx,
+		"msuite://"+h3.ID().Pretty()+"/svc",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpcclient.NameDialer(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nconn.Close()
+	resp, err := healthpb.NewHealthClient(nconn).Check(ctx, &healthpb.HealthCheckRequest{Service: "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatal("incorrect status", resp.Status)
+	}
+
+	err = c.Partition([]int{0, 1}, []int{2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.WaitConnected(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h0, err := c.Host(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := c.Host(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h0.Network().Connectedness(h2.ID()) == network.Connected {
+		t.Fatal("partitioned nodes are connected")
+	}
+	if err := h0.Connect(ctx, h2.Peerstore().PeerInfo(h2.ID())); err == nil {
+		t.Fatal("expected connection across partition to fail")
+	}
+
+	err = c.Heal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.WaitConnected(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h0.Network().Connectedness(h2.ID()) != network.Connected {
+		t.Fatal("healed nodes are not connected")
+	}
+}
@@ -0,0 +1,10 @@
+// Package all registers all the optional implementations provided by msuite.
+// The implementations are not linked into the binary unless their packages are
+// imported, so apps can import this package to have all of them available or
+// import only the ones required
+package all
+
+import (
+	_ "github.com/plexsysio/go-msuite/modules/node/locker/redis"
+	_ "github.com/plexsysio/go-msuite/modules/node/locker/zookeeper"
+)
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry holds the implementations of a subsystem by name. Implementations
+// register themselves from their own packages, usually in init(), so only the
+// ones linked into the binary are available
+type Registry struct {
+	kind  string
+	mtx   sync.RWMutex
+	items map[string]interface{}
+	pkgs  map[string]string
+}
+
+// NewRegistry returns a registry for the kind of implementations. The kind is
+// used in the errors
+func NewRegistry(kind string) *Registry {
+	return &Registry{
+		kind:  kind,
+		items: make(map[string]interface{}),
+		pkgs:  make(map[string]string),
+	}
+}
+
+// Known records the package which registers the implementation with the name.
+// If the package is not imported, the error for the name tells which package to
+// import instead of only listing the available ones
+func (r *Registry) Known(name, pkg string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.pkgs[name] = pkg
+}
+
+// Register adds the implementation. It panics if the name is already used, as
+// this is a programming error
+func (r *Registry) Register(name string, impl interface{}) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, found := r.items[name]; found {
+		panic(fmt.Sprintf("%s %q registered twice", r.kind, name))
+	}
+	r.items[name] = impl
+}
+
+// Get returns the implementation registered with the name. The error lists the
+// available implementations if the name is not registered, or the package to
+// import if the name is known
+func (r *Registry) Get(name string) (interface{}, error) {
+	r.mtx.RLock()
+	impl, found := r.items[name]
+	pkg, known := r.pkgs[name]
+	r.mtx.RUnlock()
+
+	if !found {
+		if known {
+			return nil, fmt.Errorf(
+				"%s %q not registered, import _ %q to use it",
+				r.kind, name, pkg,
+			)
+		}
+		return nil, fmt.Errorf("unknown %s %q, available %v", r.kind, name, r.Names())
+	}
+	return impl, nil
+}
+
+// Names returns the sorted names of the registered implementations
+func (r *Registry) Names() []string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	names := make([]string, 0, len(r.items))
+	for k := range r.items {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}